@@ -0,0 +1,176 @@
+package neffos
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Principal describes the authenticated identity of a connection,
+// it's the result of the `Server.Authenticate` and it's available through the `Conn.Principal`.
+type Principal interface {
+	// Subject returns the unique identifier of the authenticated identity, i.e. a user ID.
+	Subject() string
+}
+
+// BasicPrincipal is a simple `Principal` implementation,
+// custom types can be used through the `PrincipalOf` as well.
+type BasicPrincipal struct {
+	ID     string
+	Roles  []string
+	Claims map[string]interface{}
+}
+
+// Subject returns the ID of the principal.
+func (p *BasicPrincipal) Subject() string {
+	return p.ID
+}
+
+// HasRole reports whether the principal has the "role", see `RequireRoles`.
+func (p *BasicPrincipal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	// ErrUnauthorized can be returned from the `Server.Authenticate`
+	// to reject a connection with the 401 Unauthorized status code.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is the error which the remote side's `Connect` returns
+	// when a `Server.NamespaceAuthorizers` predicate rejected the namespace connection.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Authorizer reports whether a connection of the "principal" is allowed to connect to a namespace,
+// see `Server.NamespaceAuthorizers`. The "principal" is nil for anonymous connections.
+type Authorizer func(c *Conn, principal Principal) bool
+
+// RequireAuthenticated is an `Authorizer` which rejects the anonymous connections.
+func RequireAuthenticated(c *Conn, principal Principal) bool {
+	return principal != nil
+}
+
+// RequireRoles returns an `Authorizer` which allows only the principals with at least one of the "roles".
+// The principal should complete the `HasRole(role string) bool` method, as the `BasicPrincipal` does.
+func RequireRoles(roles ...string) Authorizer {
+	return func(c *Conn, principal Principal) bool {
+		p, ok := principal.(interface{ HasRole(role string) bool })
+		if !ok {
+			return false
+		}
+
+		for _, role := range roles {
+			if p.HasRole(role) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Principal returns the authenticated identity of this connection, see `Server.Authenticate`.
+// It returns nil for anonymous and client-side connections.
+func (c *Conn) Principal() Principal {
+	return c.principal
+}
+
+// PrincipalOf returns the `Conn.Principal` as a value of "T",
+// it reports false if the connection is anonymous or its principal is not a "T".
+func PrincipalOf[T Principal](c *Conn) (T, bool) {
+	p, ok := c.Principal().(T)
+	return p, ok
+}
+
+// authorize reports whether the connection is allowed to connect to the "namespace".
+func (c *Conn) authorize(namespace string) bool {
+	if c.server == nil {
+		return true
+	}
+
+	authorizer, ok := c.server.NamespaceAuthorizers[namespace]
+	if !ok || authorizer == nil {
+		return true
+	}
+
+	return authorizer(c, c.principal)
+}
+
+// TokenExtractor extracts a credential, i.e. a token, from the handshake request.
+// It returns empty if the credential is missing. See `Server.Authenticate`.
+type TokenExtractor func(r *http.Request) string
+
+// BearerToken is a `TokenExtractor` which extracts the token of the "Authorization: Bearer <token>" header.
+// The browser clients, which can't set custom headers, can send it as the
+// "X-Websocket-Header-Authorization" URL parameter instead, see `URLParamAsHeaderPrefix`.
+func BearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(auth[len(prefix):])
+}
+
+// HeaderToken returns a `TokenExtractor` which extracts the value of the "name" header,
+// the `URLParamAsHeaderPrefix` URL parameters are parsed as headers too.
+func HeaderToken(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// CookieToken returns a `TokenExtractor` which extracts the value of the "name" cookie.
+func CookieToken(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		cookie, err := r.Cookie(name)
+		if err != nil {
+			return ""
+		}
+
+		return cookie.Value
+	}
+}
+
+// QueryToken returns a `TokenExtractor` which extracts the value of the "name" URL parameter.
+func QueryToken(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return r.URL.Query().Get(name)
+	}
+}
+
+// FirstToken returns a `TokenExtractor` which returns the first non-empty credential of the "extractors".
+func FirstToken(extractors ...TokenExtractor) TokenExtractor {
+	return func(r *http.Request) string {
+		for _, extract := range extractors {
+			if token := extract(r); token != "" {
+				return token
+			}
+		}
+
+		return ""
+	}
+}
+
+// authenticate runs the `Server.Authenticate`, if any,
+// on failure it writes the 401 Unauthorized status code.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (Principal, error) {
+	if s.Authenticate == nil {
+		return nil, nil
+	}
+
+	principal, err := s.Authenticate(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil, err
+	}
+
+	return principal, nil
+}
@@ -14,6 +14,8 @@ type Client struct {
 
 	// ID comes from server, local changes are not reflected,
 	// use the `Server#IDGenerator` if you want to set a custom logic for ID set.
+	// It's the ID of the first connection, see `GetID` for the current one
+	// when the client reconnects.
 	ID string
 
 	// NotifyClose can be optionally registered to notify about the client's disconnect.
@@ -22,7 +24,82 @@ type Client struct {
 	// Don't confuse it with the `OnNamespaceDisconnect` event.
 	// Usage:
 	// <- client.NotifyClose // blocks until local `Close` or remote close of connection.
+	//
+	// When `Reconnect` is set, it is notified only after all reconnection attempts failed.
 	NotifyClose <-chan struct{}
+
+	// Reconnect can be optionally set to re-dial the server when the underline
+	// connection is lost (e.g. remote close or network failure), instead of closing the client.
+	// On successful reconnection the previously connected namespaces
+	// and joined rooms are connected and joined again, the `NSConn` and `Room` values
+	// are kept as they are. A local `Close` call never triggers a reconnection.
+	// A server which shuts down may redirect the reconnection to another URL, see `Server.ReconnectHint`.
+	//
+	// Prefer to set it through a `ClientOption` on `Dial`.
+	// Defaults to nil, no reconnection.
+	Reconnect *ReconnectPolicy
+	// OnReconnecting can be optionally registered to be notified
+	// before each reconnection attempt. The "err" is the error which caused
+	// the connection loss or the previous attempt's failure.
+	OnReconnecting func(attempt int, err error)
+	// OnReconnected can be optionally registered to be notified
+	// when the client is reconnected and its namespaces and rooms are restored.
+	OnReconnected func(attempt int)
+
+	// BinaryProtocol, if true, asks the server to use the compact binary wire protocol
+	// instead of the text one, on the acknowledgement process.
+	// Servers that do not support it, keep using the text protocol.
+	// It must be set before `Dial` returns, see `WithBinaryProtocol`.
+	BinaryProtocol bool
+
+	// OutboxSize is the maximum number of unacknowledged messages, sent with at-least-once delivery,
+	// that the client retains. See `Conn.WriteReliable`.
+	// Defaults to `DefaultOutboxSize`.
+	OutboxSize int
+
+	// Codec is the `Codec` of the client's namespaces, see `EmitTyped` and `OnTyped`.
+	// Defaults to nil, the `DefaultCodec` is used instead.
+	Codec Codec
+	// NamespaceCodecs can be optionally set to use a different `Codec`
+	// for specific namespaces, it has priority over the `Codec` field.
+	NamespaceCodecs map[string]Codec
+
+	// Compression can be optionally set to negotiate the per-message compression (permessage-deflate)
+	// with the server, see `Compression` and `Message.Compress`.
+	// It must be set through the `WithCompression` option on `Dial`.
+	// Defaults to nil, the messages are not compressed.
+	Compression *Compression
+
+	// Heartbeat can be optionally set to ping the server periodically,
+	// measure the latency (see `Conn.Latency`) and detect a dead connection.
+	// It must be set through the `WithHeartbeat` option on `Dial`.
+	// Defaults to nil, no pings are sent.
+	Heartbeat *Heartbeat
+
+	// Tracer can be optionally registered to trace the messages
+	// sent to and received from the server, see `WithTracer`.
+	Tracer Tracer
+
+	dial Dialer
+	url  string
+}
+
+// GetID returns the current ID of the client, the server may give a new ID
+// to the client on each reconnection, see `Reconnect`.
+// It's safe for concurrent use.
+func (c *Client) GetID() string {
+	return c.conn.ID()
+}
+
+// ClientOption can be passed on `Dial` to configure a `Client`
+// before its connection is established.
+type ClientOption func(*Client)
+
+// WithReconnect is a `ClientOption` which sets the `Client.Reconnect` policy.
+func WithReconnect(policy ReconnectPolicy) ClientOption {
+	return func(c *Client) {
+		c.Reconnect = &policy
+	}
 }
 
 // Close method terminates the client-side connection.
@@ -53,6 +130,12 @@ func (c *Client) Connect(ctx context.Context, namespace string) (*NSConn, error)
 	return c.conn.Connect(ctx, namespace)
 }
 
+// ConnectWithHeader acts like `Connect` but it sends the "header" along with the namespace connect message.
+// See `Conn#ConnectWithHeader` for more details.
+func (c *Client) ConnectWithHeader(ctx context.Context, namespace string, header map[string]string) (*NSConn, error) {
+	return c.conn.ConnectWithHeader(ctx, namespace, header)
+}
+
 // Dialer is the definition type of a dialer, gorilla or gobwas or custom.
 // It is the second parameter of the `Dial` function.
 type Dialer func(ctx context.Context, url string) (Socket, error)
@@ -62,11 +145,12 @@ type Dialer func(ctx context.Context, url string) (Socket, error)
 // Dialer "dial" can be either `gobwas.Dialer/DefaultDialer` or `gorilla.Dialer/DefaultDialer`,
 // custom dialers can be used as well when complete the `Socket` and `Dialer` interfaces for valid client.
 // URL "url" is the endpoint of the neffos server, i.e "ws://localhost:8080/echo".
-// The last parameter, and the most important one is the "connHandler", it can be
+// The "connHandler" is the most important one, it can be
 // filled as `Namespaces`, `Events` or `WithTimeout`, same namespaces and events can be used on the server-side as well.
+// The last, optional, variadic parameter accepts `ClientOption`s to customize the `Client`, e.g. `WithReconnect`.
 //
 // See examples for more.
-func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler) (*Client, error) {
+func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler, options ...ClientOption) (*Client, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
@@ -75,7 +159,19 @@ func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler)
 		url = "ws://" + url
 	}
 
-	underline, err := dial(ctx, url)
+	client := &Client{
+		dial: dial,
+		url:  url,
+	}
+
+	// options are applied before dial, the dialer may need them, i.e. the `Client.Compression`.
+	for _, opt := range options {
+		if opt != nil {
+			opt(client)
+		}
+	}
+
+	underline, err := dial(contextWithCompression(ctx, client.Compression), url)
 	if err != nil {
 		return nil, err
 	}
@@ -84,16 +180,25 @@ func Dial(ctx context.Context, dial Dialer, url string, connHandler ConnHandler)
 		connHandler = Namespaces{}
 	}
 
-	c := newConn(underline, connHandler.GetNamespaces())
+	c := newConn(context.Background(), underline, connHandler.GetNamespaces())
 	readTimeout, writeTimeout := getTimeouts(connHandler)
 	c.readTimeout = readTimeout
 	c.writeTimeout = writeTimeout
+	c.compression = client.Compression
 
+	client.conn = c
+	client.NotifyClose = c.closeCh
+	c.client = client
+	if client.Heartbeat != nil {
+		c.startHeartbeat(client.Heartbeat)
+	}
 	go c.startReader()
 
 	if err = c.sendClientACK(); err != nil {
 		return nil, err
 	}
 
-	return &Client{conn: c, ID: c.id, NotifyClose: c.closeCh}, nil
+	client.ID = c.ID()
+
+	return client, nil
 }
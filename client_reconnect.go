@@ -0,0 +1,232 @@
+package neffos
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ReconnectPolicy describes how a `Client` re-dials the server
+// when its connection is lost. See `Client.Reconnect` and `WithReconnect`.
+//
+// The wait time before each attempt grows exponentially:
+// Delay * Multiplier^(attempt-1), limited by MaxDelay and randomized by Jitter.
+type ReconnectPolicy struct {
+	// MaxAttempts is the maximum number of reconnection attempts
+	// before the client is closed for good.
+	// Defaults to 0, unlimited attempts.
+	MaxAttempts int
+	// Delay is the wait time before the first attempt.
+	// Defaults to 500 milliseconds.
+	Delay time.Duration
+	// MaxDelay is the maximum wait time between attempts.
+	// Defaults to 30 seconds.
+	MaxDelay time.Duration
+	// Multiplier is the factor which the wait time is multiplied by on each attempt.
+	// Defaults to 2.
+	Multiplier float64
+	// Jitter randomizes the wait time by the given fraction (0 to 1),
+	// e.g. 0.2 means plus or minus 20 percent.
+	// Helps to not overload the server when many clients reconnect at the same time.
+	// Values out of that range are clamped to it.
+	// Defaults to 0, no randomization.
+	Jitter float64
+	// Timeout is the maximum time allowed to dial and
+	// to restore the namespaces and rooms of a reconnection.
+	// Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// backoff returns the wait time before the given "attempt".
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	delay, maxDelay, multiplier := p.Delay, p.MaxDelay, p.Multiplier
+	if delay <= 0 {
+		delay = 500 * time.Millisecond
+	}
+
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	d := float64(delay) * math.Pow(multiplier, float64(attempt-1))
+	if d > float64(maxDelay) {
+		d = float64(maxDelay)
+	}
+
+	if jitter := math.Min(p.Jitter, 1); jitter > 0 {
+		d += d * jitter * (rand.Float64()*2 - 1)
+	}
+
+	return time.Duration(d)
+}
+
+func (p *ReconnectPolicy) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return maxSyncWaitDur
+	}
+
+	return p.Timeout
+}
+
+// canReconnect reports whether this is a client-side connection
+// which should re-dial instead of being closed on a connection loss.
+func (c *Conn) canReconnect() bool {
+	return c.client != nil && c.client.Reconnect != nil
+}
+
+// reconnect is called by the reader when reading failed,
+// it reports whether a new underline socket took the place of the broken one.
+// Connections that never completed their first acknowledgement (see `Dial`) are not reconnected.
+func (c *Conn) reconnect(err error) bool {
+	if c.IsClosed() || !c.canReconnect() || !c.readiness.isReady() || c.readiness.err != nil {
+		return false
+	}
+
+	return c.client.reconnect(err)
+}
+
+func (c *Client) reconnect(err error) bool {
+	policy := c.Reconnect
+	conn := c.conn
+
+	// not acknowledged until the new server-side connection replies with its ID,
+	// see `Conn#handleACK` and `Client#restore`.
+	atomic.StoreUint32(conn.acknowledged, 0)
+
+	if hint := reconnectHint(err); hint != "" {
+		// the server is going away, see `Server.ReconnectHint`.
+		c.url = hint
+	}
+
+	for attempt := 1; policy.MaxAttempts <= 0 || attempt <= policy.MaxAttempts; attempt++ {
+		if c.OnReconnecting != nil {
+			c.OnReconnecting(attempt, err)
+		}
+
+		select {
+		case <-conn.closeCh:
+			return false
+		case <-time.After(policy.backoff(attempt)):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), policy.timeout())
+		var socket Socket
+		socket, err = c.dial(contextWithCompression(ctx, c.Compression), withReconnectTries(c.url, attempt))
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		conn.socketMutex.Lock()
+		conn.socket.NetConn().Close()
+		conn.socket = socket
+		conn.socketMutex.Unlock()
+		conn.watchPongs()
+
+		if conn.IsClosed() {
+			// closed manually while dialing.
+			socket.NetConn().Close()
+			return false
+		}
+
+		conn.ReconnectTries = attempt
+		// protocols are negotiated again.
+		conn.resetProtocols()
+		if !conn.write(conn.ackMessage(), false) {
+			err = ErrWrite
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// reconnectHint returns the websocket URL of a `CloseGoingAway` close error's reason, if any.
+func reconnectHint(err error) string {
+	closeErr, ok := err.(CloseError)
+	if !ok || closeErr.Code != CloseGoingAway {
+		return ""
+	}
+
+	u, parseErr := url.Parse(closeErr.Reason())
+	if parseErr != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+		return ""
+	}
+
+	return u.String()
+}
+
+// withReconnectTries appends the reconnect header, as url parameter, to the "url".
+// See `Server#Upgrade` and `URLParamAsHeaderPrefix`.
+func withReconnectTries(url string, tries int) string {
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+
+	return url + sep + URLParamAsHeaderPrefix + websocketReconectHeaderKey + "=" + strconv.Itoa(tries)
+}
+
+// restore connects to the previously connected namespaces and
+// joins the previously joined rooms after a successful reconnection,
+// it's called once the new connection is acknowledged.
+// Namespaces and rooms that the server does not accept anymore are force-disconnected and force-left locally.
+func (c *Client) restore() {
+	conn := c.conn
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Reconnect.timeout())
+	defer cancel()
+
+	conn.connectedNamespacesMutex.RLock()
+	namespaces := make([]*NSConn, 0, len(conn.connectedNamespaces))
+	for _, ns := range conn.connectedNamespaces {
+		namespaces = append(namespaces, ns)
+	}
+	conn.connectedNamespacesMutex.RUnlock()
+
+	for _, ns := range namespaces {
+		_, err := conn.ask(ctx, Message{Namespace: ns.namespace, Event: OnNamespaceConnect, Header: ns.connectHeader}, false)
+		if err != nil {
+			ns.forceLeaveAll(true)
+
+			conn.connectedNamespacesMutex.Lock()
+			delete(conn.connectedNamespaces, ns.namespace)
+			conn.connectedNamespacesMutex.Unlock()
+
+			ns.events.fireEvent(ns, Message{Namespace: ns.namespace, Event: OnNamespaceDisconnect, IsForced: true, IsLocal: true})
+			continue
+		}
+
+		for _, room := range ns.Rooms() {
+			joinMsg := Message{Namespace: ns.namespace, Room: room.Name, Event: OnRoomJoin}
+			if _, err = conn.ask(ctx, joinMsg, false); err != nil {
+				leaveMsg := Message{Namespace: ns.namespace, Room: room.Name, Event: OnRoomLeave, IsForced: true, IsLocal: true}
+				ns.events.fireEvent(ns, leaveMsg)
+
+				ns.roomsMutex.Lock()
+				delete(ns.rooms, room.Name)
+				ns.roomsMutex.Unlock()
+
+				leaveMsg.Event = OnRoomLeft
+				ns.events.fireEvent(ns, leaveMsg)
+			}
+		}
+
+		conn.replayDeliveries(ns.namespace)
+	}
+
+	if c.OnReconnected != nil {
+		c.OnReconnected(conn.ReconnectTries)
+	}
+}
@@ -1,8 +1,11 @@
 package neffos_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"testing"
+	"time"
 
 	"github.com/kataras/neffos"
 
@@ -35,3 +38,124 @@ func runTestClient(addr string, connHandler neffos.ConnHandler, testFn func(stri
 	testFn("gorilla", gorillaClient)
 	return teardown
 }
+
+func TestClientReconnect(t *testing.T) {
+	var (
+		namespace = "default"
+		roomName  = "room1"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"close": func(c *neffos.NSConn, msg neffos.Message) error {
+					c.Conn.Close()
+					return nil
+				},
+				"room": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Room(msg.Room) == nil {
+						return neffos.ErrBadRoom
+					}
+
+					if !c.Conn.WasReconnected() {
+						return fmt.Errorf("expected a reconnected connection")
+					}
+
+					return neffos.Reply([]byte(c.Conn.ID()))
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		reconnected := make(chan int, 1)
+		client.Reconnect = &neffos.ReconnectPolicy{MaxAttempts: 3, Delay: 50 * time.Millisecond}
+		client.OnReconnected = func(attempt int) {
+			reconnected <- attempt
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.JoinRoom(context.TODO(), roomName); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("close", nil)
+
+		select {
+		case attempt := <-reconnected:
+			if attempt != 1 {
+				t.Fatalf("[%s] expected to be reconnected on first attempt but got: %d", dialer, attempt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[%s] client did not reconnect", dialer)
+		}
+
+		if c.Room(roomName) == nil {
+			t.Fatalf("[%s] expected room to be kept after reconnection", dialer)
+		}
+
+		msg, err := c.Conn.Ask(context.TODO(), neffos.Message{Namespace: namespace, Room: roomName, Event: "room"})
+		if err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+
+		if expected, got := string(msg.Body), client.GetID(); expected != got {
+			t.Fatalf("[%s] expected client ID to be updated to: %s but got: %s", dialer, expected, got)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientBinaryProtocol(t *testing.T) {
+	var (
+		namespace = "default"
+		body      = []byte("a body with ; delimeters")
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer, events, neffos.WithBinaryProtocol())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			msg, err := c.Ask(context.TODO(), "echo", body)
+			if err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+
+			if !bytes.Equal(msg.Body, body) || msg.Event != "echo" || msg.Namespace != namespace {
+				t.Fatalf("[%s] unexpected reply: %#+v", dialer, msg)
+			}
+		}
+
+		if err = c.Disconnect(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+	}
+}
@@ -0,0 +1,222 @@
+package neffos
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Codec encodes and decodes the typed payloads of the messages, the `Message.Body`.
+// A codec can be set per `Server` (`Server.Codec`), per `Client` (`Client.Codec`)
+// and per namespace (`Server.NamespaceCodecs` and `Client.NamespaceCodecs`),
+// it's used by the `EmitTyped` and `OnTyped` helpers and the `NSConn.Codec` method.
+//
+// Built-in implementations are the `JSONCodec` and the `DefaultCodec`,
+// see the codec/protobuf, codec/msgpack and codec/cbor subpackages for more.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(body []byte, outPtr interface{}) error
+}
+
+// BinaryCodec is an optional interface which a `Codec` can implement
+// to report that its encoded payloads are binary data and not UTF-8 text,
+// `EmitTyped` sends those as binary websocket messages, see `NSConn.EmitBinary`.
+type BinaryCodec interface {
+	Binary() bool
+}
+
+var (
+	_ Codec = JSONCodec{}
+	_ Codec = defaultCodec{}
+)
+
+// DefaultCodec is the codec which is used when no codec is set to a server, client or namespace.
+// It acts like the package-level `Marshal` function and `Message.Unmarshal` method,
+// it respects the `MessageObjectMarshaler`, `MessageObjectUnmarshaler`,
+// `DefaultMarshaler` and `DefaultUnmarshaler`.
+var DefaultCodec Codec = defaultCodec{}
+
+type defaultCodec struct{}
+
+func (defaultCodec) Marshal(v interface{}) ([]byte, error) {
+	if marshaler, ok := v.(MessageObjectMarshaler); ok {
+		return marshaler.Marshal()
+	}
+
+	return DefaultMarshaler(v)
+}
+
+func (defaultCodec) Unmarshal(body []byte, outPtr interface{}) error {
+	if unmarshaler, ok := outPtr.(MessageObjectUnmarshaler); ok {
+		return unmarshaler.Unmarshal(body)
+	}
+
+	return DefaultUnmarshaler(body, outPtr)
+}
+
+// JSONCodec is a `Codec` which encodes and decodes payloads using the standard encoding/json package.
+type JSONCodec struct{}
+
+// Marshal returns the JSON encoding of "v".
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the JSON "body" to the "outPtr".
+func (JSONCodec) Unmarshal(body []byte, outPtr interface{}) error {
+	return json.Unmarshal(body, outPtr)
+}
+
+// codec returns the codec of a "namespace",
+// the namespace's codec has priority over the server's or client's one.
+func (c *Conn) codec(namespace string) Codec {
+	var (
+		codec  Codec
+		codecs map[string]Codec
+	)
+
+	if c.server != nil {
+		codec, codecs = c.server.Codec, c.server.NamespaceCodecs
+	} else if c.client != nil {
+		codec, codecs = c.client.Codec, c.client.NamespaceCodecs
+	}
+
+	if nsCodec := codecs[namespace]; nsCodec != nil {
+		return nsCodec
+	}
+
+	if codec != nil {
+		return codec
+	}
+
+	return DefaultCodec
+}
+
+// Codec returns the codec which is used to encode and decode
+// the typed payloads of this namespace, see `EmitTyped` and `OnTyped`.
+func (ns *NSConn) Codec() Codec {
+	return ns.Conn.codec(ns.namespace)
+}
+
+// EmitTyped encodes the "v" value using the namespace's `Codec`
+// and sends it to the remote side, see `NSConn.Emit` and `BinaryCodec`.
+// It returns the encoding error or `ErrWrite` if the message could not be sent.
+func EmitTyped[T any](ns *NSConn, event string, v T) error {
+	if ns == nil {
+		return ErrWrite
+	}
+
+	msg, err := ns.encodeTyped(event, v)
+	if err != nil {
+		return err
+	}
+
+	if !ns.Conn.Write(msg) {
+		return ErrWrite
+	}
+
+	return nil
+}
+
+// encodeTyped returns a message of an "event" with its body filled by the encoded "v".
+func (ns *NSConn) encodeTyped(event string, v interface{}) (Message, error) {
+	codec := ns.Codec()
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{Namespace: ns.namespace, Event: event, Body: body}
+	if b, ok := codec.(BinaryCodec); ok {
+		msg.SetBinary = b.Binary()
+	}
+
+	return msg, nil
+}
+
+// decodeTyped decodes the "body" to the "outPtr" using the namespace's `Codec`,
+// failures are reported as `ErrDecode`.
+func (ns *NSConn) decodeTyped(body []byte, outPtr interface{}) error {
+	if len(body) == 0 {
+		return nil
+	}
+
+	if err := ns.Codec().Unmarshal(body, outPtr); err != nil {
+		return decodeError{err}
+	}
+
+	return nil
+}
+
+// ErrDecode is the error which is returned, as the event callback's error, by the typed event callbacks
+// when the incoming `Message.Body` could not be decoded. The remote side gets notified through its `Message.Err`,
+// use the `errors.Is(msg.Err, neffos.ErrDecode)` to check for it.
+// See `OnTyped`, `On`, `Ask` and `NewStruct`.
+var ErrDecode error = decodeError{}
+
+type decodeError struct {
+	err error
+}
+
+const decodeErrorText = "decode failure"
+
+func (e decodeError) Error() string {
+	if e.err == nil {
+		return decodeErrorText
+	}
+
+	return decodeErrorText + ": " + e.err.Error()
+}
+
+func (e decodeError) Unwrap() error {
+	return e.err
+}
+
+func (e decodeError) Is(target error) bool {
+	_, ok := target.(decodeError)
+	return ok
+}
+
+// ResolveError completes the `RegisterKnownError` dynamic text interface,
+// the remote side's decode errors are resolved to the `ErrDecode`.
+func (e decodeError) ResolveError(errorText string) bool {
+	return strings.HasPrefix(errorText, decodeErrorText)
+}
+
+// OnTyped returns an event callback which decodes the incoming `Message.Body`
+// to a value of T, using the namespace's `Codec`, before it calls the "handler".
+// Decoding errors are returned as the callback's `ErrDecode` error, therefore the remote side gets notified
+// through its `Message.Err`. Incoming messages that carry a remote error are decoded to the zero value.
+//
+// Usage:
+// neffos.Events{"chat": neffos.OnTyped(func(c *neffos.NSConn, msg neffos.Message, v ChatMessage) error {...})}
+func OnTyped[T any](handler func(c *NSConn, msg Message, v T) error) MessageHandlerFunc {
+	return func(c *NSConn, msg Message) error {
+		var v T
+		if msg.Err == nil {
+			if err := c.decodeTyped(msg.Body, &v); err != nil {
+				return err
+			}
+		}
+
+		return handler(c, msg, v)
+	}
+}
+
+// WithCodec is a `ClientOption` which sets the `Client.Codec`.
+func WithCodec(codec Codec) ClientOption {
+	return func(c *Client) {
+		c.Codec = codec
+	}
+}
+
+// WithNamespaceCodec is a `ClientOption` which sets a `Codec` for a specific "namespace",
+// see `Client.NamespaceCodecs`.
+func WithNamespaceCodec(namespace string, codec Codec) ClientOption {
+	return func(c *Client) {
+		if c.NamespaceCodecs == nil {
+			c.NamespaceCodecs = make(map[string]Codec)
+		}
+
+		c.NamespaceCodecs[namespace] = codec
+	}
+}
@@ -0,0 +1,49 @@
+package cbor
+
+import (
+	"github.com/kataras/neffos"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Codec is a `neffos.Codec` for CBOR (RFC 8949).
+//
+// Usage:
+// server.Codec = cbor.Codec{}
+type Codec struct {
+	// EncMode can be optionally set to customize the encoding,
+	// e.g. to use the core deterministic encoding.
+	// Defaults to nil, the cbor package's default encoding options are used.
+	EncMode cbor.EncMode
+	// DecMode can be optionally set to customize the decoding.
+	// Defaults to nil, the cbor package's default decoding options are used.
+	DecMode cbor.DecMode
+}
+
+var (
+	_ neffos.Codec       = Codec{}
+	_ neffos.BinaryCodec = Codec{}
+)
+
+// Binary reports true, the payloads are sent as binary websocket messages.
+func (Codec) Binary() bool {
+	return true
+}
+
+// Marshal returns the CBOR encoding of "v".
+func (c Codec) Marshal(v interface{}) ([]byte, error) {
+	if c.EncMode != nil {
+		return c.EncMode.Marshal(v)
+	}
+
+	return cbor.Marshal(v)
+}
+
+// Unmarshal decodes the CBOR "body" to the "outPtr".
+func (c Codec) Unmarshal(body []byte, outPtr interface{}) error {
+	if c.DecMode != nil {
+		return c.DecMode.Unmarshal(body, outPtr)
+	}
+
+	return cbor.Unmarshal(body, outPtr)
+}
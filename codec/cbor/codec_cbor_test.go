@@ -0,0 +1,125 @@
+package cbor_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/kataras/neffos/codec/cbor"
+
+	fxcbor "github.com/fxamacker/cbor/v2"
+)
+
+type point struct {
+	X, Y int
+	Tags map[string]int
+}
+
+func TestCodec(t *testing.T) {
+	var (
+		codec = cbor.Codec{}
+		value = point{X: 1, Y: 2, Tags: map[string]int{"a": 1, "b": 2}}
+	)
+
+	if !codec.Binary() {
+		t.Fatalf("expected a binary codec")
+	}
+
+	b, err := codec.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got point
+	if err = codec.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(value, got) {
+		t.Fatalf("expected %#v but got %#v", value, got)
+	}
+
+	// a pointer to a nil pointer.
+	var ptr *point
+	if err = codec.Unmarshal(b, &ptr); err != nil {
+		t.Fatal(err)
+	}
+
+	if ptr == nil || !reflect.DeepEqual(value, *ptr) {
+		t.Fatalf("expected %#v but got %#v", value, ptr)
+	}
+}
+
+func TestCodecModes(t *testing.T) {
+	encMode, err := fxcbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decMode, err := fxcbor.DecOptions{DupMapKey: fxcbor.DupMapKeyEnforcedAPF}.DecMode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec := cbor.Codec{EncMode: encMode, DecMode: decMode}
+	value := map[string]int{"b": 2, "a": 1, "c": 3}
+
+	// the core deterministic encoding sorts the map keys.
+	first, err := codec.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		b, err := codec.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(first, b) {
+			t.Fatalf("expected a deterministic encoding")
+		}
+	}
+
+	var got map[string]int
+	if err = codec.Unmarshal(first, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(value, got) {
+		t.Fatalf("expected %#v but got %#v", value, got)
+	}
+
+	// a map with a duplicated key: {"a": 1, "a": 2}.
+	duplicated := []byte{0xa2, 0x61, 'a', 0x01, 0x61, 'a', 0x02}
+	if err = codec.Unmarshal(duplicated, &got); err == nil {
+		t.Fatalf("expected the decoding mode to reject the duplicated map keys")
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	codec := cbor.Codec{}
+
+	b, err := codec.Marshal("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err = codec.Unmarshal(b, &n); err == nil {
+		t.Fatalf("expected an error when unmarshaling a string to an int")
+	}
+
+	var p point
+	if err = codec.Unmarshal(b, &p); err == nil {
+		t.Fatalf("expected an error when unmarshaling a string to a struct")
+	}
+
+	if _, err = codec.Marshal(make(chan int)); err == nil {
+		t.Fatalf("expected an error when marshaling an unsupported type")
+	}
+
+	if err = codec.Unmarshal(b[:len(b)-1], new(string)); err == nil {
+		t.Fatalf("expected an error when unmarshaling a truncated body")
+	}
+}
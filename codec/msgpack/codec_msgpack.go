@@ -0,0 +1,33 @@
+package msgpack
+
+import (
+	"github.com/kataras/neffos"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec is a `neffos.Codec` for MessagePack.
+//
+// Usage:
+// server.Codec = msgpack.Codec{}
+type Codec struct{}
+
+var (
+	_ neffos.Codec       = Codec{}
+	_ neffos.BinaryCodec = Codec{}
+)
+
+// Binary reports true, the payloads are sent as binary websocket messages.
+func (Codec) Binary() bool {
+	return true
+}
+
+// Marshal returns the MessagePack encoding of "v".
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal decodes the MessagePack "body" to the "outPtr".
+func (Codec) Unmarshal(body []byte, outPtr interface{}) error {
+	return msgpack.Unmarshal(body, outPtr)
+}
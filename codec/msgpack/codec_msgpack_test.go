@@ -0,0 +1,79 @@
+package msgpack_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kataras/neffos/codec/msgpack"
+)
+
+type point struct {
+	X, Y int
+	Tags []string
+}
+
+func TestCodec(t *testing.T) {
+	var (
+		codec = msgpack.Codec{}
+		value = point{X: 1, Y: 2, Tags: []string{"a", "b"}}
+	)
+
+	if !codec.Binary() {
+		t.Fatalf("expected a binary codec")
+	}
+
+	b, err := codec.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b) > 0 && b[0] == '{' {
+		t.Fatalf("expected a msgpack body but got: %s", string(b))
+	}
+
+	var got point
+	if err = codec.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(value, got) {
+		t.Fatalf("expected %#v but got %#v", value, got)
+	}
+
+	// a pointer to a nil pointer.
+	var ptr *point
+	if err = codec.Unmarshal(b, &ptr); err != nil {
+		t.Fatal(err)
+	}
+
+	if ptr == nil || !reflect.DeepEqual(value, *ptr) {
+		t.Fatalf("expected %#v but got %#v", value, ptr)
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	codec := msgpack.Codec{}
+
+	b, err := codec.Marshal("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err = codec.Unmarshal(b, &n); err == nil {
+		t.Fatalf("expected an error when unmarshaling a string to an int")
+	}
+
+	var p point
+	if err = codec.Unmarshal(b, &p); err == nil {
+		t.Fatalf("expected an error when unmarshaling a string to a struct")
+	}
+
+	if _, err = codec.Marshal(make(chan int)); err == nil {
+		t.Fatalf("expected an error when marshaling an unsupported type")
+	}
+
+	if err = codec.Unmarshal(b[:len(b)-1], new(string)); err == nil {
+		t.Fatalf("expected an error when unmarshaling a truncated body")
+	}
+}
@@ -0,0 +1,62 @@
+package protobuf
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/kataras/neffos"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec is a `neffos.Codec` for protocol buffers.
+// The values to encode and decode should be `proto.Message`s,
+// e.g. pointers to the generated structs of a .proto file.
+//
+// Usage:
+// server.Codec = protobuf.Codec{}
+type Codec struct {
+	MarshalOptions   proto.MarshalOptions
+	UnmarshalOptions proto.UnmarshalOptions
+}
+
+var (
+	_ neffos.Codec       = Codec{}
+	_ neffos.BinaryCodec = Codec{}
+)
+
+// Binary reports true, the payloads are sent as binary websocket messages.
+func (Codec) Binary() bool {
+	return true
+}
+
+// Marshal returns the wire-format encoding of "v", which should be a `proto.Message`.
+func (c Codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf: marshal: %T is not a proto.Message", v)
+	}
+
+	return c.MarshalOptions.Marshal(m)
+}
+
+// Unmarshal decodes the wire-format "body" to the "outPtr", which should be a `proto.Message`.
+func (c Codec) Unmarshal(body []byte, outPtr interface{}) error {
+	m, ok := outPtr.(proto.Message)
+	if !ok {
+		// a pointer to a, maybe nil, message pointer, e.g. `neffos.OnTyped[*UserMessage]`.
+		if v := reflect.ValueOf(outPtr); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Ptr {
+			if v.Elem().IsNil() {
+				v.Elem().Set(reflect.New(v.Elem().Type().Elem()))
+			}
+
+			m, ok = v.Elem().Interface().(proto.Message)
+		}
+
+		if !ok {
+			return fmt.Errorf("protobuf: unmarshal: %T is not a proto.Message", outPtr)
+		}
+	}
+
+	return c.UnmarshalOptions.Unmarshal(body, m)
+}
@@ -0,0 +1,87 @@
+package protobuf_test
+
+import (
+	"testing"
+
+	"github.com/kataras/neffos/codec/protobuf"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	var (
+		codec = protobuf.Codec{}
+		value = wrapperspb.String("data")
+	)
+
+	if !codec.Binary() {
+		t.Fatalf("expected a binary codec")
+	}
+
+	b, err := codec.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(wrapperspb.StringValue)
+	if err = codec.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !proto.Equal(value, got) {
+		t.Fatalf("expected %v but got %v", value, got)
+	}
+
+	// a pointer to a nil message pointer, e.g. the value of a `neffos.OnTyped[*wrapperspb.StringValue]`.
+	var nilPtr *wrapperspb.StringValue
+	if err = codec.Unmarshal(b, &nilPtr); err != nil {
+		t.Fatal(err)
+	}
+
+	if nilPtr == nil || !proto.Equal(value, nilPtr) {
+		t.Fatalf("expected %v but got %v", value, nilPtr)
+	}
+
+	// a pointer to an allocated message pointer is decoded to that message.
+	existing := new(wrapperspb.StringValue)
+	ptr := existing
+	if err = codec.Unmarshal(b, &ptr); err != nil {
+		t.Fatal(err)
+	}
+
+	if ptr != existing || !proto.Equal(value, existing) {
+		t.Fatalf("expected the existing message to be decoded but got %v", ptr)
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	codec := protobuf.Codec{}
+
+	if _, err := codec.Marshal(struct{ Name string }{"data"}); err == nil {
+		t.Fatalf("expected an error when marshaling a value which is not a proto.Message")
+	}
+
+	b, err := codec.Marshal(wrapperspb.String("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s string
+	if err = codec.Unmarshal(b, &s); err == nil {
+		t.Fatalf("expected an error when unmarshaling to a value which is not a proto.Message")
+	}
+
+	var ptr *string
+	if err = codec.Unmarshal(b, &ptr); err == nil {
+		t.Fatalf("expected an error when unmarshaling to a pointer of a pointer which is not a proto.Message")
+	}
+
+	if err = codec.Unmarshal(b, wrapperspb.String("")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = codec.Unmarshal([]byte{0xff}, new(wrapperspb.StringValue)); err == nil {
+		t.Fatalf("expected an error when unmarshaling an invalid body")
+	}
+}
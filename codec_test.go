@@ -0,0 +1,137 @@
+package neffos_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/codec/msgpack"
+	"github.com/kataras/neffos/gobwas"
+	"github.com/kataras/neffos/gorilla"
+)
+
+type testPoint struct {
+	X, Y int
+}
+
+func TestCodec(t *testing.T) {
+	var (
+		namespace = "msgpack"
+		point     = testPoint{X: 1, Y: 2}
+		errs      = make(chan error, 1)
+		results   = make(chan testPoint, 1)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"point": neffos.OnTyped(func(c *neffos.NSConn, msg neffos.Message, v testPoint) error {
+					if c.Conn.IsClient() {
+						if msg.Err != nil {
+							errs <- msg.Err
+							return nil
+						}
+
+						results <- v
+						return nil
+					}
+
+					if len(msg.Body) > 0 && msg.Body[0] == '{' {
+						t.Fatalf("expected a msgpack body but got: %s", string(msg.Body))
+					}
+
+					return neffos.EmitTyped(c, "point", testPoint{X: v.Y, Y: v.X})
+				}),
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.Codec = neffos.JSONCodec{}
+		s.NamespaceCodecs = map[string]neffos.Codec{namespace: msgpack.Codec{}}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer, events,
+			neffos.WithNamespaceCodec(namespace, msgpack.Codec{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := c.Codec().(msgpack.Codec); !ok {
+			t.Fatalf("[%s] expected the namespace's codec but got: %T", dialer, c.Codec())
+		}
+
+		if err = neffos.EmitTyped(c, "point", point); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := <-results; got.X != point.Y || got.Y != point.X {
+			t.Fatalf("[%s] unexpected result: %#+v", dialer, got)
+		}
+
+		// a body that can not be decoded, the server's decode error is sent back.
+		c.EmitBinary("point", []byte{0xc1})
+		if err = <-errs; err == nil {
+			t.Fatalf("[%s] expected a decode error", dialer)
+		}
+
+		client.Close()
+	}
+}
+
+func TestTypedAsk(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = make(neffos.Events)
+		errSum    = errors.New("sum failure")
+	)
+
+	neffos.OnAsk(events, "sum", func(c *neffos.NSConn, p testPoint) (int, error) {
+		if p.X < 0 {
+			return 0, errSum
+		}
+
+		return p.X + p.Y, nil
+	})
+
+	neffos.RegisterKnownError(errSum)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: events})
+	defer teardownServer()
+
+	defer runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}},
+		func(dialer string, client *neffos.Client) {
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sum, err := neffos.Ask[testPoint, int](context.TODO(), c, "sum", testPoint{X: 1, Y: 2})
+			if err != nil {
+				t.Fatalf("[%s] %v", dialer, err)
+			}
+
+			if sum != 3 {
+				t.Fatalf("[%s] expected sum to be 3 but got: %d", dialer, sum)
+			}
+
+			if _, err = neffos.Ask[testPoint, int](context.TODO(), c, "sum", testPoint{X: -1}); err != errSum {
+				t.Fatalf("[%s] expected error: %v but got: %v", dialer, errSum, err)
+			}
+
+			_, err = neffos.Ask[string, int](context.TODO(), c, "sum", "not a point")
+			if !errors.Is(err, neffos.ErrDecode) {
+				t.Fatalf("[%s] expected a decode error but got: %v", dialer, err)
+			}
+
+			if _, err = neffos.Ask[testPoint, string](context.TODO(), c, "sum", testPoint{}); !errors.Is(err, neffos.ErrDecode) {
+				t.Fatalf("[%s] expected a decode error of the response but got: %v", dialer, err)
+			}
+		})()
+}
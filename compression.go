@@ -0,0 +1,110 @@
+package neffos
+
+import (
+	"compress/flate"
+	"context"
+	"time"
+)
+
+// Compression describes the per-message compression (permessage-deflate) of the connections' messages,
+// see `Server.Compression` and `Client.Compression`.
+// It is negotiated on the handshake, the remote side may not support it,
+// then the messages are sent uncompressed.
+//
+// The `gorilla` sockets support only the no context takeover mode,
+// the context takeover options are ignored there.
+type Compression struct {
+	// Level is the compression level of the "compress/flate" package,
+	// from `flate.BestSpeed` to `flate.BestCompression` or `flate.HuffmanOnly`.
+	// Defaults to 0, the `flate.DefaultCompression` is used.
+	Level int
+	// Threshold is the minimum size, in bytes, of a message to be compressed,
+	// small messages are not worth the compression. A negative value compresses every message.
+	// The `Message.Compress` field can override it.
+	// Defaults to 0, the `DefaultCompressionThreshold` is used.
+	Threshold int
+	// ServerContextTakeover, if true, allows the server to reuse the compression state
+	// between its messages, it's better compression ratio for more memory per connection.
+	// Defaults to false.
+	ServerContextTakeover bool
+	// ClientContextTakeover, if true, allows the client to reuse the compression state
+	// between its messages, it's better compression ratio for more memory per connection.
+	// Defaults to false.
+	ClientContextTakeover bool
+}
+
+// DefaultCompressionThreshold is the default `Compression.Threshold`.
+var DefaultCompressionThreshold = 512
+
+// CompressionLevel returns the flate compression level.
+func (c *Compression) CompressionLevel() int {
+	if c.Level == 0 {
+		return flate.DefaultCompression
+	}
+
+	return c.Level
+}
+
+func (c *Compression) threshold() int {
+	if c.Threshold == 0 {
+		return DefaultCompressionThreshold
+	}
+
+	return c.Threshold
+}
+
+// CompressMode is the per-message compression override, see `Message.Compress`.
+type CompressMode uint8
+
+const (
+	// CompressAuto compresses the message if it's not smaller than the `Compression.Threshold`.
+	CompressAuto CompressMode = iota
+	// CompressAlways compresses the message regardless of its size.
+	CompressAlways
+	// CompressNever sends the message uncompressed.
+	CompressNever
+)
+
+// SocketCompressor is an optional interface which a `Socket` can complete
+// to send compressed messages, see `Compression`. The socket should decompress
+// the incoming compressed messages on its `ReadData` method.
+type SocketCompressor interface {
+	// CompressionNegotiated reports whether the permessage-deflate extension was negotiated.
+	CompressionNegotiated() bool
+	// WriteCompressed sends a compressed text or binary message to the remote connection.
+	WriteCompressed(body []byte, binary bool, timeout time.Duration) error
+}
+
+type compressionKey struct{}
+
+// CompressionFromContext returns the `Compression` of the server's or client's
+// handshake, the `Upgrader` receives it through the request's context
+// and the `Dialer` through its context. It returns nil if the compression is disabled.
+func CompressionFromContext(ctx context.Context) *Compression {
+	c, _ := ctx.Value(compressionKey{}).(*Compression)
+	return c
+}
+
+func contextWithCompression(ctx context.Context, c *Compression) context.Context {
+	if c == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, compressionKey{}, c)
+}
+
+// WithCompression is a `ClientOption` which sets the `Client.Compression`.
+func WithCompression(compression Compression) ClientOption {
+	return func(c *Client) {
+		c.Compression = &compression
+	}
+}
+
+// shouldCompress reports whether a message of "size" bytes should be sent compressed.
+func (c *Conn) shouldCompress(size int, mode CompressMode) bool {
+	if c.compression == nil || mode == CompressNever {
+		return false
+	}
+
+	return mode == CompressAlways || size >= c.compression.threshold()
+}
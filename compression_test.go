@@ -0,0 +1,98 @@
+package neffos_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kataras/neffos"
+
+	gobwas "github.com/kataras/neffos/gobwas"
+	gorilla "github.com/kataras/neffos/gorilla"
+
+	ws "github.com/gobwas/ws"
+	gorillaws "github.com/gorilla/websocket"
+)
+
+type countingConn struct {
+	net.Conn
+	read *int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+func TestCompression(t *testing.T) {
+	var (
+		namespace = "default"
+		body      = bytes.Repeat([]byte(`{"name":"neffos","value":42},`), 300)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+		compression = neffos.Compression{ServerContextTakeover: true, ClientContextTakeover: true}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.Compression = &compression
+	})
+	defer teardownServer()
+
+	netDial := func(read *int64) func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return countingConn{Conn: conn, read: read}, nil
+		}
+	}
+
+	for _, endpoint := range []string{"gobwas", "gorilla"} {
+		for _, dialer := range []string{"gobwas", "gorilla"} {
+			read := new(int64)
+			dial := gobwas.Dialer(ws.Dialer{NetDial: netDial(read)})
+			if dialer == "gorilla" {
+				dial = gorilla.Dialer(&gorillaws.Dialer{NetDialContext: netDial(read)}, nil)
+			}
+
+			client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+endpoint, events, neffos.WithCompression(compression))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s, ok := c.Conn.Socket().(neffos.SocketCompressor); !ok || !s.CompressionNegotiated() {
+				t.Fatalf("[%s/%s] expected compression to be negotiated", endpoint, dialer)
+			}
+
+			for i := 0; i < 3; i++ {
+				reply, err := c.Ask(context.TODO(), "echo", body)
+				if err != nil {
+					t.Fatalf("[%s/%s] %v", endpoint, dialer, err)
+				}
+
+				if !bytes.Equal(reply.Body, body) {
+					t.Fatalf("[%s/%s] expected the reply to be the same as the body", endpoint, dialer)
+				}
+			}
+
+			if got, max := atomic.LoadInt64(read), int64(len(body)); got > max {
+				t.Fatalf("[%s/%s] expected the compressed replies to be less than %d bytes but got %d", endpoint, dialer, max, got)
+			}
+			client.Close()
+		}
+	}
+}
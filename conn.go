@@ -5,6 +5,8 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,6 +27,21 @@ type (
 		WriteText(body []byte, timeout time.Duration) error
 	}
 
+	// SocketCloseWriter is an optional interface which a `Socket` can complete
+	// to send a websocket close frame with a status code, see `Conn.CloseWithCode`.
+	SocketCloseWriter interface {
+		// WriteClose sends a close frame of the "code" and "reason" to the remote connection.
+		WriteClose(code int, reason string, timeout time.Duration) error
+	}
+
+	// SocketReadLimiter is an optional interface which a `Socket` can complete
+	// to reject the incoming messages which are bigger than a limit, see `Server.MaxMessageSize`.
+	SocketReadLimiter interface {
+		// SetReadLimit sets the maximum size, in bytes, of an incoming message.
+		// The `ReadData` should return the `ErrMessageTooBig` for bigger messages.
+		SetReadLimit(limit int64)
+	}
+
 	// MessageType is a type for readen and to-send data, helpful to set `msg.SetBinary`
 	// to the rest of the clients through a Broadcast, as SetBinary is not part of the deserialization.
 	MessageType uint8
@@ -42,7 +59,8 @@ const (
 // Each `NSConn` can join to multiple rooms.
 type Conn struct {
 	// the ID generated by `Server#IDGenerator`.
-	id string
+	id      string
+	idMutex sync.RWMutex
 	// serverConnID is unique per server instance and it can be comparable only within the
 	// same server instance. Even if Server#IDGenerator
 	// returns the same ID from the request.
@@ -51,14 +69,18 @@ type Conn struct {
 	store      map[string]interface{}
 	storeMutex sync.RWMutex
 
-	// the gorilla or gobwas socket.
-	socket Socket
+	// the gorilla or gobwas socket,
+	// it may be replaced on client-side reconnections, see `Client.Reconnect`.
+	socket      Socket
+	socketMutex sync.RWMutex
 	// ReconnectTries, if > 0 then this connection is a result of a client-side reconnection,
 	// see `WasReconnected() bool`.
 	ReconnectTries int
 
 	// non-nil if server-side connection.
 	server *Server
+	// non-nil if client-side connection which its `Dial` succeed.
+	client *Client
 	// when sever or client is ready to handle messages,
 	// ack and queue is available,
 	// see `Server#ServeHTTP.?OnConnect!=nil`.
@@ -85,14 +107,26 @@ type Conn struct {
 	processes *processes
 
 	isInsideHandler *uint32
+	// set when the remote side negotiated the messages' header section, see `protocolHeader`.
+	useHeader *uint32
+	// set when an event callback panicked and the connection should be closed, see `Server.CloseOnPanic`.
+	panicked *uint32
 
 	// messages that this connection waits for a reply.
 	waitingMessages      map[string]chan Message
 	waitingMessagesMutex sync.RWMutex
 
+	// messages sent with at-least-once delivery that wait for an acknowledgement,
+	// initialized on first `WriteReliable`.
+	outbox      *outbox
+	outboxMutex sync.Mutex
+
 	allowNativeMessages            bool
 	shouldHandleOnlyNativeMessages bool
 
+	// non-nil when the binary protocol is negotiated on the acknowledgement process.
+	wire atomic.Pointer[binaryCodec]
+
 	queue      map[MessageType][][]byte
 	queueMutex sync.Mutex
 
@@ -100,10 +134,42 @@ type Conn struct {
 	closed *uint32
 	// useful to terminate the broadcaster, see `Server#ServeHTTP.waitMessages`.
 	closeCh chan struct{}
+
+	// limiter keeps the state of the server's rate limits, see `Server.RateLimits`.
+	limiter      *connLimiter
+	limiterMutex sync.Mutex
+	inFlightAsks *int32
+
+	// writeQueue is the outgoing messages queue, if enabled, see `Server.WriteQueueSize`.
+	writeQueue *writeQueue
+
+	// compression is the per-message compression, if enabled, see `Server.Compression` and `Client.Compression`.
+	compression *Compression
+
+	// principal is the authenticated identity, see `Server.Authenticate`.
+	principal Principal
+
+	// presences are the members of this server-side connection, see `Server.Presence`.
+	presences         map[presenceKey]Member
+	presenceID        string // the `Member.Key`, unique per server-side connection.
+	presenceRefreshed time.Time
+	presenceMutex     sync.Mutex
+
+	// heartbeat is the ping policy, if enabled, see `Server.Heartbeat` and `Client.Heartbeat`.
+	heartbeat   *Heartbeat
+	latency     *int64
+	missedPings *int32
+
+	// ctx is the parent context of the incoming messages, see `Context`.
+	ctx       context.Context
+	cancelCtx context.CancelFunc
 }
 
-func newConn(socket Socket, namespaces Namespaces) *Conn {
+func newConn(ctx context.Context, socket Socket, namespaces Namespaces) *Conn {
+	ctx, cancel := context.WithCancel(ctx)
 	c := &Conn{
+		ctx:                            ctx,
+		cancelCtx:                      cancel,
 		socket:                         socket,
 		namespaces:                     namespaces,
 		readiness:                      newWaiterOnce(),
@@ -111,6 +177,11 @@ func newConn(socket Socket, namespaces Namespaces) *Conn {
 		connectedNamespaces:            make(map[string]*NSConn),
 		processes:                      newProcesses(),
 		isInsideHandler:                new(uint32),
+		useHeader:                      new(uint32),
+		panicked:                       new(uint32),
+		inFlightAsks:                   new(int32),
+		latency:                        new(int64),
+		missedPings:                    new(int32),
 		waitingMessages:                make(map[string]chan Message),
 		allowNativeMessages:            false,
 		shouldHandleOnlyNativeMessages: false,
@@ -126,7 +197,7 @@ func newConn(socket Socket, namespaces Namespaces) *Conn {
 		// then no need to call Connect(...) because:
 		// client-side can use raw websocket without the neffos.js library
 		// so no access to connect to a namespace.
-		if len(c.namespaces) == 1 && len(emptyNamespace) == 1 {
+		if len(c.namespaces) == 1 && emptyNamespace.len() == 1 {
 			c.connectedNamespaces[""] = newNSConn(c, "", emptyNamespace)
 			c.shouldHandleOnlyNativeMessages = true
 			atomic.StoreUint32(c.acknowledged, 1)
@@ -144,7 +215,7 @@ func (c *Conn) Is(connID string) bool {
 	}
 
 	if c.IsClient() {
-		return c.id == connID
+		return c.ID() == connID
 	}
 
 	return c.serverConnID == connID
@@ -153,8 +224,13 @@ func (c *Conn) Is(connID string) bool {
 // ID method returns the unique identifier of the connection.
 // If this is a server-side connection then this value is the generated one by the `Server#IDGenerator`.
 // If this is a client-side connection then this value is filled on the acknowledgment process which is done on the `Client#Dial`.
+// It's safe for concurrent use, the ID of a client-side connection is replaced on reconnections.
 func (c *Conn) ID() string {
-	return c.id
+	c.idMutex.RLock()
+	id := c.id
+	c.idMutex.RUnlock()
+
+	return id
 }
 
 // String method simply returns the ID(). Useful for fmt usage and
@@ -166,7 +242,10 @@ func (c *Conn) String() string {
 
 // Socket method returns the underline socket implementation.
 func (c *Conn) Socket() Socket {
-	return c.socket
+	c.socketMutex.RLock()
+	socket := c.socket
+	c.socketMutex.RUnlock()
+	return socket
 }
 
 // IsClient method reports whether this connections is a client-side connetion.
@@ -281,6 +360,9 @@ func (c *Conn) isAcknowledged() bool {
 const (
 	ackBinary   = 'M' // byte(0x1) // comes from client to server at startup.
 	ackIDBinary = 'A' // byte(0x2) // comes from server to client after ackBinary and ready as a prefix, the rest message is the conn's ID.
+	// comes from server to client instead of ackIDBinary when client asked for protocols (see `protocolBinary`),
+	// the rest message is the comma-separated accepted protocols, a semicolon and the conn's ID.
+	ackExtIDBinary = 'E'
 	// ackOKBinary    = 'K' // byte(0x3) // comes from client to server when id received and set-ed.
 	ackNotOKBinary = 'H' // byte(0x4) // comes from server to client if `Server#OnConnected` errored as a prefix, the rest message is the error text.
 )
@@ -288,6 +370,7 @@ const (
 var (
 	ackBinaryB      = []byte{ackBinary}
 	ackIDBinaryB    = []byte{ackIDBinary}
+	ackExtIDBinaryB = []byte{ackExtIDBinary}
 	ackNotOKBinaryB = []byte{ackNotOKBinary}
 )
 
@@ -299,7 +382,7 @@ func (c *Conn) sendClientACK() error {
 		return nil
 	}
 
-	ok := c.write(ackBinaryB, false)
+	ok := c.write(c.ackMessage(), false)
 	if !ok {
 		c.Close()
 		return ErrWrite
@@ -317,14 +400,27 @@ func (c *Conn) startReader() {
 	if c.IsClosed() {
 		return
 	}
-	defer c.Close()
+
+	var readErr error
+	defer func() {
+		c.close(readErr)
+	}()
 
 	// CLIENT is ready when ACK done
 	// SERVER is ready when ACK is done AND `Server#OnConnected` returns with nil error.
 	for {
-		b, msgTyp, err := c.socket.ReadData(c.readTimeout)
+		b, msgTyp, err := c.Socket().ReadData(c.readTimeout)
 		if err != nil {
+			if c.reconnect(err) {
+				continue
+			}
+
+			if err == ErrMessageTooBig {
+				c.reportError(err)
+			}
+
 			c.readiness.unwait(err)
+			readErr = err
 			return
 		}
 
@@ -340,8 +436,16 @@ func (c *Conn) startReader() {
 		}
 
 		atomic.StoreUint32(c.isInsideHandler, 1)
-		c.HandlePayload(msgTyp, b)
+		err = c.HandlePayload(msgTyp, b)
 		atomic.StoreUint32(c.isInsideHandler, 0)
+
+		if err == ErrInvalidPayload || err == ErrFieldTooLong {
+			c.reportError(err)
+		}
+
+		if atomic.LoadUint32(c.panicked) == 1 {
+			return
+		}
 	}
 }
 
@@ -355,11 +459,21 @@ func (c *Conn) handleACK(msgTyp MessageType, b []byte) bool {
 			c.write(append(ackNotOKBinaryB, []byte(err.Error())...), false)
 			return false
 		}
+
+		protocols := acceptProtocols(b[1:])
+		c.useProtocols(protocols)
+
 		atomic.StoreUint32(c.acknowledged, 1)
 		c.handleQueue()
 
 		// it's ok send ID.
-		return c.write(append(ackIDBinaryB, []byte(c.id)...), false)
+		if len(protocols) > 0 {
+			reply := append(ackExtIDBinaryB, strings.Join(protocols, ",")...)
+			reply = append(reply, ';')
+			return c.write(append(reply, []byte(c.ID())...), false)
+		}
+
+		return c.write(append(ackIDBinaryB, []byte(c.ID())...), false)
 
 	// case ackOKBinary:
 	// 	// from client to server.
@@ -367,13 +481,27 @@ func (c *Conn) handleACK(msgTyp MessageType, b []byte) bool {
 	// 	atomic.StoreUint32(c.acknowledged, 1)
 	// 	c.handleQueue()
 
-	case ackIDBinary:
+	case ackIDBinary, ackExtIDBinary:
 		// from server to client.
 		id := string(b[1:])
+		if typ == ackExtIDBinary {
+			var protocols []string
+			protocols, id = parseAckExt(b[1:])
+			c.useProtocols(protocols)
+		}
+		c.idMutex.Lock()
 		c.id = id
+		c.idMutex.Unlock()
 
 		atomic.StoreUint32(c.acknowledged, 1)
-		c.readiness.unwait(nil)
+
+		if c.readiness.isReady() {
+			// ack after a client-side reconnection,
+			// connect to the previously connected namespaces and rooms again.
+			go c.client.restore()
+		} else {
+			c.readiness.unwait(nil)
+		}
 		// c.write([]byte{ackOKBinary})
 		// println("ackIDBinary: pass with nil")
 		// c.handleQueue()
@@ -413,11 +541,34 @@ func (c *Conn) handleQueue() {
 // In the future it may be exposed by an error listener.
 var ErrInvalidPayload = errors.New("invalid payload")
 
-func (c *Conn) handleMessage(msg Message) error {
+func (c *Conn) handleMessage(msg Message) (err error) {
 	if msg.isInvalid {
+		if msg.Err == ErrFieldTooLong {
+			c.CloseWithCode(ClosePolicyViolation, ErrFieldTooLong.Error())
+			return ErrFieldTooLong
+		}
+
 		return ErrInvalidPayload
 	}
 
+	if isDeliveryWait(msg.wait) {
+		if msg.Event == "" {
+			// the remote side acknowledged a message of this side.
+			c.confirmDelivery(msg.wait)
+			return nil
+		}
+
+		// acknowledge the message after it's handled by a connected namespace without an error,
+		// otherwise the sender keeps it and sends it again, its wait token is not passed to the event callback.
+		wait := msg.wait
+		defer func() {
+			if err == nil {
+				c.writeEmptyReply(wait)
+			}
+		}()
+		msg.wait = ""
+	}
+
 	if msg.IsNative && c.shouldHandleOnlyNativeMessages {
 		ns := c.Namespace("")
 		return ns.events.fireEvent(ns, msg)
@@ -462,6 +613,10 @@ func (c *Conn) handleMessage(msg Message) error {
 		if ns, ok := c.tryNamespace(msg); ok {
 			ns.replyRoomLeave(msg)
 		}
+	case roomReplayEvent:
+		if ns, ok := c.tryNamespace(msg); ok && !c.IsClient() {
+			ns.replyRoomReplay(msg)
+		}
 	default:
 		ns, ok := c.tryNamespace(msg)
 		if !ok {
@@ -483,12 +638,94 @@ func (c *Conn) handleMessage(msg Message) error {
 
 // DeserializeMessage returns a Message from the "payload".
 func (c *Conn) DeserializeMessage(msgTyp MessageType, payload []byte) Message {
-	return DeserializeMessage(msgTyp, payload, c.allowNativeMessages, c.shouldHandleOnlyNativeMessages)
+	return deserializeMessage(msgTyp, payload, c.allowNativeMessages, c.shouldHandleOnlyNativeMessages, c.maxFieldLength())
 }
 
 // HandlePayload fires manually a local event based on the "payload".
+// The "payload" is expected to be read from this connection's socket,
+// it is deserialized using the binary protocol if it was negotiated.
 func (c *Conn) HandlePayload(msgTyp MessageType, payload []byte) error {
-	return c.handleMessage(c.DeserializeMessage(msgTyp, payload))
+	msg := c.deserialize(msgTyp, payload)
+	if o := c.observer(); o != nil {
+		o.MessageReceived(c, msg, len(payload))
+	}
+
+	// heartbeats are handled before the limits,
+	// a throttled pong would fail the heartbeat of a healthy connection.
+	if c.handleHeartbeat(msg) {
+		return nil
+	}
+
+	if err := c.limit(msg); err != nil {
+		return err
+	}
+
+	if msg.isInvalid || msg.IsNative {
+		// native messages' callbacks can use the `Conn.Context` instead.
+		return c.handleMessage(msg)
+	}
+
+	msg.ctx = c.ctx
+	if timeout, ok := extractTimeout(&msg); ok {
+		var cancel context.CancelFunc
+		msg.ctx, cancel = context.WithTimeout(msg.ctx, timeout)
+		defer cancel()
+	}
+
+	if t := c.tracer(); t != nil {
+		msg.ctx = t.Extract(msg.ctx, msg.Header)
+		end := startSpan(t, nil, SpanHandlePayload, &msg)
+		err := c.handleMessage(msg)
+		end(err)
+		return err
+	}
+
+	return c.handleMessage(msg)
+}
+
+// timeoutHeaderKey is the `Message.Header` key of the remaining time, in milliseconds,
+// of the remote side's `Conn.Ask` context, the context of the incoming message expires along with it.
+const timeoutHeaderKey = "neffos-timeout"
+
+// injectTimeout returns the header of the "msg" including the remaining time of the "ctx", if it has a deadline.
+func injectTimeout(ctx context.Context, msg Message) map[string]string {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return msg.Header
+	}
+
+	timeout := time.Until(deadline).Milliseconds()
+	if timeout < 1 {
+		timeout = 1
+	}
+
+	header := make(map[string]string, len(msg.Header)+1)
+	for k, v := range msg.Header {
+		header[k] = v
+	}
+	header[timeoutHeaderKey] = strconv.FormatInt(timeout, 10)
+	return header
+}
+
+// extractTimeout removes the remote side's timeout from the incoming "msg"'s header, if any.
+func extractTimeout(msg *Message) (time.Duration, bool) {
+	v, ok := msg.Header[timeoutHeaderKey]
+	if !ok {
+		return 0, false
+	}
+
+	// the header is decoded for this message only, it's safe to modify.
+	delete(msg.Header, timeoutHeaderKey)
+	if len(msg.Header) == 0 {
+		msg.Header = nil
+	}
+
+	timeout, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || timeout <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(timeout) * time.Millisecond, true
 }
 
 const syncWaitDur = 15 * time.Millisecond
@@ -502,6 +739,14 @@ const maxSyncWaitDur = 10 * time.Second
 // If this is a client-side connection then the server-side namespace's `OnNamespaceConnect` event callback MUST return null
 // in order to allow this client-side connection to connect, otherwise a non-nil error is returned instead.
 func (c *Conn) Connect(ctx context.Context, namespace string) (*NSConn, error) {
+	return c.ConnectWithHeader(ctx, namespace, nil)
+}
+
+// ConnectWithHeader acts like `Connect` but it sends the "header" along with the namespace connect message,
+// the remote side can read it through the `Message.Header` on its `OnNamespaceConnect` event callback
+// (or through a `StructMessageInjector`), i.e. to authorize the connection.
+// On client-side reconnections the same header is sent again.
+func (c *Conn) ConnectWithHeader(ctx context.Context, namespace string, header map[string]string) (*NSConn, error) {
 	// if c.IsClosed() {
 	// 	return nil, ErrWrite
 	// }
@@ -532,7 +777,7 @@ func (c *Conn) Connect(ctx context.Context, namespace string) (*NSConn, error) {
 		}
 	}
 
-	return c.askConnect(ctx, namespace)
+	return c.askConnect(ctx, namespace, header)
 }
 
 // const defaultNS = ""
@@ -600,7 +845,7 @@ func (c *Conn) tryNamespace(in Message) (*NSConn, bool) {
 // client#WaitConnect
 // or
 // client#Connect
-func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error) {
+func (c *Conn) askConnect(ctx context.Context, namespace string, header map[string]string) (*NSConn, error) {
 	p := c.processes.get(namespace)
 	p.Start() // block any `tryNamespace` with that "namespace".
 
@@ -624,9 +869,11 @@ func (c *Conn) askConnect(ctx context.Context, namespace string) (*NSConn, error
 		Namespace: namespace,
 		Event:     OnNamespaceConnect,
 		IsLocal:   true,
+		Header:    header,
 	}
 
 	ns = newNSConn(c, namespace, events)
+	ns.connectHeader = header
 	err := events.fireEvent(ns, connectMessage)
 	if err != nil {
 		return nil, err
@@ -680,6 +927,19 @@ func (c *Conn) replyConnect(msg Message) {
 		return
 	}
 
+	if !c.authorize(msg.Namespace) {
+		msg.Err = ErrForbidden
+		c.Write(msg)
+		return
+	}
+
+	if c.exceedsMaxNamespaces() {
+		msg.Err = ErrTooManyNamespaces
+		c.Write(msg)
+		c.CloseWithCode(ClosePolicyViolation, ErrTooManyNamespaces.Error())
+		return
+	}
+
 	ns = newNSConn(c, msg.Namespace, events)
 	err := events.fireEvent(ns, msg)
 	if err != nil {
@@ -699,17 +959,27 @@ func (c *Conn) replyConnect(msg Message) {
 
 func (c *Conn) notifyNamespaceConnected(ns *NSConn, connectMsg Message) {
 	connectMsg.Event = OnNamespaceConnected
+	if o := c.observer(); o != nil {
+		o.NamespaceConnected(c, ns.namespace)
+	}
 	ns.events.fireEvent(ns, connectMsg) // omit error, it's connected.
 
 	if !c.IsClient() && c.server.usesStackExchange() {
 		c.server.StackExchange.Subscribe(c, ns.namespace)
 	}
+
+	ns.trackPresence("", true)
+
+	c.replayDeliveries(ns.namespace)
+	c.deliverStored(ns.namespace)
 }
 
-func (c *Conn) notifyNamespaceDisconnect(_ *NSConn, disconnectMsg Message) {
+func (c *Conn) notifyNamespaceDisconnect(ns *NSConn, disconnectMsg Message) {
 	if !c.IsClient() && c.server.usesStackExchange() {
 		c.server.StackExchange.Unsubscribe(c, disconnectMsg.Namespace)
 	}
+
+	ns.trackPresence("", false)
 }
 
 // DisconnectAll method disconnects from all namespaces,
@@ -822,16 +1092,30 @@ func (c *Conn) replyDisconnect(msg Message) {
 }
 
 func (c *Conn) write(b []byte, binary bool) bool {
+	return c.writeCompress(b, binary, CompressAuto)
+}
+
+// writeCompress writes "b" compressed if the compression is negotiated
+// and the "mode" and the `Compression.Threshold` allow it.
+func (c *Conn) writeCompress(b []byte, binary bool, mode CompressMode) bool {
 	var err error
-	if binary {
-		err = c.socket.WriteBinary(b, c.writeTimeout)
+	socket := c.Socket()
+	if compressor, ok := socket.(SocketCompressor); ok && c.shouldCompress(len(b), mode) && compressor.CompressionNegotiated() {
+		err = compressor.WriteCompressed(b, binary, c.writeTimeout)
+	} else if binary {
+		err = socket.WriteBinary(b, c.writeTimeout)
 	} else {
-		err = c.socket.WriteText(b, c.writeTimeout)
+		err = socket.WriteText(b, c.writeTimeout)
 	}
 
 	if err != nil {
-		if IsCloseError(err) {
-			c.Close()
+		if o := c.observer(); o != nil {
+			o.WriteFailed(c, err)
+		}
+		c.reportError(err)
+		// let the reader decide if it should be closed when client can reconnect.
+		if IsCloseError(err) && !c.canReconnect() {
+			c.close(err)
 		}
 		return false
 	}
@@ -909,11 +1193,15 @@ func (c *Conn) Write(msg Message) bool {
 	}
 
 	msg.FromExplicit = ""
-	return c.write(serializeMessage(msg), msg.SetBinary)
+	return c.writeMessage(msg)
 }
 
 // used when `Ask` caller cares only for successful call and not the message, for performance reasons we just use raw bytes.
 func (c *Conn) writeEmptyReply(wait string) bool {
+	if c.wire.Load() != nil || c.writeQueue != nil {
+		return c.writeMessage(Message{wait: wait})
+	}
+
 	return c.write(genEmptyReplyToWait(wait), false)
 }
 
@@ -933,8 +1221,20 @@ func (c *Conn) writeEmptyReply(wait string) bool {
 // }
 
 // Ask method sends a message to the remote side and blocks until a response or an error received from the specific `Message.Event`.
-func (c *Conn) Ask(ctx context.Context, msg Message) (Message, error) {
+func (c *Conn) Ask(ctx context.Context, msg Message) (resp Message, err error) {
 	mustWaitOnlyTheNextMessage := atomic.LoadUint32(c.isInsideHandler) == 1
+	if t := c.tracer(); t != nil {
+		end := startSpan(t, ctx, SpanConnAsk, &msg)
+		defer func() { end(err) }()
+	}
+
+	if o := c.observer(); o != nil {
+		start := time.Now()
+		resp, err = c.ask(ctx, msg, mustWaitOnlyTheNextMessage)
+		o.AskCompleted(c, msg, time.Since(start), err)
+		return resp, err
+	}
+
 	return c.ask(ctx, msg, mustWaitOnlyTheNextMessage)
 }
 
@@ -948,6 +1248,11 @@ func (c *Conn) ask(ctx context.Context, msg Message, mustWaitOnlyTheNextMessage
 		return msg, CloseError{Code: -1, error: ErrWrite}
 	}
 
+	if !c.acquireAsk() {
+		return Message{}, ErrTooManyAsks
+	}
+	defer c.releaseAsk()
+
 	if ctx == nil {
 		ctx = context.TODO()
 	} else if ctx == context.TODO() {
@@ -961,6 +1266,7 @@ func (c *Conn) ask(ctx context.Context, msg Message, mustWaitOnlyTheNextMessage
 
 	ch := make(chan Message, 1)
 	msg.wait = genWait(c.IsClient())
+	msg.Header = injectTimeout(ctx, msg)
 
 	if mustWaitOnlyTheNextMessage {
 		// msg.wait is not required on this state
@@ -972,7 +1278,7 @@ func (c *Conn) ask(ctx context.Context, msg Message, mustWaitOnlyTheNextMessage
 				return
 			}
 
-			ch <- c.DeserializeMessage(msgTyp, b)
+			ch <- c.deserialize(msgTyp, b)
 		}()
 	} else {
 		c.waitingMessagesMutex.Lock()
@@ -1005,7 +1311,14 @@ func (c *Conn) ask(ctx context.Context, msg Message, mustWaitOnlyTheNextMessage
 // and finally will terminate the underline websocket connection.
 // After this method call the `Conn` is not usable anymore, a new `Dial` call is required.
 func (c *Conn) Close() {
+	c.close(nil)
+}
+
+// close closes the connection, the "err" is the reason, if any, see `Observer.ConnectionClosed`.
+func (c *Conn) close(err error) {
 	if atomic.CompareAndSwapUint32(c.closed, 0, 1) {
+		c.cancelCtx()
+
 		if !c.shouldHandleOnlyNativeMessages {
 			disconnectMsg := Message{Event: OnNamespaceDisconnect, IsForced: true, IsLocal: true}
 			c.connectedNamespacesMutex.Lock()
@@ -1029,14 +1342,68 @@ func (c *Conn) Close() {
 		atomic.StoreUint32(c.acknowledged, 0)
 
 		if !c.IsClient() {
+			c.closePresence()
+			c.server.parkOutbox(c)
+
+			if o := c.observer(); o != nil {
+				o.ConnectionClosed(c, err)
+			}
+
 			go func() {
 				c.server.disconnect <- c
 			}()
 		}
 
 		close(c.closeCh)
-		c.socket.NetConn().Close()
+		c.Socket().NetConn().Close()
+	}
+}
+
+// isBusy reports whether the connection is inside an event handler, waits for a reply,
+// or has messages to write or to be acknowledged.
+func (c *Conn) isBusy() bool {
+	if c.IsClosed() {
+		return false
 	}
+
+	if atomic.LoadUint32(c.isInsideHandler) == 1 || c.WriteQueueLen() > 0 {
+		return true
+	}
+
+	c.waitingMessagesMutex.RLock()
+	n := len(c.waitingMessages)
+	c.waitingMessagesMutex.RUnlock()
+	if n > 0 {
+		return true
+	}
+
+	c.outboxMutex.Lock()
+	o := c.outbox
+	c.outboxMutex.Unlock()
+	return o != nil && o.len() > 0
+}
+
+// CloseWithCode sends a websocket close frame of the "code" (see `CloseGoingAway` and e.t.c.)
+// and the "reason" to the remote side and closes the connection.
+// The close frame is not sent if the underline `Socket` does not complete the `SocketCloseWriter` interface.
+func (c *Conn) CloseWithCode(code int, reason string) {
+	if c.IsClosed() {
+		return
+	}
+
+	if s, ok := c.Socket().(SocketCloseWriter); ok {
+		s.WriteClose(code, reason, c.writeTimeout)
+	}
+
+	c.Close()
+}
+
+// Context returns the context of this connection, it is cancelled when the connection is closed.
+// It is the parent context of the incoming messages, see `Message.Context`.
+// On server-side it carries the values of the HTTP request's context.
+// A client-side connection keeps its context across reconnections.
+func (c *Conn) Context() context.Context {
+	return c.ctx
 }
 
 // IsClosed method reports whether this connection is remotely or manually terminated.
@@ -36,7 +36,24 @@ func (e Events) GetNamespaces() Namespaces {
 	return Namespaces{"": e}
 }
 
-func (e Events) fireEvent(c *NSConn, msg Message) error {
+func (e Events) fireEvent(c *NSConn, msg Message) (err error) {
+	if c == nil || c.handler == nil {
+		return e.handler(nil)(c, msg)
+	}
+
+	if c.Conn != nil && c.Conn.server != nil {
+		defer c.Conn.recoverEvent(msg, &err)
+	}
+
+	return c.handler(c, msg)
+}
+
+// dispatch calls the event's callback, without the middlewares.
+func (e Events) dispatch(c *NSConn, msg Message) error {
+	if msg.Event == middlewaresEvent {
+		return nil
+	}
+
 	if h, ok := e[msg.Event]; ok {
 		return h(c, msg)
 	}
@@ -123,11 +140,14 @@ type Struct struct {
 	// then it matches the events based on the result string or false if this method shouldn't register as event.
 	eventMatcher              EventMatcherFunc
 	readTimeout, writeTimeout time.Duration
+	// defaults to false, see `SetTypedMethods`.
+	typedMethods bool
 
 	// This field is set when external dependency injection system is used.
-	injector StructInjector
+	injector StructMessageInjector
 
-	events Events
+	middlewares []Middleware
+	events      Events
 }
 
 // SetNamespace sets a namespace that this Struct is responsible for,
@@ -170,6 +190,19 @@ func (s *Struct) SetEventMatcher(matcher EventMatcherFunc) *Struct {
 	return s
 }
 
+// SetTypedMethods, if true, registers the methods which accept a typed value
+// instead of the message as events too, i.e. func(nsConn *neffos.NSConn, v T) error
+// or func(v T) error for structs which contain a *neffos.NSConn field,
+// the incoming message's body is decoded to T using the namespace's `Codec`, see `On`.
+// Keep note that every exported method of that form becomes an event which the remote side can fire,
+// use the `SetEventMatcher` to limit them.
+//
+// Defaults to false, only the methods which accept a `Message` are registered.
+func (s *Struct) SetTypedMethods(enable bool) *Struct {
+	s.typedMethods = enable
+	return s
+}
+
 // SetTimeouts sets read and write deadlines on the underlying network connection.
 // After a read or write have timed out, the websocket connection is closed.
 //
@@ -189,6 +222,21 @@ func (s *Struct) SetTimeouts(read, write time.Duration) *Struct {
 // The caller should return a
 // valid type of "ptr" reflect.Value.
 func (s *Struct) SetInjector(fn StructInjector) *Struct {
+	if fn == nil {
+		s.injector = nil
+		return s
+	}
+
+	s.injector = func(structType reflect.Type, nsConn *NSConn, _ Message) reflect.Value {
+		return fn(structType, nsConn)
+	}
+	return s
+}
+
+// SetMessageInjector acts like the `SetInjector` but the "fn" accepts
+// the `OnNamespaceConnect` message as well, i.e. to fill fields
+// from the namespace connect message's `Message.Header`.
+func (s *Struct) SetMessageInjector(fn StructMessageInjector) *Struct {
 	s.injector = fn
 	return s
 }
@@ -204,6 +252,7 @@ func (s *Struct) SetInjector(fn StructInjector) *Struct {
 // and static fields(if any) are set on runtime with the NSConn itself.
 // If it's a static controller (does not contain a NSConn field)
 // then it just registers its functions as regular events without performance cost.
+// Methods can also accept a typed value instead of the message, see `Struct.SetTypedMethods`.
 //
 // Users of this method is `New` and `Dial`.
 //
@@ -263,7 +312,8 @@ func (s *Struct) Events() Events {
 		return s.events
 	}
 
-	s.events = makeEventsFromStruct(s.ptr, s.eventMatcher, s.injector)
+	s.events = makeEventsFromStruct(s.ptr, s.eventMatcher, s.injector, s.typedMethods)
+	s.events.Use(s.middlewares...)
 	return s.events
 }
 
@@ -294,7 +344,10 @@ func JoinConnHandlers(connHandlers ...ConnHandler) ConnHandler {
 				}
 				clonedEvents := make(Events, len(events))
 				for evt, cb := range events {
-					clonedEvents[evt] = cb
+					if evt != middlewaresEvent {
+						// merged below.
+						clonedEvents[evt] = cb
+					}
 				}
 
 				if curEvents, exists := namespaces[namespace]; exists {
@@ -302,9 +355,10 @@ func JoinConnHandlers(connHandlers ...ConnHandler) ConnHandler {
 					for evt, cb := range clonedEvents {
 						curEvents[evt] = cb
 					}
-
+					// keep the middlewares of both.
+					curEvents.Use(events.middlewares()...)
 				} else {
-					namespaces[namespace] = clonedEvents
+					namespaces[namespace] = clonedEvents.Use(events.middlewares()...)
 				}
 			}
 		}
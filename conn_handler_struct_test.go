@@ -1,7 +1,10 @@
 package neffos
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -77,7 +80,7 @@ type testStructDynamicEmbedded struct {
 }
 
 func (s *testStructDynamicEmbedded) OnMyEvent(msg Message) error {
-	return fmt.Errorf(s.namespace)
+	return fmt.Errorf("%s", s.namespace)
 }
 
 func TestConnHandlerStructDynamicEmbedded(t *testing.T) {
@@ -94,3 +97,182 @@ func TestConnHandlerStructDynamicEmbedded(t *testing.T) {
 		t.Fatalf("expected output error to be: %v but got: %v", s.namespace, err)
 	}
 }
+
+type testStructTypedPayload struct {
+	Text string
+}
+
+type testStructTyped struct {
+	Conn *NSConn
+}
+
+func (s *testStructTyped) OnMyEvent(v testStructTypedPayload) error {
+	return fmt.Errorf("%s:%s", s.Conn.namespace, v.Text)
+}
+
+func TestConnHandlerStructTyped(t *testing.T) {
+	if _, ok := NewStruct(new(testStructTyped)).SetNamespace("default").Events()["OnMyEvent"]; ok {
+		t.Fatalf("expected typed methods to not be registered as events by default")
+	}
+
+	v := new(testStructTypedPayload)
+	s := NewStruct(new(testStructTyped)).SetNamespace("default").SetTypedMethods(true)
+	nss := s.GetNamespaces()
+
+	if _, ok := nss[s.namespace]["Disconnect"]; ok {
+		t.Fatalf("expected methods of NSConn to not be registered as events")
+	}
+
+	nsConn := &NSConn{Conn: new(Conn), namespace: s.namespace}
+	nss[s.namespace][OnNamespaceConnect](nsConn, Message{Namespace: s.namespace})
+
+	v.Text = "text"
+	err := nss[s.namespace]["OnMyEvent"](nsConn, Message{Body: Marshal(v)})
+	if expected, got := s.namespace+":"+v.Text, err.Error(); expected != got {
+		t.Fatalf("expected output error to be: %v but got: %v", expected, got)
+	}
+
+	err = nss[s.namespace]["OnMyEvent"](nsConn, Message{Body: []byte("{invalid")})
+	if !errors.Is(err, ErrDecode) {
+		t.Fatalf("expected a decode error but got: %v", err)
+	}
+
+	if expected, got := ErrDecode, resolveError(err.Error()); expected != got {
+		t.Fatalf("expected the remote side to resolve the error to: %v but got: %v", expected, got)
+	}
+}
+
+func TestConnHandlerStructMiddleware(t *testing.T) {
+	errMiddleware := fmt.Errorf("from middleware")
+
+	v := new(testStructStatic)
+	v.Err = fmt.Errorf("from static")
+	s := NewStruct(v).Use(func(next MessageHandlerFunc) MessageHandlerFunc {
+		return func(c *NSConn, msg Message) error {
+			if msg.Body == nil {
+				return errMiddleware
+			}
+
+			return next(c, msg)
+		}
+	})
+	events := s.GetNamespaces()[s.namespace]
+
+	if expected, got := 1, events.len(); expected != got {
+		t.Fatalf("expected %d events but got: %d", expected, got)
+	}
+
+	if err := events.fireEvent(nil, Message{Event: "OnMyEvent"}); err != errMiddleware {
+		t.Fatalf("expected output error to be: %v but got: %v", errMiddleware, err)
+	}
+
+	if err := events.fireEvent(nil, Message{Event: "OnMyEvent", Body: []byte("data")}); err != v.Err {
+		t.Fatalf("expected output error to be: %v but got: %v", v.Err, err)
+	}
+}
+
+type testStructOther struct{}
+
+func (s *testStructOther) Namespace() string {
+	return "default"
+}
+
+func (s *testStructOther) OnOtherEvent(c *NSConn, msg Message) error {
+	return nil
+}
+
+func TestConnHandlerStructJoinMiddleware(t *testing.T) {
+	var called []string
+	record := func(name string) Middleware {
+		return func(next MessageHandlerFunc) MessageHandlerFunc {
+			return func(c *NSConn, msg Message) error {
+				called = append(called, name)
+				return next(c, msg)
+			}
+		}
+	}
+
+	s1 := NewStruct(new(testStructStatic)).Use(record("s1"))
+	s2 := NewStruct(new(testStructOther)).Use(record("s2"))
+	events := JoinConnHandlers(s1, s2).GetNamespaces()["default"]
+
+	if expected, got := 2, events.len(); expected != got {
+		t.Fatalf("expected %d events but got: %d", expected, got)
+	}
+
+	for _, event := range []string{"OnMyEvent", "OnOtherEvent", OnNamespaceConnect} {
+		called = nil
+		events.fireEvent(nil, Message{Event: event})
+
+		if expected, got := "s1,s2", strings.Join(called, ","); expected != got {
+			t.Fatalf("[%s] expected the middlewares: %s to be called but got: %s", event, expected, got)
+		}
+	}
+}
+
+func TestEventsMiddlewareCopy(t *testing.T) {
+	var called []string
+	events := Events{
+		"event": func(c *NSConn, msg Message) error {
+			called = append(called, "handler")
+			return nil
+		},
+	}.Use(func(next MessageHandlerFunc) MessageHandlerFunc {
+		return func(c *NSConn, msg Message) error {
+			called = append(called, "middleware")
+			return next(c, msg)
+		}
+	})
+
+	// a copy of the map keeps the middlewares.
+	copied := make(Events, len(events))
+	for evt, cb := range events {
+		copied[evt] = cb
+	}
+
+	// the chain is resolved when the namespace is connected.
+	ns := newNSConn(nil, "default", copied)
+	if err := copied.fireEvent(ns, Message{Event: "event"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "middleware,handler", strings.Join(called, ","); expected != got {
+		t.Fatalf("expected the calls: %s but got: %s", expected, got)
+	}
+
+	// the middlewares entry is never fired as an event.
+	called = nil
+	if err := copied.fireEvent(ns, Message{Event: middlewaresEvent}); err != nil {
+		t.Fatalf("expected the middlewares entry to not be fired but got: %v", err)
+	}
+
+	if expected, got := "middleware", strings.Join(called, ","); expected != got {
+		t.Fatalf("expected the calls: %s but got: %s", expected, got)
+	}
+}
+
+type testStructMessageInjected struct {
+	Conn  *NSConn
+	Token string
+}
+
+func (s *testStructMessageInjected) OnMyEvent(msg Message) error {
+	return fmt.Errorf("%s", s.Token)
+}
+
+func TestConnHandlerStructMessageInjector(t *testing.T) {
+	s := NewStruct(new(testStructMessageInjected)).SetNamespace("default").SetMessageInjector(func(typ reflect.Type, nsConn *NSConn, msg Message) reflect.Value {
+		v := reflect.New(typ)
+		v.Interface().(*testStructMessageInjected).Token = msg.Header["token"]
+		return v
+	})
+	nss := s.GetNamespaces()
+
+	nsConn := &NSConn{namespace: s.namespace}
+	nss[s.namespace][OnNamespaceConnect](nsConn, Message{Namespace: s.namespace, Header: map[string]string{"token": "secret"}})
+
+	err := nss[s.namespace]["OnMyEvent"](nsConn, Message{})
+	if expected, got := "secret", err.Error(); expected != got {
+		t.Fatalf("expected output error to be: %v but got: %v", expected, got)
+	}
+}
@@ -18,6 +18,9 @@ type NSConn struct {
 	namespace string
 	// Static from server, client can select which to use or not.
 	events Events
+	// the events' callbacks wrapped by the server's and the events' middlewares,
+	// resolved when the namespace is connected, see `Events.handler`.
+	handler MessageHandlerFunc
 
 	// Dynamically channels/rooms for each connected namespace.
 	// Client can ask to join, server can forcely join a connection to a room.
@@ -25,16 +28,26 @@ type NSConn struct {
 	rooms      map[string]*Room
 	roomsMutex sync.RWMutex
 
+	// connectHeader is the header of the client-side namespace connect message,
+	// it is sent again on reconnection, see `Conn.ConnectWithHeader`.
+	connectHeader map[string]string
+
 	// value is just a temporarily value.
 	// Storage across event callbacks for this namespace.
 	value reflect.Value
 }
 
 func newNSConn(c *Conn, namespace string, events Events) *NSConn {
+	var middlewares []Middleware
+	if c != nil && c.server != nil {
+		middlewares = c.server.middlewares
+	}
+
 	return &NSConn{
 		Conn:      c,
 		namespace: namespace,
 		events:    events,
+		handler:   events.handler(middlewares),
 		rooms:     make(map[string]*Room),
 	}
 }
@@ -66,6 +79,16 @@ func (ns *NSConn) Emit(event string, body []byte) bool {
 	return ns.Conn.Write(Message{Namespace: ns.namespace, Event: event, Body: body})
 }
 
+// EmitWithHeader acts like `Emit` but it sends the "header" along with the message,
+// the remote side can read it through the `Message.Header`.
+func (ns *NSConn) EmitWithHeader(event string, body []byte, header map[string]string) bool {
+	if ns == nil {
+		return false
+	}
+
+	return ns.Conn.Write(Message{Namespace: ns.namespace, Event: event, Body: body, Header: header})
+}
+
 // EmitBinary acts like `Emit` but it sets the `Message.SetBinary` to true
 // and sends the data as binary, the receiver's Message in javascript-side is Uint8Array.
 func (ns *NSConn) EmitBinary(event string, body []byte) bool {
@@ -153,6 +176,7 @@ func (ns *NSConn) forceLeaveAll(isLocal bool) {
 		ns.events.fireEvent(ns, leaveMsg)
 
 		delete(ns.rooms, room)
+		ns.trackRoom(room, false)
 
 		leaveMsg.Event = OnRoomLeft
 		ns.events.fireEvent(ns, leaveMsg)
@@ -202,6 +226,7 @@ func (ns *NSConn) askRoomJoin(ctx context.Context, roomName string) (*Room, erro
 	ns.roomsMutex.Lock()
 	ns.rooms[roomName] = room
 	ns.roomsMutex.Unlock()
+	ns.trackRoom(roomName, true)
 
 	joinMsg.Event = OnRoomJoined
 	ns.events.fireEvent(ns, joinMsg)
@@ -217,6 +242,13 @@ func (ns *NSConn) replyRoomJoin(msg Message) {
 	_, ok := ns.rooms[msg.Room]
 	ns.roomsMutex.RUnlock()
 	if !ok {
+		if ns.exceedsMaxRooms() {
+			msg.Err = ErrTooManyRooms
+			ns.Conn.Write(msg)
+			ns.Conn.CloseWithCode(ClosePolicyViolation, ErrTooManyRooms.Error())
+			return
+		}
+
 		err := ns.events.fireEvent(ns, msg)
 		if err != nil {
 			msg.Err = err
@@ -226,6 +258,7 @@ func (ns *NSConn) replyRoomJoin(msg Message) {
 		ns.roomsMutex.Lock()
 		ns.rooms[msg.Room] = newRoom(ns, msg.Room)
 		ns.roomsMutex.Unlock()
+		ns.trackRoom(msg.Room, true)
 
 		msg.Event = OnRoomJoined
 		ns.events.fireEvent(ns, msg)
@@ -271,6 +304,7 @@ func (ns *NSConn) askRoomLeave(ctx context.Context, msg Message, lock bool) erro
 	if lock {
 		ns.roomsMutex.Unlock()
 	}
+	ns.trackRoom(msg.Room, false)
 
 	msg.Event = OnRoomLeft
 	ns.events.fireEvent(ns, msg)
@@ -315,6 +349,7 @@ func (ns *NSConn) replyRoomLeave(msg Message) {
 	ns.roomsMutex.Lock()
 	delete(ns.rooms, msg.Room)
 	ns.roomsMutex.Unlock()
+	ns.trackRoom(msg.Room, false)
 
 	msg.Event = OnRoomLeft
 	ns.events.fireEvent(ns, msg)
@@ -5,8 +5,12 @@ import (
 	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/kataras/neffos"
+
+	gobwas "github.com/kataras/neffos/gobwas"
+	gorilla "github.com/kataras/neffos/gorilla"
 )
 
 func TestJoinAndLeaveRoom(t *testing.T) {
@@ -163,3 +167,171 @@ func TestJoinAndLeaveRoomInsideHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestServerRoomRegistry(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		roomName  = "room1"
+		body      = []byte("data")
+		servers   []*neffos.Server
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"event": func(c *neffos.NSConn, msg neffos.Message) error {
+					if c.Conn.IsClient() {
+						if msg.Room != roomName || !bytes.Equal(msg.Body, body) {
+							t.Fatalf("expected a room message of: %s but got: %#+v", roomName, msg)
+						}
+						wg.Done()
+					}
+
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		servers = append(servers, s)
+	})
+	defer teardownServer()
+
+	var clients []*neffos.NSConn
+	for i := 0; i < 2; i++ {
+		teardownClient := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if i == 0 {
+				if _, err = c.JoinRoom(context.TODO(), roomName); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			clients = append(clients, c)
+		})
+		defer teardownClient()
+	}
+
+	for _, s := range servers {
+		if expected, got := 1, s.GetTotalRoomMembers(namespace, roomName); expected != got {
+			t.Fatalf("expected %d room members but got %d", expected, got)
+		}
+
+		if rooms := s.GetRooms(namespace); len(rooms) != 1 || rooms[0] != roomName {
+			t.Fatalf("expected rooms to be [%s] but got %v", roomName, rooms)
+		}
+
+		members := s.GetRoomMembers(namespace, roomName)
+		if len(members) != 1 || members[0].Room(roomName) == nil {
+			t.Fatalf("expected a single joined member but got %v", members)
+		}
+
+		wg.Add(1)
+		s.Broadcast(nil, neffos.Message{Namespace: namespace, Room: roomName, Event: "event", Body: body})
+	}
+	wg.Wait()
+
+	for _, c := range clients {
+		if room := c.Room(roomName); room != nil {
+			if err := room.Leave(context.TODO()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, s := range servers {
+		if got := s.GetTotalRoomMembers(namespace, roomName); got != 0 {
+			t.Fatalf("expected zero room members after leave but got %d", got)
+		}
+
+		if rooms := s.GetRooms(namespace); len(rooms) != 0 {
+			t.Fatalf("expected no rooms after leave but got %v", rooms)
+		}
+	}
+}
+
+func TestServerRoomBroadcastOrder(t *testing.T) {
+	var (
+		namespace = "default"
+		roomName  = "room1"
+		servers   = make(map[string]*neffos.Server)
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(s *neffos.Server) {
+		if len(servers) == 0 {
+			servers["gobwas"] = s
+		} else {
+			servers["gorilla"] = s
+		}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		connect := func(join bool) chan string {
+			received := make(chan string, 10)
+			events := neffos.Namespaces{namespace: neffos.Events{
+				"event": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- string(msg.Body)
+					return nil
+				},
+			}}
+
+			client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer, events)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { client.Close() })
+
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if join {
+				if _, err = c.JoinRoom(context.TODO(), roomName); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			return received
+		}
+
+		expect := func(received chan string, bodies ...string) {
+			t.Helper()
+
+			for _, body := range bodies {
+				select {
+				case got := <-received:
+					if got != body {
+						t.Fatalf("[%s] expected the message %q but got %q", dialer, body, got)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("[%s] expected the message %q", dialer, body)
+				}
+			}
+
+			select {
+			case got := <-received:
+				t.Fatalf("[%s] expected no more messages but got %q", dialer, got)
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+
+		member, other := connect(true), connect(false)
+
+		// the room messages are sent through the broadcaster, in order with the rest.
+		servers[dialer].Broadcast(nil,
+			neffos.Message{Namespace: namespace, Event: "event", Body: []byte("1")},
+			neffos.Message{Namespace: namespace, Room: roomName, Event: "event", Body: []byte("room")},
+			neffos.Message{Namespace: namespace, Room: "empty", Event: "event", Body: []byte("empty")},
+			neffos.Message{Namespace: namespace, Event: "event", Body: []byte("2")},
+		)
+
+		expect(member, "1", "room", "2")
+		expect(other, "1", "2")
+	}
+}
@@ -0,0 +1,245 @@
+package neffos
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultOutboxSize is the default maximum number of unacknowledged messages
+// that a connection retains for at-least-once delivery.
+// See `Server.OutboxSize`, `Client.OutboxSize` and `NSConn.EmitReliable`.
+var DefaultOutboxSize = 1024
+
+// DefaultOutboxTTL is the default time that a server keeps the unacknowledged messages
+// of a closed connection, waiting for a connection with the same ID to take them over.
+// See `Server.OutboxTTL`.
+var DefaultOutboxTTL = time.Minute
+
+// outbox keeps the messages which were sent with at-least-once delivery
+// and are not acknowledged by the remote side yet.
+//
+// A message with at-least-once delivery carries a wait token
+// prefixed with `waitIsDeliveryPrefix` followed by its sequence number,
+// the receiver replies with an empty message of the same wait token after
+// the message was handled. See `Conn#handleMessage`.
+type outbox struct {
+	seq     uint64
+	max     int
+	pending []Message
+	mu      sync.Mutex
+}
+
+func newOutbox(max int) *outbox {
+	if max <= 0 {
+		max = DefaultOutboxSize
+	}
+
+	return &outbox{max: max}
+}
+
+// add fills the message's wait token and keeps it,
+// it reports false if the outbox is full.
+func (o *outbox) add(msg *Message) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.pending) >= o.max {
+		return false
+	}
+
+	o.seq++
+	msg.wait = string(waitIsDeliveryPrefix) + strconv.FormatUint(o.seq, 10)
+	o.pending = append(o.pending, *msg)
+	return true
+}
+
+// confirm removes the message of the "wait" token, if still pending.
+func (o *outbox) confirm(wait string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i, msg := range o.pending {
+		if msg.wait == wait {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// list returns a copy of the pending messages of a "namespace", in order.
+func (o *outbox) list(namespace string) []Message {
+	o.mu.Lock()
+	var msgs []Message
+	for _, msg := range o.pending {
+		if msg.Namespace == namespace {
+			msgs = append(msgs, msg)
+		}
+	}
+	o.mu.Unlock()
+
+	return msgs
+}
+
+func (o *outbox) len() int {
+	o.mu.Lock()
+	n := len(o.pending)
+	o.mu.Unlock()
+
+	return n
+}
+
+func isDeliveryWait(wait string) bool {
+	return len(wait) > 1 && wait[0] == waitIsDeliveryPrefix
+}
+
+func (c *Conn) getOutbox() *outbox {
+	c.outboxMutex.Lock()
+	if c.outbox == nil {
+		max := 0
+		if c.server != nil {
+			max = c.server.OutboxSize
+		} else if c.client != nil {
+			max = c.client.OutboxSize
+		}
+
+		c.outbox = newOutbox(max)
+	}
+	o := c.outbox
+	c.outboxMutex.Unlock()
+
+	return o
+}
+
+// WriteReliable sends a message to the remote side with at-least-once delivery.
+// The message is kept until the remote side acknowledges that it was handled,
+// the remote side does not acknowledge it if its event callback returned an error
+// or its namespace is not connected, and it is sent again after a reconnection (when the namespace is connected again),
+// therefore the remote side's event callback may receive the same message more than once.
+//
+// It reports false when the message can not be written to the namespace (see `Write`),
+// it is a system or native message or the connection's outbox is full
+// (see `Server.OutboxSize` and `Client.OutboxSize`).
+// A failed socket write is not reported, the message is retained and sent again later.
+func (c *Conn) WriteReliable(msg Message) bool {
+	if msg.Event == "" || msg.IsNative || IsSystemEvent(msg.Event) || msg.wait != "" {
+		return false
+	}
+
+	if !c.canWrite(msg) {
+		return false
+	}
+
+	if !c.getOutbox().add(&msg) {
+		return false
+	}
+
+	c.Write(msg)
+	return true
+}
+
+// UnacknowledgedMessages returns the number of messages sent through `WriteReliable`
+// which are not acknowledged by the remote side yet.
+func (c *Conn) UnacknowledgedMessages() int {
+	c.outboxMutex.Lock()
+	o := c.outbox
+	c.outboxMutex.Unlock()
+
+	if o == nil {
+		return 0
+	}
+
+	return o.len()
+}
+
+// confirmDelivery is called when the remote side acknowledged an at-least-once message.
+func (c *Conn) confirmDelivery(wait string) {
+	c.outboxMutex.Lock()
+	o := c.outbox
+	c.outboxMutex.Unlock()
+
+	if o != nil {
+		o.confirm(wait)
+	}
+}
+
+// replayDeliveries sends again the unacknowledged messages of a "namespace",
+// it's called when the namespace is connected (again).
+func (c *Conn) replayDeliveries(namespace string) {
+	c.outboxMutex.Lock()
+	o := c.outbox
+	c.outboxMutex.Unlock()
+
+	if o == nil {
+		return
+	}
+
+	for _, msg := range o.list(namespace) {
+		c.Write(msg)
+	}
+}
+
+// parkOutbox keeps the unacknowledged messages of a closed server-side connection
+// for `OutboxTTL`, a new connection with the same ID takes them over, see `takeOutbox`.
+func (s *Server) parkOutbox(c *Conn) {
+	c.outboxMutex.Lock()
+	o := c.outbox
+	c.outboxMutex.Unlock()
+
+	if o == nil || o.len() == 0 {
+		return
+	}
+
+	ttl := s.OutboxTTL
+	if ttl <= 0 {
+		ttl = DefaultOutboxTTL
+	}
+
+	s.outboxesMutex.Lock()
+	s.outboxes[c.ID()] = o
+	s.outboxesMutex.Unlock()
+
+	time.AfterFunc(ttl, func() {
+		s.outboxesMutex.Lock()
+		if s.outboxes[c.ID()] == o {
+			delete(s.outboxes, c.ID())
+		}
+		s.outboxesMutex.Unlock()
+	})
+}
+
+func (s *Server) takeOutbox(id string) *outbox {
+	s.outboxesMutex.Lock()
+	o, ok := s.outboxes[id]
+	if ok {
+		delete(s.outboxes, id)
+	}
+	s.outboxesMutex.Unlock()
+
+	return o
+}
+
+// EmitReliable method sends a message to the remote side
+// with its `Message.Namespace` filled to this specific namespace,
+// with at-least-once delivery. See `Conn.WriteReliable` for details.
+func (ns *NSConn) EmitReliable(event string, body []byte) bool {
+	if ns == nil {
+		return false
+	}
+
+	return ns.Conn.WriteReliable(Message{Namespace: ns.namespace, Event: event, Body: body})
+}
+
+// EmitReliable method sends a message to the remote side with its `Message.Room` filled to this specific room
+// and `Message.Namespace` to the underline `NSConn`'s namespace, with at-least-once delivery.
+// See `Conn.WriteReliable` for details.
+func (r *Room) EmitReliable(event string, body []byte) bool {
+	return r.NSConn.Conn.WriteReliable(Message{
+		Namespace: r.NSConn.namespace,
+		Room:      r.Name,
+		Event:     event,
+		Body:      body,
+	})
+}
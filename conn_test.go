@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/kataras/neffos"
 )
@@ -422,3 +423,210 @@ func TestOnNativeMessageOnly(t *testing.T) {
 // 		t.Fatal(err)
 // 	}
 // }
+
+func TestEmitReliable(t *testing.T) {
+	var (
+		wg        sync.WaitGroup
+		namespace = "default"
+		body      = []byte("order status")
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"close": func(c *neffos.NSConn, msg neffos.Message) error {
+					c.Conn.Close()
+					return nil
+				},
+				"status": func(c *neffos.NSConn, msg neffos.Message) error {
+					if !bytes.Equal(msg.Body, body) {
+						t.Fatalf("expected body: %s but got: %s", string(body), string(msg.Body))
+					}
+
+					if !c.Conn.IsClient() {
+						if !c.EmitReliable("status", msg.Body) {
+							t.Fatalf("expected reliable emit to be accepted")
+						}
+					}
+
+					wg.Done()
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		var c *neffos.NSConn
+		reconnected := make(chan struct{})
+		client.Reconnect = &neffos.ReconnectPolicy{MaxAttempts: 3, Delay: 50 * time.Millisecond}
+		client.OnReconnecting = func(attempt int, err error) {
+			// the socket is broken, it should be sent after reconnection.
+			if !c.EmitReliable("status", body) {
+				t.Fatalf("[%s] expected reliable emit to be accepted while reconnecting", dialer)
+			}
+		}
+		client.OnReconnected = func(attempt int) {
+			close(reconnected)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(2) // server and client.
+		c.Emit("close", nil)
+		<-reconnected
+		wg.Wait()
+
+		for i := 0; i < 50 && c.Conn.UnacknowledgedMessages() > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if n := c.Conn.UnacknowledgedMessages(); n != 0 {
+			t.Fatalf("[%s] expected all messages to be acknowledged but %d are pending", dialer, n)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmitReliableHandlerError(t *testing.T) {
+	var (
+		namespace = "default"
+		body      = []byte("order status")
+		received  = make(chan error, 10)
+		failed    sync.Map
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{
+		namespace: neffos.Events{
+			"status": func(c *neffos.NSConn, msg neffos.Message) error {
+				// the first delivery of each connection fails, it's not acknowledged.
+				if _, loaded := failed.LoadOrStore(c.Conn, struct{}{}); !loaded {
+					received <- errors.New("failed")
+					return errors.New("failed")
+				}
+
+				received <- nil
+				return nil
+			},
+		},
+	})
+	defer teardownServer()
+
+	err := runTestClient("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(dialer string, client *neffos.Client) {
+		defer client.Close()
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expect := func(expectedErr bool) {
+			t.Helper()
+
+			select {
+			case err := <-received:
+				if got := err != nil; got != expectedErr {
+					t.Fatalf("[%s] expected the handler to fail: %v but got: %v", dialer, expectedErr, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("[%s] expected the message to be delivered", dialer)
+			}
+		}
+
+		if !c.EmitReliable("status", body) {
+			t.Fatalf("[%s] expected reliable emit to be accepted", dialer)
+		}
+		expect(true)
+
+		time.Sleep(50 * time.Millisecond)
+		if expected, got := 1, c.Conn.UnacknowledgedMessages(); expected != got {
+			t.Fatalf("[%s] expected %d unacknowledged message after the handler's error but got %d", dialer, expected, got)
+		}
+
+		// the message is sent again when the namespace is connected again.
+		if err = c.Disconnect(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+		if c, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+		expect(false)
+
+		for i := 0; i < 50 && c.Conn.UnacknowledgedMessages() > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if n := c.Conn.UnacknowledgedMessages(); n != 0 {
+			t.Fatalf("[%s] expected the message to be acknowledged but %d are pending", dialer, n)
+		}
+	})()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageContext(t *testing.T) {
+	var (
+		namespace = "default"
+		errs      = make(chan error, 1)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"wait": func(c *neffos.NSConn, msg neffos.Message) error {
+					<-msg.Context().Done()
+					errs <- msg.Context().Err()
+					return nil
+				},
+				"background": func(c *neffos.NSConn, msg neffos.Message) error {
+					ctx := msg.Context()
+					go func() {
+						<-ctx.Done()
+						errs <- ctx.Err()
+					}()
+					return nil
+				},
+			},
+		}
+	)
+
+	expectErr := func(dialer string, expected error) {
+		select {
+		case err := <-errs:
+			if err != expected {
+				t.Fatalf("[%s] expected the message's context error to be: %v but got: %v", dialer, expected, err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%s] the message's context was not done", dialer)
+		}
+	}
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		if _, err = c.Ask(ctx, "wait", nil); err != context.DeadlineExceeded {
+			t.Fatalf("[%s] expected ask error: %v but got: %v", dialer, context.DeadlineExceeded, err)
+		}
+		// the remote side's Ask deadline expires the context of the message.
+		expectErr(dialer, context.DeadlineExceeded)
+
+		c.Emit("background", nil)
+		time.Sleep(50 * time.Millisecond)
+		client.Close()
+		// the connection's close cancels the context of the message.
+		expectErr(dialer, context.Canceled)
+	})
+}
@@ -0,0 +1,237 @@
+package neffos
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// WriteQueuePolicy describes what a connection's write queue does
+// when it's full, see `Server.WriteQueueSize`.
+type WriteQueuePolicy uint8
+
+const (
+	// WriteQueueDropOldest drops the oldest queued message to make room for the new one.
+	WriteQueueDropOldest WriteQueuePolicy = iota
+	// WriteQueueDropNewest drops the new message, the `Conn.Write` returns false.
+	WriteQueueDropNewest
+	// WriteQueueDisconnect closes the slow connection with the `ErrWriteQueueFull` error.
+	WriteQueueDisconnect
+	// WriteQueueBlock blocks the writer until there is room for the new message
+	// or the `Server.WriteQueueTimeout` passed, then the new message is dropped.
+	WriteQueueBlock
+)
+
+// String returns the name of the policy.
+func (p WriteQueuePolicy) String() string {
+	switch p {
+	case WriteQueueDropOldest:
+		return "drop_oldest"
+	case WriteQueueDropNewest:
+		return "drop_newest"
+	case WriteQueueDisconnect:
+		return "disconnect"
+	case WriteQueueBlock:
+		return "block"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultWriteQueueTimeout is the default maximum time that a write waits
+// for room in a full write queue of the `WriteQueueBlock` policy.
+// See `Server.WriteQueueTimeout`.
+var DefaultWriteQueueTimeout = 5 * time.Second
+
+// ErrWriteQueueFull is reported to the `Server.OnError` when a message was dropped
+// because the connection's write queue is full. It is the close error
+// of the connections which are closed by the `WriteQueueDisconnect` policy.
+var ErrWriteQueueFull = errors.New("write queue is full")
+
+// WriteQueueObserver is an optional interface which an `Observer`
+// can complete to be notified about the connections' write queues.
+type WriteQueueObserver interface {
+	// WriteQueueChanged is called when the length of a connection's write queue changed,
+	// "depth" is its new length and "delta" is the difference, i.e. +1 when a message is queued
+	// and -1 when a message is taken to be written or dropped.
+	WriteQueueChanged(c *Conn, depth, delta int)
+	// WriteQueueDropped is called when a message was dropped by the "policy" of a full write queue.
+	WriteQueueDropped(c *Conn, msg Message, policy WriteQueuePolicy)
+}
+
+// writeQueue is the bounded outgoing messages queue of a server-side connection,
+// its messages are written to the socket by a goroutine of its own,
+// so a slow connection does not block its writers, i.e. the `Server.Broadcast`.
+type writeQueue struct {
+	conn    *Conn
+	size    int
+	policy  WriteQueuePolicy
+	timeout time.Duration
+
+	messages []Message
+	mu       sync.Mutex
+	// notEmpty wakes the writer goroutine, notFull wakes the blocked writers.
+	notEmpty chan struct{}
+	notFull  chan struct{}
+}
+
+func newWriteQueue(c *Conn, size int, policy WriteQueuePolicy, timeout time.Duration) *writeQueue {
+	if timeout <= 0 {
+		timeout = DefaultWriteQueueTimeout
+	}
+
+	return &writeQueue{
+		conn:     c,
+		size:     size,
+		policy:   policy,
+		timeout:  timeout,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (q *writeQueue) observer() WriteQueueObserver {
+	o, _ := q.conn.observer().(WriteQueueObserver)
+	return o
+}
+
+func (q *writeQueue) dropped(msg Message) {
+	if o := q.observer(); o != nil {
+		o.WriteQueueDropped(q.conn, msg, q.policy)
+	}
+
+	q.conn.reportError(ErrWriteQueueFull)
+}
+
+// push queues the "msg", it reports false if the message was dropped by the queue's policy.
+func (q *writeQueue) push(msg Message) bool {
+	var timer *time.Timer
+
+	q.mu.Lock()
+	for len(q.messages) >= q.size {
+		switch q.policy {
+		case WriteQueueDropOldest:
+			oldest := q.messages[0]
+			q.messages[0] = Message{}
+			q.messages = q.messages[1:]
+			depth := len(q.messages)
+			q.mu.Unlock()
+			q.dropped(oldest)
+			if o := q.observer(); o != nil {
+				o.WriteQueueChanged(q.conn, depth, -1)
+			}
+			q.mu.Lock()
+		case WriteQueueBlock:
+			q.mu.Unlock()
+			if timer == nil {
+				timer = time.NewTimer(q.timeout)
+				defer timer.Stop()
+			}
+
+			select {
+			case <-q.notFull:
+			case <-q.conn.closeCh:
+				return false
+			case <-timer.C:
+				q.dropped(msg)
+				return false
+			}
+			q.mu.Lock()
+		case WriteQueueDisconnect:
+			q.mu.Unlock()
+			q.dropped(msg)
+			// the caller may hold the connection's locks, see `Message.locked`.
+			go q.conn.close(ErrWriteQueueFull)
+			return false
+		default: // WriteQueueDropNewest.
+			q.mu.Unlock()
+			q.dropped(msg)
+			return false
+		}
+	}
+
+	if q.conn.IsClosed() {
+		q.mu.Unlock()
+		return false
+	}
+
+	q.messages = append(q.messages, msg)
+	depth := len(q.messages)
+	q.mu.Unlock()
+
+	signal(q.notEmpty)
+	if o := q.observer(); o != nil {
+		o.WriteQueueChanged(q.conn, depth, 1)
+	}
+
+	return true
+}
+
+// pop returns the oldest queued message, if any.
+func (q *writeQueue) pop() (Message, int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.messages) == 0 {
+		return Message{}, 0, false
+	}
+
+	msg := q.messages[0]
+	q.messages[0] = Message{}
+	q.messages = q.messages[1:]
+	signal(q.notFull)
+	return msg, len(q.messages), true
+}
+
+// run writes the queued messages until the connection is closed,
+// the messages which are still queued are discarded then.
+func (q *writeQueue) run() {
+	for {
+		select {
+		case <-q.notEmpty:
+		case <-q.conn.closeCh:
+			q.mu.Lock()
+			n := len(q.messages)
+			q.messages = nil
+			q.mu.Unlock()
+
+			if o := q.observer(); o != nil && n > 0 {
+				o.WriteQueueChanged(q.conn, 0, -n)
+			}
+			return
+		}
+
+		for {
+			msg, depth, ok := q.pop()
+			if !ok {
+				break
+			}
+
+			if o := q.observer(); o != nil {
+				o.WriteQueueChanged(q.conn, depth, -1)
+			}
+
+			q.conn.writeMessageNow(msg)
+		}
+	}
+}
+
+// WriteQueueLen returns the number of the queued messages of this connection
+// which are not written to the socket yet, see `Server.WriteQueueSize`.
+func (c *Conn) WriteQueueLen() int {
+	if c.writeQueue == nil {
+		return 0
+	}
+
+	c.writeQueue.mu.Lock()
+	n := len(c.writeQueue.messages)
+	c.writeQueue.mu.Unlock()
+	return n
+}
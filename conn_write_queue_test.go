@@ -0,0 +1,59 @@
+package neffos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteQueuePolicies(t *testing.T) {
+	events := func(q *writeQueue) (s string) {
+		for _, msg := range q.messages {
+			s += msg.Event
+		}
+		return
+	}
+
+	newQueue := func(policy WriteQueuePolicy, timeout time.Duration) *writeQueue {
+		c := &Conn{closed: new(uint32), closeCh: make(chan struct{})}
+		return newWriteQueue(c, 2, policy, timeout)
+	}
+
+	var tests = []struct {
+		policy   WriteQueuePolicy
+		pushed   []bool
+		expected string
+	}{
+		{WriteQueueDropOldest, []bool{true, true, true}, "23"},
+		{WriteQueueDropNewest, []bool{true, true, false}, "12"},
+		{WriteQueueBlock, []bool{true, true, false}, "12"},
+	}
+
+	for _, tt := range tests {
+		q := newQueue(tt.policy, 50*time.Millisecond)
+		for i, event := range []string{"1", "2", "3"} {
+			if expected, got := tt.pushed[i], q.push(Message{Event: event}); expected != got {
+				t.Fatalf("[%s] expected push of %s to report %v but got %v", tt.policy, event, expected, got)
+			}
+		}
+
+		if got := events(q); tt.expected != got {
+			t.Fatalf("[%s] expected queued messages: %s but got: %s", tt.policy, tt.expected, got)
+		}
+	}
+
+	q := newQueue(WriteQueueBlock, 5*time.Second)
+	q.push(Message{Event: "1"})
+	q.push(Message{Event: "2"})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		q.pop()
+	}()
+
+	if !q.push(Message{Event: "3"}) {
+		t.Fatalf("expected a blocked push to succeed after a message was written")
+	}
+
+	if expected, got := "23", events(q); expected != got {
+		t.Fatalf("expected queued messages: %s but got: %s", expected, got)
+	}
+}
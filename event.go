@@ -1,6 +1,7 @@
 package neffos
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -57,16 +58,46 @@ func IsSystemEvent(event string) bool {
 	}
 }
 
+// The websocket close codes which neffos sends, see `Conn.CloseWithCode`.
+const (
+	// CloseNormal is the code of a normal closure.
+	CloseNormal = 1000
+	// CloseGoingAway is the code of a server which is going down.
+	CloseGoingAway = 1001
+	// ClosePolicyViolation is the code of a connection which violated the server's policy,
+	// i.e. exceeded its `RateLimits`.
+	ClosePolicyViolation = 1008
+	// CloseMessageTooBig is the code of a connection which sent a message that's too big to process.
+	CloseMessageTooBig = 1009
+	// CloseTryAgainLater is the code of a server which is overloaded.
+	CloseTryAgainLater = 1013
+)
+
 // CloseError can be used to send and close a remote connection in the event callback's return statement.
 type CloseError struct {
 	error
 	Code int
 }
 
+// NewCloseError returns a `CloseError` of the websocket close "code" and "reason",
+// the sockets return it when the remote side sent a close frame.
+func NewCloseError(code int, reason string) CloseError {
+	return CloseError{error: errors.New(reason), Code: code}
+}
+
 func (err CloseError) Error() string {
 	return fmt.Sprintf("[%d] %s", err.Code, err.error.Error())
 }
 
+// Reason returns the text of the close error, i.e. the reason of a close frame.
+func (err CloseError) Reason() string {
+	if err.error == nil {
+		return ""
+	}
+
+	return err.error.Error()
+}
+
 // IsDisconnectError reports whether the "err" is a timeout or a closed connection error.
 func IsDisconnectError(err error) bool {
 	if err == nil {
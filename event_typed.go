@@ -0,0 +1,85 @@
+package neffos
+
+import (
+	"context"
+)
+
+// On registers a typed callback "handler" for an event "eventName" of the "events".
+// The incoming `Message.Body` is decoded to a value of T, using the namespace's `Codec`,
+// before the "handler" is called. Decoding errors are sent back to the remote side as `ErrDecode`.
+//
+// The "handler" is not called for incoming messages that carry a remote error,
+// use the `OnTyped` to handle those as well.
+//
+// Usage:
+// neffos.On(events, "chat", func(c *neffos.NSConn, v ChatMessage) error {...})
+//
+// See `NamespaceOn`, `OnAsk` and `EmitTyped` too.
+func On[T any](events Events, eventName string, handler func(c *NSConn, v T) error) Events {
+	events.On(eventName, typedHandler(handler))
+	return events
+}
+
+// NamespaceOn is like `On` but it registers the typed callback "handler"
+// for an event "eventName" of the particular "namespace", see `Namespaces.On`.
+func NamespaceOn[T any](nss Namespaces, namespace, eventName string, handler func(c *NSConn, v T) error) Events {
+	return nss.On(namespace, eventName, typedHandler(handler))
+}
+
+func typedHandler[T any](handler func(c *NSConn, v T) error) MessageHandlerFunc {
+	return OnTyped(func(c *NSConn, msg Message, v T) error {
+		if msg.Err != nil {
+			return nil
+		}
+
+		return handler(c, v)
+	})
+}
+
+// OnAsk registers a typed callback "handler" which replies to the `Ask` calls
+// of an event "eventName" of the "events". The incoming `Message.Body` is decoded to a value of Req
+// and the "handler"'s Resp result is encoded and sent back to the remote side, see `Reply`.
+// A non-nil error result is sent back instead, as the remote side's `Message.Err`.
+func OnAsk[Req, Resp any](events Events, eventName string, handler func(c *NSConn, req Req) (Resp, error)) Events {
+	return On(events, eventName, func(c *NSConn, req Req) error {
+		resp, err := handler(c, req)
+		if err != nil {
+			return err
+		}
+
+		body, err := c.Codec().Marshal(resp)
+		if err != nil {
+			return err
+		}
+
+		return Reply(body)
+	})
+}
+
+// Ask encodes the "req" value using the namespace's `Codec`, sends it to the remote side
+// and blocks until a response or an error received, see `NSConn.Ask`.
+// The response's `Message.Body` is decoded to a value of Resp.
+// It returns the remote side's error, if any, or `ErrDecode` if the response could not be decoded.
+//
+// Usage:
+// resp, err := neffos.Ask[Question, Answer](ctx, nsConn, "question", Question{...})
+func Ask[Req, Resp any](ctx context.Context, ns *NSConn, event string, req Req) (Resp, error) {
+	var resp Resp
+
+	if ns == nil {
+		return resp, ErrWrite
+	}
+
+	msg, err := ns.encodeTyped(event, req)
+	if err != nil {
+		return resp, err
+	}
+
+	msg, err = ns.Conn.Ask(ctx, msg)
+	if err != nil {
+		return resp, err
+	}
+
+	err = ns.decodeTyped(msg.Body, &resp)
+	return resp, err
+}
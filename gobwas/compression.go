@@ -0,0 +1,155 @@
+package gobwas
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+
+	"github.com/kataras/neffos"
+
+	"github.com/gobwas/httphead"
+	gobwas "github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// parameters returns the permessage-deflate parameters of the "compression".
+func parameters(compression *neffos.Compression) wsflate.Parameters {
+	return wsflate.Parameters{
+		ServerNoContextTakeover: !compression.ServerContextTakeover,
+		ClientNoContextTakeover: !compression.ClientContextTakeover,
+	}
+}
+
+// extension negotiates the permessage-deflate extension on the server-side.
+// Unlike the `wsflate.Extension` it accepts the offers which ask for no context takeover,
+// i.e. of the gorilla clients, and responds with them.
+type extension struct {
+	want     wsflate.Parameters
+	params   wsflate.Parameters
+	accepted bool
+}
+
+func (e *extension) Negotiate(opt httphead.Option) (httphead.Option, error) {
+	if e.accepted || !bytes.Equal(opt.Name, wsflate.ExtensionNameBytes) {
+		return httphead.Option{}, nil
+	}
+
+	var offer wsflate.Parameters
+	if err := offer.Parse(opt); err != nil || offer.ServerMaxWindowBits.Defined() {
+		// decline, the server's window size is fixed.
+		return httphead.Option{}, nil
+	}
+
+	e.params = wsflate.Parameters{
+		ServerNoContextTakeover: e.want.ServerNoContextTakeover || offer.ServerNoContextTakeover,
+		ClientNoContextTakeover: e.want.ClientNoContextTakeover || offer.ClientNoContextTakeover,
+	}
+	e.accepted = true
+
+	return e.params.Option(), nil
+}
+
+// flateState is the permessage-deflate state of a socket.
+type flateState struct {
+	level int
+
+	writeTakeover bool
+	writer        *flate.Writer
+	buf           bytes.Buffer
+
+	readTakeover bool
+	reader       io.ReadCloser
+	// the last decompressed bytes, the dictionary of the next message on context takeover.
+	window []byte
+	// message reports whether the current incoming message is compressed.
+	message wsflate.MessageState
+}
+
+// enableCompression is called when the permessage-deflate extension is negotiated with the "params".
+func (s *Socket) enableCompression(params wsflate.Parameters, compression *neffos.Compression) {
+	f := &flateState{level: compression.CompressionLevel()}
+	if s.state.ClientSide() {
+		f.writeTakeover, f.readTakeover = !params.ClientNoContextTakeover, !params.ServerNoContextTakeover
+	} else {
+		f.writeTakeover, f.readTakeover = !params.ServerNoContextTakeover, !params.ClientNoContextTakeover
+	}
+
+	s.flate = f
+	// allows the RSV1 bit of the compressed frames.
+	s.state = s.state.Set(gobwas.StateExtended)
+	s.reader.State = s.state
+	s.reader.Extensions = []wsutil.RecvExtension{&f.message}
+	// the text messages are checked after they are decompressed, see `ReadData`.
+	s.reader.CheckUTF8 = false
+}
+
+// compress returns the compressed "body", without the tail of the sync flush,
+// see https://tools.ietf.org/html/rfc7692#section-7.2.1.
+// The result is valid until the next call.
+func (f *flateState) compress(body []byte) ([]byte, error) {
+	f.buf.Reset()
+	if f.writer == nil {
+		w, err := flate.NewWriter(&f.buf, f.level)
+		if err != nil {
+			return nil, err
+		}
+		f.writer = w
+	} else if !f.writeTakeover {
+		f.writer.Reset(&f.buf)
+	}
+
+	if _, err := f.writer.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := f.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	b := f.buf.Bytes()
+	if len(b) >= 4 {
+		b = b[:len(b)-4]
+	}
+
+	return b, nil
+}
+
+// the tail of the sync flush and a final empty block, so the reader does not expect more data.
+const flateTail = "\x00\x00\xff\xff\x01\x00\x00\xff\xff"
+
+// decompress returns the decompressed "payload", up to "limit"+1 bytes if "limit" is positive.
+func (f *flateState) decompress(payload []byte, limit int64) ([]byte, error) {
+	src := io.MultiReader(bytes.NewReader(payload), strings.NewReader(flateTail))
+
+	var dict []byte
+	if f.readTakeover {
+		dict = f.window
+	}
+
+	if f.reader == nil {
+		f.reader = flate.NewReaderDict(src, dict)
+	} else if err := f.reader.(flate.Resetter).Reset(src, dict); err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = f.reader
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.readTakeover {
+		f.window = append(f.window, b...)
+		if n := len(f.window); n > wsflate.MaxLZ77WindowSize {
+			f.window = append([]byte(nil), f.window[n-wsflate.MaxLZ77WindowSize:]...)
+		}
+	}
+
+	return b, nil
+}
@@ -1,11 +1,13 @@
 package gobwas
 
 import (
+	"bytes"
 	"context"
 
 	"github.com/kataras/neffos"
 
 	gobwas "github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
 )
 
 // DefaultDialer is a gobwas/ws dialer with all fields set to the default values.
@@ -14,13 +16,37 @@ var DefaultDialer = Dialer(gobwas.DefaultDialer)
 // Dialer is a `neffos.Dialer` type for the gobwas/ws subprotocol implementation.
 // Should be used on `Dial` to create a new client/client-side connection.
 // To send headers to the server set the dialer's `Header` field to a `gobwas.HandshakeHeaderHTTP`.
+// The `neffos.Client.Compression` negotiates the permessage-deflate extension.
 func Dialer(dialer gobwas.Dialer) neffos.Dialer {
 	return func(ctx context.Context, url string) (neffos.Socket, error) {
-		underline, _, _, err := dialer.Dial(ctx, url)
+		d := dialer
+		compression := neffos.CompressionFromContext(ctx)
+		if compression != nil {
+			d.Extensions = append(d.Extensions[:len(d.Extensions):len(d.Extensions)], parameters(compression).Option())
+		}
+
+		underline, _, hs, err := d.Dial(ctx, url)
 		if err != nil {
 			return nil, err
 		}
 
-		return newSocket(underline, nil, true), nil
+		socket := newSocket(underline, nil, true)
+		if compression != nil {
+			for _, opt := range hs.Extensions {
+				if !bytes.Equal(opt.Name, wsflate.ExtensionNameBytes) {
+					continue
+				}
+
+				var params wsflate.Parameters
+				if err = params.Parse(opt); err != nil {
+					underline.Close()
+					return nil, err
+				}
+				socket.enableCompression(params, compression)
+				break
+			}
+		}
+
+		return socket, nil
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/kataras/neffos"
 
@@ -23,6 +24,10 @@ type Socket struct {
 	reader         *wsutil.Reader
 	controlHandler wsutil.FrameHandlerFunc
 	state          gobwas.State
+	readLimit      int64
+	pongHandler    func(data []byte)
+	// non-nil when the permessage-deflate extension was negotiated.
+	flate *flateState
 
 	mu sync.Mutex
 }
@@ -33,9 +38,15 @@ func newSocket(underline net.Conn, request *http.Request, client bool) *Socket {
 		state = gobwas.StateClientSide
 	}
 
-	controlHandler := wsutil.ControlFrameHandler(underline, state)
+	s := &Socket{
+		UnderlyingConn: underline,
+		request:        request,
+		state:          state,
+	}
 
-	reader := &wsutil.Reader{
+	// control frames' replies (i.e. pongs) are written under the same lock as the messages.
+	s.controlHandler = wsutil.ControlFrameHandler(lockedWriter{s}, state)
+	s.reader = &wsutil.Reader{
 		Source:          underline,
 		State:           state,
 		CheckUTF8:       true,
@@ -44,16 +55,21 @@ func newSocket(underline net.Conn, request *http.Request, client bool) *Socket {
 		// be received between text/binary continuation frames.
 		// Read `gobwas/wsutil/reader#NextReader`.
 		//
-		OnIntermediate: controlHandler,
+		OnIntermediate: s.controlHandler,
 	}
 
-	return &Socket{
-		UnderlyingConn: underline,
-		request:        request,
-		state:          state,
-		reader:         reader,
-		controlHandler: controlHandler,
-	}
+	return s
+}
+
+type lockedWriter struct {
+	s *Socket
+}
+
+func (w lockedWriter) Write(p []byte) (int, error) {
+	w.s.mu.Lock()
+	n, err := w.s.UnderlyingConn.Write(p)
+	w.s.mu.Unlock()
+	return n, err
 }
 
 // NetConn returns the underline net connection.
@@ -75,6 +91,10 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, er
 
 		hdr, err := s.reader.NextFrame()
 		if err != nil {
+			if err == wsutil.ErrFrameTooLarge {
+				return nil, 0, s.rejectTooBig()
+			}
+
 			if err == io.EOF {
 				return nil, 0, io.ErrUnexpectedEOF // for io.ReadAll to return an error if connection remotely closed.
 			}
@@ -82,9 +102,22 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, er
 		}
 
 		if hdr.OpCode == gobwas.OpClose {
+			// replies with a close frame and returns the remote side's code and reason.
+			if closedErr, ok := s.controlHandler(hdr, s.reader).(wsutil.ClosedError); ok {
+				return nil, 0, neffos.NewCloseError(int(closedErr.Code), closedErr.Reason)
+			}
 			return nil, 0, io.ErrUnexpectedEOF // for io.ReadAll to return an error if connection remotely closed.
 		}
 
+		if hdr.OpCode == gobwas.OpPong && s.pongHandler != nil {
+			data, err := ioutil.ReadAll(s.reader)
+			if err != nil {
+				return nil, 0, err
+			}
+			s.pongHandler(data)
+			continue
+		}
+
 		if hdr.OpCode.IsControl() {
 			err = s.controlHandler(hdr, s.reader)
 			if err != nil {
@@ -101,11 +134,34 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, er
 			continue
 		}
 
-		b, err := ioutil.ReadAll(s.reader)
+		var r io.Reader = s.reader
+		if s.readLimit > 0 {
+			// a message may be fragmented to many frames.
+			r = io.LimitReader(r, s.readLimit+1)
+		}
+
+		b, err := ioutil.ReadAll(r)
+		if err == nil && s.flate != nil {
+			if s.flate.message.IsCompressed() {
+				b, err = s.flate.decompress(b, s.readLimit)
+			}
+
+			if err == nil && hdr.OpCode == gobwas.OpText && !utf8.Valid(b) {
+				err = wsutil.ErrInvalidUTF8
+			}
+		}
+
 		if err != nil {
+			if err == wsutil.ErrFrameTooLarge {
+				return nil, 0, s.rejectTooBig()
+			}
 			return nil, 0, err
 		}
 
+		if s.readLimit > 0 && int64(len(b)) > s.readLimit {
+			return nil, 0, s.rejectTooBig()
+		}
+
 		return b, neffos.MessageType(hdr.OpCode), nil
 	}
 
@@ -149,3 +205,70 @@ func (s *Socket) write(body []byte, op gobwas.OpCode, timeout time.Duration) err
 
 	return err
 }
+
+// CompressionNegotiated reports whether the permessage-deflate extension was negotiated,
+// it completes the `neffos.SocketCompressor` interface.
+func (s *Socket) CompressionNegotiated() bool {
+	return s.flate != nil
+}
+
+// WriteCompressed sends a compressed text or binary message to the remote connection,
+// it completes the `neffos.SocketCompressor` interface.
+func (s *Socket) WriteCompressed(body []byte, binary bool, timeout time.Duration) error {
+	op := gobwas.OpText
+	if binary {
+		op = gobwas.OpBinary
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timeout > 0 {
+		s.UnderlyingConn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+
+	payload, err := s.flate.compress(body)
+	if err != nil {
+		return err
+	}
+
+	frame := gobwas.NewFrame(op, true, payload)
+	frame.Header.Rsv = gobwas.Rsv(true, false, false)
+	if s.state.ClientSide() {
+		frame = gobwas.MaskFrameInPlace(frame)
+	}
+
+	return gobwas.WriteFrame(s.UnderlyingConn, frame)
+}
+
+// WriteClose sends a close frame of the "code" and "reason" to the remote connection,
+// it completes the `neffos.SocketCloseWriter` interface.
+func (s *Socket) WriteClose(code int, reason string, timeout time.Duration) error {
+	body := gobwas.NewCloseFrameBody(gobwas.StatusCode(code), reason)
+	return s.write(body, gobwas.OpClose, timeout)
+}
+
+// SetReadLimit sets the maximum size, in bytes, of an incoming message,
+// it completes the `neffos.SocketReadLimiter` interface.
+// Bigger messages are rejected with the `neffos.CloseMessageTooBig` close code.
+func (s *Socket) SetReadLimit(limit int64) {
+	s.readLimit = limit
+	s.reader.MaxFrameSize = limit
+}
+
+func (s *Socket) rejectTooBig() error {
+	s.WriteClose(neffos.CloseMessageTooBig, neffos.ErrMessageTooBig.Error(), time.Second)
+	return neffos.ErrMessageTooBig
+}
+
+// WritePing sends a ping control frame of the "data" to the remote connection,
+// it completes the `neffos.SocketPinger` interface.
+func (s *Socket) WritePing(data []byte, timeout time.Duration) error {
+	return s.write(data, gobwas.OpPing, timeout)
+}
+
+// SetPongHandler registers the callback of the pong control frames,
+// it completes the `neffos.SocketPinger` interface.
+func (s *Socket) SetPongHandler(handler func(data []byte)) {
+	s.pongHandler = handler
+}
@@ -13,13 +13,27 @@ var DefaultUpgrader = Upgrader(gobwas.HTTPUpgrader{})
 
 // Upgrader is a `neffos.Upgrader` type for the gobwas/ws subprotocol implementation.
 // Should be used on `neffos.New` to construct the neffos server.
+// The `neffos.Server.Compression` negotiates the permessage-deflate extension.
 func Upgrader(upgrader gobwas.HTTPUpgrader) neffos.Upgrader {
 	return func(w http.ResponseWriter, r *http.Request) (neffos.Socket, error) {
-		underline, _, _, err := upgrader.Upgrade(r, w)
+		u := upgrader
+		var ext *extension
+		compression := neffos.CompressionFromContext(r.Context())
+		if compression != nil {
+			ext = &extension{want: parameters(compression)}
+			u.Negotiate = ext.Negotiate
+		}
+
+		underline, _, _, err := u.Upgrade(r, w)
 		if err != nil {
 			return nil, err
 		}
 
-		return newSocket(underline, r, false), nil
+		socket := newSocket(underline, r, false)
+		if ext != nil && ext.accepted {
+			socket.enableCompression(ext.params, compression)
+		}
+
+		return socket, nil
 	}
 }
@@ -14,13 +14,22 @@ var DefaultDialer = Dialer(gorilla.DefaultDialer, make(http.Header))
 
 // Dialer is a `neffos.Dialer` type for the gorilla/websocket subprotocol implementation.
 // Should be used on `Dial` to create a new client/client-side connection.
+// The `neffos.Client.Compression` enables the dialer's `EnableCompression`.
 func Dialer(dialer *gorilla.Dialer, requestHeader http.Header) neffos.Dialer {
 	return func(ctx context.Context, url string) (neffos.Socket, error) {
-		underline, _, err := dialer.DialContext(ctx, url, requestHeader)
+		d := dialer
+		compression := neffos.CompressionFromContext(ctx)
+		if compression != nil && !dialer.EnableCompression {
+			withCompression := *dialer
+			withCompression.EnableCompression = true
+			d = &withCompression
+		}
+
+		underline, resp, err := d.DialContext(ctx, url, requestHeader)
 		if err != nil {
 			return nil, err
 		}
 
-		return newSocket(underline, nil, true), nil
+		return newSocket(underline, nil, true, compression, resp != nil && offersCompression(resp.Header)), nil
 	}
 }
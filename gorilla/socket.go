@@ -1,8 +1,10 @@
 package gorilla
 
 import (
+	"io"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,18 +20,39 @@ type Socket struct {
 	request        *http.Request
 
 	client bool
+	// set when the permessage-deflate extension was negotiated.
+	compressed bool
+	readLimit  int64
 
 	mu sync.Mutex
 }
 
-func newSocket(underline *gorilla.Conn, request *http.Request, client bool) *Socket {
+func newSocket(underline *gorilla.Conn, request *http.Request, client bool, compression *neffos.Compression, compressed bool) *Socket {
+	// messages are compressed only through the `WriteCompressed`.
+	underline.EnableWriteCompression(false)
+	if compressed && compression != nil {
+		underline.SetCompressionLevel(compression.CompressionLevel())
+	}
+
 	return &Socket{
 		UnderlyingConn: underline,
 		request:        request,
 		client:         client,
+		compressed:     compressed,
 	}
 }
 
+// offersCompression reports whether the "header" lists the permessage-deflate extension.
+func offersCompression(header http.Header) bool {
+	for _, value := range header.Values("Sec-Websocket-Extensions") {
+		if strings.Contains(strings.ToLower(value), "permessage-deflate") {
+			return true
+		}
+	}
+
+	return false
+}
+
 // NetConn returns the underline net connection.
 func (s *Socket) NetConn() net.Conn {
 	return s.UnderlyingConn.UnderlyingConn()
@@ -47,8 +70,16 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, er
 			s.UnderlyingConn.SetReadDeadline(time.Now().Add(timeout))
 		}
 
-		opCode, data, err := s.UnderlyingConn.ReadMessage()
+		opCode, data, err := s.readMessage()
 		if err != nil {
+			if err == gorilla.ErrReadLimit {
+				// the close frame is sent by the gorilla connection itself.
+				return nil, 0, neffos.ErrMessageTooBig
+			}
+
+			if closeErr, ok := err.(*gorilla.CloseError); ok {
+				return nil, 0, neffos.NewCloseError(closeErr.Code, closeErr.Text)
+			}
 			return nil, 0, err
 		}
 
@@ -61,24 +92,104 @@ func (s *Socket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, er
 	}
 }
 
+// readMessage reads the next message, the decompressed messages
+// are limited to the read limit as well, see `SetReadLimit`.
+func (s *Socket) readMessage() (int, []byte, error) {
+	if s.readLimit <= 0 {
+		return s.UnderlyingConn.ReadMessage()
+	}
+
+	opCode, r, err := s.UnderlyingConn.NextReader()
+	if err != nil {
+		return opCode, nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, s.readLimit+1))
+	if err == nil && int64(len(data)) > s.readLimit {
+		s.WriteClose(neffos.CloseMessageTooBig, neffos.ErrMessageTooBig.Error(), time.Second)
+		err = gorilla.ErrReadLimit
+	}
+
+	return opCode, data, err
+}
+
 // WriteBinary sends a binary message to the remote connection.
 func (s *Socket) WriteBinary(body []byte, timeout time.Duration) error {
-	return s.write(body, gorilla.BinaryMessage, timeout)
+	return s.write(body, gorilla.BinaryMessage, false, timeout)
 }
 
 // WriteText sends a text message to the remote connection.
 func (s *Socket) WriteText(body []byte, timeout time.Duration) error {
-	return s.write(body, gorilla.TextMessage, timeout)
+	return s.write(body, gorilla.TextMessage, false, timeout)
+}
+
+// CompressionNegotiated reports whether the permessage-deflate extension was negotiated,
+// it completes the `neffos.SocketCompressor` interface.
+func (s *Socket) CompressionNegotiated() bool {
+	return s.compressed
 }
 
-func (s *Socket) write(body []byte, opCode int, timeout time.Duration) error {
+// WriteCompressed sends a compressed text or binary message to the remote connection,
+// it completes the `neffos.SocketCompressor` interface.
+func (s *Socket) WriteCompressed(body []byte, binary bool, timeout time.Duration) error {
+	if binary {
+		return s.write(body, gorilla.BinaryMessage, true, timeout)
+	}
+
+	return s.write(body, gorilla.TextMessage, true, timeout)
+}
+
+func (s *Socket) write(body []byte, opCode int, compress bool, timeout time.Duration) error {
 	if timeout > 0 {
 		s.UnderlyingConn.SetWriteDeadline(time.Now().Add(timeout))
 	}
 
 	s.mu.Lock()
+	if compress {
+		s.UnderlyingConn.EnableWriteCompression(true)
+	}
 	err := s.UnderlyingConn.WriteMessage(opCode, body)
+	if compress {
+		s.UnderlyingConn.EnableWriteCompression(false)
+	}
 	s.mu.Unlock()
 
 	return err
 }
+
+// WriteClose sends a close frame of the "code" and "reason" to the remote connection,
+// it completes the `neffos.SocketCloseWriter` interface.
+func (s *Socket) WriteClose(code int, reason string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	return s.UnderlyingConn.WriteControl(gorilla.CloseMessage, gorilla.FormatCloseMessage(code, reason), time.Now().Add(timeout))
+}
+
+// SetReadLimit sets the maximum size, in bytes, of an incoming message,
+// it completes the `neffos.SocketReadLimiter` interface.
+// Bigger messages are rejected with the `neffos.CloseMessageTooBig` close code.
+func (s *Socket) SetReadLimit(limit int64) {
+	s.readLimit = limit
+	s.UnderlyingConn.SetReadLimit(limit)
+}
+
+// WritePing sends a ping control frame of the "data" to the remote connection,
+// it completes the `neffos.SocketPinger` interface.
+func (s *Socket) WritePing(data []byte, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+
+	return s.UnderlyingConn.WriteControl(gorilla.PingMessage, data, time.Now().Add(timeout))
+}
+
+// SetPongHandler registers the callback of the pong control frames,
+// it completes the `neffos.SocketPinger` interface.
+func (s *Socket) SetPongHandler(handler func(data []byte)) {
+	s.UnderlyingConn.SetPongHandler(func(appData string) error {
+		handler([]byte(appData))
+		return nil
+	})
+}
@@ -13,13 +13,31 @@ var DefaultUpgrader = Upgrader(gorilla.Upgrader{})
 
 // Upgrader is a `neffos.Upgrader` type for the gorilla/websocket subprotocol implementation.
 // Should be used on `New` to construct the neffos server.
+//
+// The requests which are allowed by the `neffos.Server.OriginPolicy` skip the upgrader's `CheckOrigin`
+// and the `neffos.Server.Compression` enables the upgrader's `EnableCompression`.
 func Upgrader(upgrader gorilla.Upgrader) neffos.Upgrader {
+	checkOrigin := upgrader.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = neffos.SameOrigin
+	}
+
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		return neffos.OriginChecked(r) || checkOrigin(r)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) (neffos.Socket, error) {
-		underline, err := upgrader.Upgrade(w, r, w.Header())
+		u := upgrader
+		compression := neffos.CompressionFromContext(r.Context())
+		if compression != nil {
+			u.EnableCompression = true
+		}
+
+		underline, err := u.Upgrade(w, r, w.Header())
 		if err != nil {
 			return nil, err
 		}
 
-		return newSocket(underline, r, false), nil
+		return newSocket(underline, r, false, compression, u.EnableCompression && offersCompression(r.Header)), nil
 	}
 }
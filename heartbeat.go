@@ -0,0 +1,167 @@
+package neffos
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat describes how a connection checks that its remote side is alive,
+// see `Server.Heartbeat` and `Client.Heartbeat`.
+//
+// A ping is sent on every Interval, as a websocket ping control frame
+// if the `Socket` completes the `SocketPinger` interface (the `gorilla` and `gobwas` ones do)
+// or as a neffos-level ping message otherwise. The round-trip time of the last ping
+// is reported by the `Conn.Latency` method.
+//
+// Unlike the `WithTimeout.ReadTimeout`, it does not close the idle connections
+// which are still alive, keep the `ReadTimeout` greater than the Interval when both are set.
+type Heartbeat struct {
+	// Interval is the wait time between two pings.
+	// Defaults to 0, no pings are sent.
+	Interval time.Duration
+	// MaxMissed is the number of the consecutive pings without reply
+	// after which the connection is considered dead and it is closed with the `ErrHeartbeatTimeout`.
+	// Clients with a `Client.Reconnect` policy try to reconnect instead.
+	// Defaults to 3.
+	MaxMissed int
+}
+
+func (h *Heartbeat) maxMissed() int32 {
+	if h.MaxMissed <= 0 {
+		return 3
+	}
+
+	return int32(h.MaxMissed)
+}
+
+// ErrHeartbeatTimeout is reported to the `Server.OnError` when a connection
+// missed the `Heartbeat.MaxMissed` pings and it was closed.
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
+// SocketPinger is an optional interface which a `Socket` can complete
+// to send websocket ping control frames instead of neffos-level ping messages, see `Heartbeat`.
+type SocketPinger interface {
+	// WritePing sends a ping control frame of the "data" to the remote connection.
+	WritePing(data []byte, timeout time.Duration) error
+	// SetPongHandler registers the callback which should be called, by the `ReadData`,
+	// with the data of each pong control frame received from the remote connection.
+	SetPongHandler(handler func(data []byte))
+}
+
+// The neffos-level ping and pong events, they are sent to the empty namespace.
+const (
+	pingEvent = "_ping"
+	pongEvent = "_pong"
+)
+
+// WithHeartbeat is a `ClientOption` which sets the `Client.Heartbeat`.
+func WithHeartbeat(heartbeat Heartbeat) ClientOption {
+	return func(c *Client) {
+		c.Heartbeat = &heartbeat
+	}
+}
+
+// Latency returns the round-trip time of the last replied ping, see `Heartbeat`.
+// It returns zero if no ping was replied yet.
+func (c *Conn) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(c.latency))
+}
+
+// startHeartbeat sends pings on every "h.Interval" until the connection is closed.
+func (c *Conn) startHeartbeat(h *Heartbeat) {
+	if h.Interval <= 0 {
+		return
+	}
+
+	c.heartbeat = h
+	c.watchPongs()
+
+	go func() {
+		ticker := time.NewTicker(h.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.closeCh:
+				return
+			case <-ticker.C:
+			}
+
+			if !c.isAcknowledged() {
+				// not ready yet or reconnecting.
+				atomic.StoreInt32(c.missedPings, 0)
+				continue
+			}
+
+			if atomic.AddInt32(c.missedPings, 1) > h.maxMissed() {
+				atomic.StoreInt32(c.missedPings, 0)
+				c.reportError(ErrHeartbeatTimeout)
+				// let the reader decide if it should be closed or reconnect.
+				c.Socket().NetConn().Close()
+				continue
+			}
+
+			c.ping()
+		}
+	}()
+}
+
+// watchPongs registers the pong handler of the connection's socket, if it's a `SocketPinger`.
+// It's called again when the client reconnects with a new socket.
+func (c *Conn) watchPongs() {
+	if c.heartbeat == nil {
+		return
+	}
+
+	if pinger, ok := c.Socket().(SocketPinger); ok {
+		pinger.SetPongHandler(c.pong)
+	}
+}
+
+func (c *Conn) ping() {
+	data := strconv.AppendInt(nil, time.Now().UnixNano(), 10)
+
+	if pinger, ok := c.Socket().(SocketPinger); ok {
+		if err := pinger.WritePing(data, c.writeTimeout); err != nil {
+			c.reportError(err)
+		}
+		return
+	}
+
+	if !c.shouldHandleOnlyNativeMessages {
+		c.write(serializeMessage(Message{Event: pingEvent, Body: data}), false)
+	}
+}
+
+// pong measures the round-trip time of a ping's "data".
+func (c *Conn) pong(data []byte) {
+	sent, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return
+	}
+
+	atomic.StoreInt64(c.latency, int64(time.Since(time.Unix(0, sent))))
+	atomic.StoreInt32(c.missedPings, 0)
+	c.refreshPresence()
+}
+
+// handleHeartbeat replies to the neffos-level pings and handles their pongs,
+// it reports whether the "msg" was a ping or a pong.
+func (c *Conn) handleHeartbeat(msg Message) bool {
+	if msg.Namespace != "" || msg.isInvalid || msg.IsNative {
+		return false
+	}
+
+	switch msg.Event {
+	case pingEvent:
+		c.write(serializeMessage(Message{Event: pongEvent, Body: msg.Body}), false)
+		return true
+	case pongEvent:
+		c.pong(msg.Body)
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,106 @@
+package neffos_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	gobwas "github.com/kataras/neffos/gobwas"
+	gorilla "github.com/kataras/neffos/gorilla"
+)
+
+// pongDropperSocket hides the `SocketPinger` of its socket,
+// so neffos-level pings are used, and drops the pongs when "drop" is set.
+type pongDropperSocket struct {
+	neffos.Socket
+	drop *uint32
+}
+
+func (s pongDropperSocket) ReadData(timeout time.Duration) ([]byte, neffos.MessageType, error) {
+	for {
+		b, typ, err := s.Socket.ReadData(timeout)
+		if err == nil && atomic.LoadUint32(s.drop) == 1 && bytes.Contains(b, []byte("_pong")) {
+			continue
+		}
+
+		return b, typ, err
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+		heartbeat = neffos.Heartbeat{Interval: 20 * time.Millisecond, MaxMissed: 2}
+
+		mu    sync.Mutex
+		conns = make(map[*neffos.Conn]struct{})
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.Heartbeat = &heartbeat
+		s.OnConnect = func(c *neffos.Conn) error {
+			mu.Lock()
+			conns[c] = struct{}{}
+			mu.Unlock()
+			return nil
+		}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		drop := new(uint32)
+		dropper := func(ctx context.Context, url string) (neffos.Socket, error) {
+			socket, err := dial(ctx, url)
+			if err != nil {
+				return nil, err
+			}
+			return pongDropperSocket{Socket: socket, drop: drop}, nil
+		}
+
+		for _, d := range []neffos.Dialer{dial, dropper} {
+			client, err := neffos.Dial(context.TODO(), d, "ws://localhost:8080/"+dialer, events, neffos.WithHeartbeat(heartbeat))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			time.Sleep(150 * time.Millisecond)
+			if c.Conn.Latency() <= 0 {
+				t.Fatalf("[%s] expected the client's latency to be measured", dialer)
+			}
+			client.Close()
+		}
+
+		mu.Lock()
+		for c := range conns {
+			if c.Latency() <= 0 {
+				t.Fatalf("[%s] expected the server's latency to be measured", dialer)
+			}
+			delete(conns, c)
+		}
+		mu.Unlock()
+
+		client, err := neffos.Dial(context.TODO(), dropper, "ws://localhost:8080/"+dialer, events, neffos.WithHeartbeat(heartbeat))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		atomic.StoreUint32(drop, 1)
+		select {
+		case <-client.NotifyClose:
+		case <-time.After(time.Second):
+			t.Fatalf("[%s] expected the client to be closed after missed heartbeats", dialer)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package neffos
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRoomHistorySize is the default `RoomHistory.MaxMessages`.
+const DefaultRoomHistorySize = 100
+
+// RoomHistory describes the history of the room messages, see `Server.RoomHistory`.
+//
+// The messages of the `Server.Broadcast` which target a room (their `Message.Room` is filled)
+// are recorded with a sequence number, see `Message.Sequence`.
+// A connection which joined a room can receive the recorded messages
+// after a sequence number through the `Room.Replay` method, i.e. inside its `OnRoomJoined` event.
+// The messages to a specific connection (`Message.To`), the errors, the `Ask` calls
+// and the system events are not recorded.
+type RoomHistory struct {
+	// Store keeps the recorded messages.
+	// Defaults to the `Server.StackExchange` if it completes the `HistoryStore` interface,
+	// as the redis one does, otherwise to a `MemoryHistory` of this server.
+	Store HistoryStore
+	// MaxMessages is the maximum number of the recorded messages of each room,
+	// the oldest ones are removed first.
+	// Defaults to `DefaultRoomHistorySize`.
+	MaxMessages int
+	// MaxAge, if greater than zero, is the maximum age of a replayed message.
+	// Defaults to 0, the messages are replayed regardless of their age.
+	MaxAge time.Duration
+}
+
+func (h *RoomHistory) maxMessages() int {
+	if h.MaxMessages <= 0 {
+		return DefaultRoomHistorySize
+	}
+
+	return h.MaxMessages
+}
+
+// HistoryEntry is a recorded room message, see `HistoryStore`.
+type HistoryEntry struct {
+	Seq     uint64
+	Time    time.Time
+	Message Message
+}
+
+// HistoryStore keeps the recorded room messages of the `RoomHistory`,
+// it should be safe for concurrent use.
+//
+// It's an optional interface for a `StackExchange` too,
+// so the history is shared between the servers of the stack exchange.
+type HistoryStore interface {
+	// Append records the room message "msg", keeps the last "limit" messages of its room
+	// and returns the sequence number of the message. The sequence numbers of a room start from 1.
+	Append(msg Message, limit int) (uint64, error)
+	// Since returns the recorded messages of the "room" of the "namespace"
+	// with a sequence number greater than "since", the oldest first.
+	Since(namespace, room string, since uint64) ([]HistoryEntry, error)
+}
+
+// MemoryHistory is an in-memory `HistoryStore`, it keeps the messages of each room
+// in a ring buffer. It's the default store of a single server.
+type MemoryHistory struct {
+	rooms map[historyKey]*historyRing
+	mu    sync.Mutex
+}
+
+type historyKey struct {
+	namespace string
+	room      string
+}
+
+type historyRing struct {
+	seq     uint64
+	entries []HistoryEntry
+	start   int
+	n       int
+}
+
+var _ HistoryStore = (*MemoryHistory)(nil)
+
+// NewMemoryHistory returns a new in-memory `HistoryStore`.
+func NewMemoryHistory() *MemoryHistory {
+	return &MemoryHistory{
+		rooms: make(map[historyKey]*historyRing),
+	}
+}
+
+// Append records the "msg" and returns its sequence number.
+func (h *MemoryHistory) Append(msg Message, limit int) (uint64, error) {
+	key := historyKey{namespace: msg.Namespace, room: msg.Room}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ring, ok := h.rooms[key]
+	if !ok {
+		ring = new(historyRing)
+		h.rooms[key] = ring
+	}
+
+	if len(ring.entries) != limit {
+		ring.resize(limit)
+	}
+
+	ring.seq++
+	entry := HistoryEntry{Seq: ring.seq, Time: time.Now(), Message: msg}
+	if ring.n < len(ring.entries) {
+		ring.entries[(ring.start+ring.n)%len(ring.entries)] = entry
+		ring.n++
+	} else {
+		// full, overwrite the oldest one.
+		ring.entries[ring.start] = entry
+		ring.start = (ring.start + 1) % len(ring.entries)
+	}
+
+	return ring.seq, nil
+}
+
+// resize keeps the last "limit" entries in a buffer of that size.
+func (r *historyRing) resize(limit int) {
+	entries := make([]HistoryEntry, limit)
+	n := r.n
+	if n > limit {
+		n = limit
+	}
+
+	for i := 0; i < n; i++ {
+		entries[i] = r.entries[(r.start+r.n-n+i)%len(r.entries)]
+	}
+
+	r.entries, r.start, r.n = entries, 0, n
+}
+
+// Since returns the recorded messages after the "since" sequence number.
+func (h *MemoryHistory) Since(namespace, room string, since uint64) ([]HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ring, ok := h.rooms[historyKey{namespace: namespace, room: room}]
+	if !ok {
+		return nil, nil
+	}
+
+	var list []HistoryEntry
+	for i := 0; i < ring.n; i++ {
+		entry := ring.entries[(ring.start+i)%len(ring.entries)]
+		if entry.Seq > since {
+			list = append(list, entry)
+		}
+	}
+
+	return list, nil
+}
+
+// sequenceHeaderKey is the `Message.Header` key of the room history's sequence number.
+const sequenceHeaderKey = "neffos-seq"
+
+// Sequence returns the room history's sequence number of a room message,
+// see `Server.RoomHistory` and `Room.Replay`.
+// It returns zero if the message was not recorded or the remote side does not send the `Message.Header`.
+func (m *Message) Sequence() uint64 {
+	seq, _ := strconv.ParseUint(m.Header[sequenceHeaderKey], 10, 64)
+	return seq
+}
+
+// withSequence returns a copy of the "header" with the "seq" sequence number.
+func withSequence(header map[string]string, seq uint64) map[string]string {
+	h := make(map[string]string, len(header)+1)
+	for k, v := range header {
+		h[k] = v
+	}
+	h[sequenceHeaderKey] = strconv.FormatUint(seq, 10)
+
+	return h
+}
+
+// historyStore returns the `RoomHistory.Store`, the stack exchange's one or the in-memory one.
+// It returns nil if the `Server.RoomHistory` is nil.
+func (s *Server) historyStore() HistoryStore {
+	if s.RoomHistory == nil {
+		return nil
+	}
+
+	s.historyOnce.Do(func() {
+		if s.RoomHistory.Store != nil {
+			s.history = s.RoomHistory.Store
+		} else if store, ok := findStackExchange[HistoryStore](s.StackExchange); ok {
+			s.history = store
+		} else {
+			s.history = NewMemoryHistory()
+		}
+	})
+
+	return s.history
+}
+
+// shouldRecord reports whether the "msg" should be recorded to the room history.
+func shouldRecord(msg Message) bool {
+	return msg.Room != "" && msg.To == "" && msg.wait == "" && msg.Err == nil && !msg.IsNative &&
+		!IsSystemEvent(msg.Event) && msg.Event != OnPresenceJoined && msg.Event != OnPresenceLeft
+}
+
+// recordHistory records the room messages of the "msgs" and sets their sequence numbers.
+func (s *Server) recordHistory(msgs []Message) {
+	store := s.historyStore()
+	if store == nil {
+		return
+	}
+
+	limit := s.RoomHistory.maxMessages()
+	for i, msg := range msgs {
+		if !shouldRecord(msg) {
+			continue
+		}
+
+		msg.from, msg.FromExplicit = "", ""
+		seq, err := store.Append(msg, limit)
+		if err != nil {
+			// the message is still sent, without a sequence number.
+			continue
+		}
+
+		msgs[i].Header = withSequence(msg.Header, seq)
+	}
+}
+
+// roomReplayEvent is the event of the `Room.Replay` requests of the client-side.
+const roomReplayEvent = "_OnRoomReplay"
+
+// ErrReplayInsideHandler is returned by the client-side `Room.Replay` when it's called
+// inside an event callback which runs on the connection's read loop,
+// i.e. the `OnRoomJoined` of a room which the server made the client to join.
+// The server-side should call the `Room.Replay` after its `NSConn.JoinRoom` instead.
+var ErrReplayInsideHandler = errors.New("room replay inside an event callback")
+
+// Replay sends the recorded messages of this room (see `Server.RoomHistory`)
+// with a sequence number greater than "since" to the connection, the oldest first.
+// The replayed messages fire the room's events as they were sent now,
+// keep note that a message may be received twice, by the broadcast and the replay,
+// use the `Message.Sequence` to skip the duplicates.
+//
+// On client-side it asks the server and it blocks until all the messages are received,
+// it can be called inside the `OnRoomJoined` event of a room joined by the client (`NSConn.JoinRoom`),
+// i.e. with the sequence number of the last message received before a reconnection.
+// It can not be called inside the event callbacks which run on the connection's read loop,
+// e.g. the `OnRoomJoined` of a room joined by the server, it returns the `ErrReplayInsideHandler` instead,
+// as the replayed messages can not be received before the callback returns.
+// On server-side it writes the messages to the remote side,
+// i.e. after the server-side `NSConn.JoinRoom`.
+func (r *Room) Replay(ctx context.Context, since uint64) error {
+	ns := r.NSConn
+	if ns.Conn.IsClient() {
+		if atomic.LoadUint32(ns.Conn.isInsideHandler) == 1 {
+			return ErrReplayInsideHandler
+		}
+
+		_, err := ns.Conn.Ask(ctx, Message{
+			Namespace: ns.namespace,
+			Room:      r.Name,
+			Event:     roomReplayEvent,
+			Body:      strconv.AppendUint(nil, since, 10),
+		})
+		return err
+	}
+
+	return ns.replay(r.Name, since)
+}
+
+func (ns *NSConn) replay(roomName string, since uint64) error {
+	store := ns.Conn.server.historyStore()
+	if store == nil {
+		return nil
+	}
+
+	entries, err := store.Since(ns.namespace, roomName, since)
+	if err != nil {
+		return err
+	}
+
+	maxAge := ns.Conn.server.RoomHistory.MaxAge
+	for _, entry := range entries {
+		if maxAge > 0 && time.Since(entry.Time) > maxAge {
+			continue
+		}
+
+		msg := entry.Message
+		msg.Header = withSequence(msg.Header, entry.Seq)
+		if !ns.Conn.Write(msg) {
+			return ErrWrite
+		}
+	}
+
+	return nil
+}
+
+func (ns *NSConn) replyRoomReplay(msg Message) {
+	if ns == nil || msg.wait == "" || msg.isNoOp {
+		return
+	}
+
+	if ns.Room(msg.Room) == nil {
+		msg.Err = ErrBadRoom
+		ns.Conn.Write(msg)
+		return
+	}
+
+	since, _ := strconv.ParseUint(string(msg.Body), 10, 64)
+	if err := ns.replay(msg.Room, since); err != nil {
+		msg.Err = err
+		ns.Conn.Write(msg)
+		return
+	}
+
+	ns.Conn.writeEmptyReply(msg.wait)
+}
@@ -0,0 +1,188 @@
+package neffos_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	gobwas "github.com/kataras/neffos/gobwas"
+	gorilla "github.com/kataras/neffos/gorilla"
+)
+
+func TestRoomHistory(t *testing.T) {
+	var (
+		namespace = "default"
+		room      = "room1"
+		servers   = make(map[string]*neffos.Server)
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(s *neffos.Server) {
+		s.RoomHistory = &neffos.RoomHistory{MaxMessages: 2}
+		if len(servers) == 0 {
+			servers["gobwas"] = s
+		} else {
+			servers["gorilla"] = s
+		}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		var (
+			server   = servers[dialer]
+			received = make(chan neffos.Message, 10)
+			since    uint64
+		)
+
+		events := neffos.Events{
+			neffos.OnRoomJoined: func(c *neffos.NSConn, msg neffos.Message) error {
+				return c.Room(msg.Room).Replay(context.TODO(), since)
+			},
+			"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- msg
+				return nil
+			},
+		}
+
+		for i := 1; i <= 3; i++ {
+			server.Broadcast(nil, neffos.Message{Namespace: namespace, Room: room, Event: "chat", Body: []byte(strconv.Itoa(i))})
+		}
+
+		expect := func(seqs ...uint64) {
+			t.Helper()
+
+			for _, seq := range seqs {
+				select {
+				case msg := <-received:
+					if msg.Sequence() != seq || string(msg.Body) != strconv.FormatUint(seq, 10) {
+						t.Fatalf("[%s] expected the message of sequence %d but got %d: %s", dialer, seq, msg.Sequence(), msg.Body)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("[%s] expected the message of sequence %d to be replayed", dialer, seq)
+				}
+			}
+
+			if len(received) > 0 {
+				t.Fatalf("[%s] expected no more messages but got %d", dialer, len(received))
+			}
+		}
+
+		client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer, neffos.Namespaces{namespace: events})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// only the last two messages are kept.
+		r, err := c.JoinRoom(context.TODO(), room)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect(2, 3)
+
+		server.Broadcast(nil, neffos.Message{Namespace: namespace, Room: room, Event: "chat", Body: []byte("4")})
+		expect(4)
+
+		if err = r.Replay(context.TODO(), 3); err != nil {
+			t.Fatal(err)
+		}
+		expect(4)
+
+		client.Close()
+	}
+}
+
+func TestRoomHistoryServerJoin(t *testing.T) {
+	var (
+		namespace = "default"
+		room      = "room1"
+		servers   = make(map[string]*neffos.Server)
+	)
+
+	serverEvents := neffos.Events{
+		"join": func(c *neffos.NSConn, msg neffos.Message) error {
+			r, err := c.JoinRoom(context.TODO(), room)
+			if err != nil {
+				return err
+			}
+
+			return r.Replay(context.TODO(), 0)
+		},
+	}
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: serverEvents}, func(s *neffos.Server) {
+		s.RoomHistory = &neffos.RoomHistory{}
+		if len(servers) == 0 {
+			servers["gobwas"] = s
+		} else {
+			servers["gorilla"] = s
+		}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		var (
+			server    = servers[dialer]
+			received  = make(chan neffos.Message, 10)
+			replayErr = make(chan error, 1)
+		)
+
+		events := neffos.Events{
+			neffos.OnRoomJoined: func(c *neffos.NSConn, msg neffos.Message) error {
+				replayErr <- c.Room(msg.Room).Replay(context.TODO(), 0)
+				return nil
+			},
+			"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- msg
+				return nil
+			},
+		}
+
+		for i := 1; i <= 2; i++ {
+			server.Broadcast(nil, neffos.Message{Namespace: namespace, Room: room, Event: "chat", Body: []byte(strconv.Itoa(i))})
+		}
+
+		client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer, neffos.Namespaces{namespace: events})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit("join", nil)
+
+		select {
+		case err = <-replayErr:
+			if err != neffos.ErrReplayInsideHandler {
+				t.Fatalf("[%s] expected the client-side replay of a server's join to fail but got: %v", dialer, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%s] expected the room to be joined by the server", dialer)
+		}
+
+		// replayed by the server.
+		for seq := uint64(1); seq <= 2; seq++ {
+			select {
+			case msg := <-received:
+				if msg.Sequence() != seq {
+					t.Fatalf("[%s] expected the message of sequence %d but got %d", dialer, seq, msg.Sequence())
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("[%s] expected the message of sequence %d to be replayed", dialer, seq)
+			}
+		}
+
+		client.Close()
+	}
+}
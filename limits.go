@@ -0,0 +1,51 @@
+package neffos
+
+import "errors"
+
+var (
+	// ErrMessageTooBig is the read error of the connections which sent a message
+	// bigger than the `Server.MaxMessageSize`, it is reported to the `Server.OnError`.
+	ErrMessageTooBig = errors.New("message too big")
+	// ErrFieldTooLong is reported to the `Server.OnError` when a connection sent a message
+	// with a namespace, room or event longer than the `Server.MaxFieldLength`.
+	ErrFieldTooLong = errors.New("message field too long")
+	// ErrTooManyNamespaces is the error which the remote side receives
+	// when it tries to connect to more namespaces than the `Server.MaxNamespaces`.
+	ErrTooManyNamespaces = errors.New("too many namespaces")
+	// ErrTooManyRooms is the error which the remote side receives
+	// when it tries to join more rooms than the `Server.MaxRooms`.
+	ErrTooManyRooms = errors.New("too many rooms")
+)
+
+func (c *Conn) maxFieldLength() int {
+	if c.server == nil {
+		return 0
+	}
+
+	return c.server.MaxFieldLength
+}
+
+// exceedsMaxNamespaces reports whether a new namespace connection exceeds the `Server.MaxNamespaces`.
+func (c *Conn) exceedsMaxNamespaces() bool {
+	if c.server == nil || c.server.MaxNamespaces <= 0 {
+		return false
+	}
+
+	c.connectedNamespacesMutex.RLock()
+	n := len(c.connectedNamespaces)
+	c.connectedNamespacesMutex.RUnlock()
+	return n >= c.server.MaxNamespaces
+}
+
+// exceedsMaxRooms reports whether a new room exceeds the `Server.MaxRooms`.
+func (ns *NSConn) exceedsMaxRooms() bool {
+	s := ns.Conn.server
+	if s == nil || s.MaxRooms <= 0 {
+		return false
+	}
+
+	ns.roomsMutex.RLock()
+	n := len(ns.rooms)
+	ns.roomsMutex.RUnlock()
+	return n >= s.MaxRooms
+}
@@ -2,8 +2,11 @@ package neffos
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,9 +25,13 @@ import (
 // <room>;
 // <event>;
 // <isError(0-1)>;
-// <isNoOp(0-1)>;
+// <isNoOp(0-1)[?header]>;
 // <body||error_message>
 //
+// The optional header (see `Message.Header`) is URL-encoded and
+// it's sent only to the remote sides which negotiated it on the acknowledgement process,
+// therefore older clients keep receiving the messages without it.
+//
 // Internal `serializeMessage` and
 // exported `DeserializeMessage` functions
 // do the job on `Conn#Write`, `NSConn#Emit` and `Room#Emit` calls.
@@ -49,6 +56,17 @@ type Message struct {
 	isError bool
 	isNoOp  bool
 
+	// Header is the metadata of the message, i.e. a request ID, a locale or an auth token,
+	// which should not be part of the Body.
+	// It is sent to the remote side, if it's a neffos Go client or server, and
+	// it's forwarded through the `Server.Broadcast` and the `StackExchange`.
+	// The trace context of a `Tracer` is carried by the header as well.
+	// Keep note that the header of a broadcasted message is shared across connections,
+	// it should not be modified after the message was sent.
+	Header map[string]string
+	// ctx is the context of the message, see `Context`.
+	ctx context.Context
+
 	isInvalid bool
 
 	// the CONN ID, filled automatically if `Server#Broadcast` first parameter of sender connection's ID is not empty,
@@ -92,12 +110,45 @@ type Message struct {
 	// This field is not filled on sending/receiving.
 	IsNative bool
 
+	// the local members of the `Room`, resolved by the `Server#Broadcast`
+	// so the broadcaster skips the rest of the connections, see `publishMessages`.
+	roomMembers map[*Conn]struct{}
+
 	// Useful rarely internally on `Conn#Write` namespace and rooms checks, i.e `Conn#DisconnectAll` and `NSConn#RemoveAll`.
 	// If true then the writer's checks will not lock connectedNamespacesMutex or roomsMutex again. May be useful in the future, keep that solution.
 	locked bool
 
 	// if server or client should write using Binary message or if the incoming message was readen as binary.
 	SetBinary bool
+
+	// Compress overrides the `Compression.Threshold` for this message when compression is negotiated,
+	// see `Server.Compression` and `Client.Compression`.
+	// This field is not filled on sending/receiving.
+	Compress CompressMode
+}
+
+// Context returns the context of this message.
+// The context of an incoming message derives from its connection's context (see `Conn.Context`),
+// it is cancelled when the connection is closed or when the remote side's `Conn.Ask` deadline, if any, expires,
+// i.e. the event callbacks can pass it to database queries.
+// Native messages have no context, use the `Conn.Context` instead.
+// It carries the trace context of the remote side when a `Tracer` is used as well.
+// Middlewares can enrich it through `WithContext` before calling their next callback.
+// It's never nil, it defaults to `context.Background()`.
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+
+	return m.ctx
+}
+
+// WithContext returns a shallow copy of the message with its context set to the "ctx".
+// The trace context of the "ctx", if any, is sent to the remote side when a `Tracer` is used,
+// i.e. `Conn.Write(msg.WithContext(ctx))`.
+func (m Message) WithContext(ctx context.Context) Message {
+	m.ctx = ctx
+	return m
 }
 
 func (m *Message) isConnect() bool {
@@ -189,6 +240,8 @@ const (
 	waitIsConfirmationPrefix   = '#'
 	waitComesFromClientPrefix  = '$'
 	waitComesFromStackExchange = '!'
+	// the wait token of messages sent with at-least-once delivery, see `Conn#WriteReliable`.
+	waitIsDeliveryPrefix = '%'
 )
 
 // IsWait reports whether this message waits for a response back.
@@ -240,9 +293,27 @@ func genWaitStackExchange(wait string) string {
 	}
 
 	// This is the second special character.
-	// If found, it is removed on the deserialization
-	// and Message.FromStackExchange is set to true.
-	return string(wait[0]+waitComesFromStackExchange) + wait[1:]
+	// If found, Message.FromStackExchange is set to true on the deserialization,
+	// it's kept so the reply of the remote side carries it back to the stackexchange's waiter.
+	return string(wait[0]) + string(waitComesFromStackExchange) + wait[1:]
+}
+
+// isStackExchangeWait reports whether the "wait" token
+// is generated by the `genWaitStackExchange`.
+//
+// The tokens of the previous versions, which added the special character to the first digit
+// instead, are accepted too, so the replies to the `Server.Ask` of a not yet upgraded server
+// of the same stackexchange are still sent back to it.
+func isStackExchangeWait(wait string) bool {
+	if len(wait) < 2 {
+		return false
+	}
+
+	if wait[1] == waitComesFromStackExchange {
+		return len(wait) > 2
+	}
+
+	return wait[0] >= '0'+waitComesFromStackExchange && wait[0] <= '9'+waitComesFromStackExchange
 }
 
 var (
@@ -286,7 +357,7 @@ func serializeMessage(msg Message) (out []byte) {
 
 			msg.wait = msg.FromExplicit
 		}
-		out = serializeOutput(msg.wait, escape(msg.Namespace), escape(msg.Room), escape(msg.Event), msg.Body, msg.Err, msg.isNoOp)
+		out = serializeOutput(msg.wait, escape(msg.Namespace), escape(msg.Room), escape(msg.Event), msg.Body, msg.Err, msg.isNoOp, msg.Header)
 	}
 
 	return out
@@ -296,6 +367,7 @@ func serializeOutput(wait, namespace, room, event string,
 	body []byte,
 	err error,
 	isNoOp bool,
+	header map[string]string,
 ) []byte {
 
 	var (
@@ -317,6 +389,10 @@ func serializeOutput(wait, namespace, room, event string,
 		isNoOpByte = trueByte
 	}
 
+	if len(header) > 0 {
+		isNoOpByte = append(append([]byte{isNoOpByte[0]}, headerSeparator), encodeHeader(header)...)
+	}
+
 	if wait != "" {
 		waitByte = []byte(wait)
 	}
@@ -338,7 +414,13 @@ func serializeOutput(wait, namespace, room, event string,
 // and returns a neffos Message.
 // When allowNativeMessages only Body is filled and check about message format is skipped.
 func DeserializeMessage(msgTyp MessageType, b []byte, allowNativeMessages, shouldHandleOnlyNativeMessages bool) Message {
-	wait, namespace, room, event, body, isNoOp, isInvalid, err := deserializeInput(b, allowNativeMessages, shouldHandleOnlyNativeMessages)
+	return deserializeMessage(msgTyp, b, allowNativeMessages, shouldHandleOnlyNativeMessages, 0)
+}
+
+// deserializeMessage is the `DeserializeMessage` which marks the message as invalid,
+// with the `ErrFieldTooLong` error, if one of its fields is longer than the "maxFieldLength".
+func deserializeMessage(msgTyp MessageType, b []byte, allowNativeMessages, shouldHandleOnlyNativeMessages bool, maxFieldLength int) Message {
+	wait, namespace, room, event, body, isNoOp, header, isInvalid, err := deserializeInput(b, allowNativeMessages, shouldHandleOnlyNativeMessages, maxFieldLength)
 
 	fromExplicit := ""
 	if isServerConnID(wait) {
@@ -346,13 +428,6 @@ func DeserializeMessage(msgTyp MessageType, b []byte, allowNativeMessages, shoul
 		wait = ""
 	}
 
-	fromStackExchange := len(wait) > 2 && wait[1] == waitComesFromStackExchange
-	if fromStackExchange {
-		// remove the second special char, we need to reform it,
-		// this wait token is compared to the waiter side as it's without the information about stackexchnage.
-		wait = string(wait[0]) + wait[2:]
-	}
-
 	return Message{
 		wait:              wait,
 		Namespace:         unescape(namespace),
@@ -362,10 +437,11 @@ func DeserializeMessage(msgTyp MessageType, b []byte, allowNativeMessages, shoul
 		Err:               err,
 		isError:           err != nil,
 		isNoOp:            isNoOp,
+		Header:            header,
 		isInvalid:         isInvalid,
 		from:              "",
 		FromExplicit:      fromExplicit,
-		FromStackExchange: fromStackExchange,
+		FromStackExchange: isStackExchangeWait(wait),
 		To:                "",
 		IsForced:          false,
 		IsLocal:           false,
@@ -377,7 +453,7 @@ func DeserializeMessage(msgTyp MessageType, b []byte, allowNativeMessages, shoul
 
 const validMessageSepCount = 7
 
-var knownErrors = []error{ErrBadNamespace, ErrBadRoom, ErrWrite, ErrInvalidPayload}
+var knownErrors = []error{ErrBadNamespace, ErrBadRoom, ErrWrite, ErrInvalidPayload, ErrDecode, ErrPanic, ErrRateLimited, ErrTooManyNamespaces, ErrTooManyRooms, ErrForbidden}
 
 // RegisterKnownError registers an error that it's "known" to both server and client sides.
 // This simply adds an error to a list which, if its static text matches
@@ -415,13 +491,14 @@ func resolveError(errorText string) error {
 	return errors.New(errorText)
 }
 
-func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessages bool) ( // go-lint: ignore line
+func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessages bool, maxFieldLength int) ( // go-lint: ignore line
 	wait,
 	namespace,
 	room,
 	event string,
 	body []byte,
 	isNoOp bool,
+	header map[string]string,
 	isInvalid bool,
 	err error,
 ) {
@@ -450,12 +527,23 @@ func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessa
 		return
 	}
 
+	if maxFieldLength > 0 && (len(dts[1]) > maxFieldLength || len(dts[2]) > maxFieldLength || len(dts[3]) > maxFieldLength) {
+		isInvalid = true
+		err = ErrFieldTooLong
+		return
+	}
+
 	wait = string(dts[0])
 	namespace = string(dts[1])
 	room = string(dts[2])
 	event = string(dts[3])
 	isError := bytes.Equal(dts[4], trueByte)
-	isNoOp = bytes.Equal(dts[5], trueByte)
+	isNoOpB := dts[5]
+	if idx := bytes.IndexByte(isNoOpB, headerSeparator); idx != -1 {
+		header = decodeHeader(isNoOpB[idx+1:])
+		isNoOpB = isNoOpB[:idx]
+	}
+	isNoOp = bytes.Equal(isNoOpB, trueByte)
 	if b := dts[6]; len(b) > 0 {
 		if isError {
 			errorText := string(b)
@@ -468,6 +556,45 @@ func deserializeInput(b []byte, allowNativeMessages, shouldHandleOnlyNativeMessa
 	return
 }
 
+// headerSeparator separates the isNoOp field from the message's header.
+const headerSeparator = '?'
+
+// encodeHeader returns the URL-encoded form of the "header", sorted by key.
+func encodeHeader(header map[string]string) []byte {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b []byte
+	for i, k := range keys {
+		if i > 0 {
+			b = append(b, '&')
+		}
+		b = append(b, url.QueryEscape(k)...)
+		b = append(b, '=')
+		b = append(b, url.QueryEscape(header[k])...)
+	}
+
+	return b
+}
+
+// decodeHeader parses a URL-encoded header, invalid pairs are skipped.
+func decodeHeader(b []byte) map[string]string {
+	values, _ := url.ParseQuery(string(b))
+	if len(values) == 0 {
+		return nil
+	}
+
+	header := make(map[string]string, len(values))
+	for k, v := range values {
+		header[k] = v[0]
+	}
+
+	return header
+}
+
 func genEmptyReplyToWait(wait string) []byte {
 	return append([]byte(wait), bytes.Repeat(messageSeparator, validMessageSepCount-1)...)
 }
@@ -0,0 +1,384 @@
+package neffos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The binary wire protocol is an alternative to the text format of `serializeMessage`,
+// negotiated on the acknowledgement process: the client appends the protocol name
+// to its ack message and the server, if it supports it, replies with `ackExtIDBinary`
+// instead of `ackIDBinary`. Old clients and servers never see the binary protocol.
+//
+// Messages are sent as binary websocket frames structured following this order:
+// <flags(uvarint)>
+// <wait(uvarint length + bytes)>
+// <namespace(interned)>
+// <room(uvarint length + bytes)>
+// <event(interned)>
+// [header(uvarint count + uvarint length + bytes key and value pairs), if binaryFlagHeader]
+// <body||error_message(the rest)>
+//
+// Interned strings are encoded as a uvarint "v":
+// v == 0: a literal follows (uvarint length + bytes),
+// v is odd: a literal follows and it is registered with the ID of v>>1,
+// v is even: a reference to the already registered ID of v>>1 - 1.
+// Each side keeps its own table for the messages it sends, the remote side mirrors it.
+const protocolBinary = "binary"
+
+// protocolHeader is negotiated by the Go clients to receive the messages' header section,
+// see `Message.Header`. Other clients never see the header.
+const protocolHeader = "header"
+
+// message flags of the binary protocol.
+const (
+	binaryFlagError = 1 << iota
+	binaryFlagNoOp
+	binaryFlagBinary
+	binaryFlagNative
+	binaryFlagHeader
+)
+
+// maxInternedStrings is the maximum number of namespaces and events
+// a binary codec registers, per direction. The rest are sent as literals
+// and a received message which registers more is invalid.
+const maxInternedStrings = 1024
+
+// binaryCodec encodes and decodes messages of a connection which negotiated the binary protocol.
+type binaryCodec struct {
+	encoded  map[string]uint64
+	encMutex sync.Mutex
+
+	decoded  []string
+	decMutex sync.Mutex
+}
+
+func newBinaryCodec() *binaryCodec {
+	return &binaryCodec{encoded: make(map[string]uint64)}
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendInterned appends the interned representation of "s",
+// it returns true if "s" was registered by this call.
+func (bc *binaryCodec) appendInterned(b []byte, s string) ([]byte, bool) {
+	if id, ok := bc.encoded[s]; ok {
+		return appendUvarint(b, (id+1)<<1), false
+	}
+
+	if s == "" || len(bc.encoded) >= maxInternedStrings {
+		b = appendUvarint(b, 0)
+		return appendString(b, s), false
+	}
+
+	id := uint64(len(bc.encoded))
+	bc.encoded[s] = id
+	b = appendUvarint(b, id<<1|1)
+	return appendString(b, s), true
+}
+
+// encode returns the binary representation of "msg" and
+// a function which unregisters the interned strings of this call,
+// to be called when the message could not be written.
+// Callers should hold the encMutex until the result is written.
+func (bc *binaryCodec) encode(msg Message) ([]byte, func()) {
+	var (
+		flags uint64
+		body  = msg.Body
+	)
+
+	if msg.Err != nil {
+		if b, ok := isReply(msg.Err); ok {
+			body = b
+		} else {
+			body = []byte(msg.Err.Error())
+			flags |= binaryFlagError
+		}
+	}
+
+	if msg.isNoOp {
+		flags |= binaryFlagNoOp
+	}
+
+	if msg.SetBinary {
+		flags |= binaryFlagBinary
+	}
+
+	if msg.IsNative && msg.wait == "" {
+		flags |= binaryFlagNative
+	}
+
+	if len(msg.Header) > 0 {
+		flags |= binaryFlagHeader
+	}
+
+	b := make([]byte, 0, 16+len(msg.wait)+len(msg.Room)+len(body))
+	b = appendUvarint(b, flags)
+	b = appendString(b, msg.wait)
+
+	var registered []string
+	b, ok := bc.appendInterned(b, msg.Namespace)
+	if ok {
+		registered = append(registered, msg.Namespace)
+	}
+	b = appendString(b, msg.Room)
+	if b, ok = bc.appendInterned(b, msg.Event); ok {
+		registered = append(registered, msg.Event)
+	}
+
+	if flags&binaryFlagHeader != 0 {
+		b = appendUvarint(b, uint64(len(msg.Header)))
+		for k, v := range msg.Header {
+			b = appendString(b, k)
+			b = appendString(b, v)
+		}
+	}
+
+	b = append(b, body...)
+
+	return b, func() {
+		for _, s := range registered {
+			delete(bc.encoded, s)
+		}
+	}
+}
+
+type binaryReader struct {
+	b   []byte
+	err bool
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err {
+		return 0
+	}
+
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = true
+		return 0
+	}
+
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *binaryReader) string() string {
+	n := r.uvarint()
+	if r.err || uint64(len(r.b)) < n {
+		r.err = true
+		return ""
+	}
+
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func (bc *binaryCodec) readInterned(r *binaryReader) string {
+	v := r.uvarint()
+	switch {
+	case r.err:
+		return ""
+	case v == 0:
+		return r.string()
+	case v&1 == 1:
+		s := r.string()
+		// the same limit as the encoder's, a peer can not grow the table without bound.
+		if id := v >> 1; id == uint64(len(bc.decoded)) && id < maxInternedStrings {
+			bc.decoded = append(bc.decoded, s)
+		} else {
+			r.err = true
+		}
+		return s
+	default:
+		id := v>>1 - 1
+		if id >= uint64(len(bc.decoded)) {
+			r.err = true
+			return ""
+		}
+		return bc.decoded[id]
+	}
+}
+
+// decode returns the message of a binary protocol payload,
+// on failure the `Message.isInvalid` is true.
+func (bc *binaryCodec) decode(b []byte) Message {
+	bc.decMutex.Lock()
+	defer bc.decMutex.Unlock()
+
+	r := &binaryReader{b: b}
+	flags := r.uvarint()
+	msg := Message{
+		wait:      r.string(),
+		Namespace: bc.readInterned(r),
+		Room:      r.string(),
+		Event:     bc.readInterned(r),
+		isNoOp:    flags&binaryFlagNoOp != 0,
+		SetBinary: flags&binaryFlagBinary != 0,
+		IsNative:  flags&binaryFlagNative != 0,
+	}
+
+	if flags&binaryFlagHeader != 0 {
+		n := r.uvarint()
+		if n > uint64(len(r.b)) {
+			// each pair takes at least two bytes.
+			r.err = true
+		}
+
+		for i := uint64(0); i < n && !r.err; i++ {
+			if msg.Header == nil {
+				msg.Header = make(map[string]string, n)
+			}
+
+			k := r.string()
+			msg.Header[k] = r.string()
+		}
+	}
+
+	if r.err {
+		return Message{isInvalid: true}
+	}
+
+	if len(r.b) > 0 {
+		if flags&binaryFlagError != 0 {
+			msg.Err = resolveError(string(r.b))
+			msg.isError = true
+		} else {
+			msg.Body = r.b
+		}
+	}
+
+	msg.FromStackExchange = isStackExchangeWait(msg.wait)
+	return msg
+}
+
+// acceptProtocols returns the supported protocols of the requested, comma-separated, "protocols".
+func acceptProtocols(protocols []byte) []string {
+	var accepted []string
+	for _, protocol := range strings.Split(string(protocols), ",") {
+		if protocol == protocolBinary || protocol == protocolHeader {
+			accepted = append(accepted, protocol)
+		}
+	}
+
+	return accepted
+}
+
+// parseAckExt splits the `ackExtIDBinary` payload to the accepted protocols and the connection's ID.
+func parseAckExt(b []byte) (protocols []string, id string) {
+	idx := bytes.IndexByte(b, ';')
+	if idx == -1 {
+		return nil, string(b)
+	}
+
+	if idx > 0 {
+		protocols = strings.Split(string(b[:idx]), ",")
+	}
+
+	return protocols, string(b[idx+1:])
+}
+
+func (c *Conn) useProtocols(protocols []string) {
+	for _, protocol := range protocols {
+		switch protocol {
+		case protocolBinary:
+			c.wire.Store(newBinaryCodec())
+		case protocolHeader:
+			atomic.StoreUint32(c.useHeader, 1)
+		}
+	}
+}
+
+// resetProtocols is called on reconnection, protocols are negotiated again.
+func (c *Conn) resetProtocols() {
+	c.wire.Store(nil)
+	atomic.StoreUint32(c.useHeader, 0)
+}
+
+// ackMessage returns the client's ack message, including the protocols to negotiate.
+func (c *Conn) ackMessage() []byte {
+	ack := append([]byte{ackBinary}, protocolHeader...)
+	if c.client != nil && c.client.BinaryProtocol {
+		ack = append(append(ack, ','), protocolBinary...)
+	}
+
+	return ack
+}
+
+// deserialize returns a Message from the "payload" read from the socket,
+// using the binary protocol if negotiated.
+func (c *Conn) deserialize(msgTyp MessageType, payload []byte) Message {
+	if bc := c.wire.Load(); bc != nil && msgTyp == BinaryMessage {
+		msg := bc.decode(payload)
+		if max := c.maxFieldLength(); max > 0 && !msg.isInvalid &&
+			(len(msg.Namespace) > max || len(msg.Room) > max || len(msg.Event) > max) {
+			return Message{isInvalid: true, Err: ErrFieldTooLong}
+		}
+		return msg
+	}
+
+	return c.DeserializeMessage(msgTyp, payload)
+}
+
+// writeMessage writes the "msg", or queues it if the connection has a write queue,
+// see `Server.WriteQueueSize`.
+func (c *Conn) writeMessage(msg Message) bool {
+	if c.writeQueue != nil {
+		return c.writeQueue.push(msg)
+	}
+
+	return c.writeMessageNow(msg)
+}
+
+// writeMessageNow writes the "msg" using the binary protocol if negotiated.
+func (c *Conn) writeMessageNow(msg Message) bool {
+	if atomic.LoadUint32(c.useHeader) == 0 {
+		msg.Header = nil
+	} else {
+		msg.Header = c.injectTrace(msg)
+	}
+
+	var (
+		b  []byte
+		ok bool
+	)
+
+	if bc := c.wire.Load(); bc == nil {
+		b = serializeMessage(msg)
+		ok = c.writeCompress(b, msg.SetBinary, msg.Compress)
+	} else {
+		var rollback func()
+		bc.encMutex.Lock()
+		b, rollback = bc.encode(msg)
+		if ok = c.writeCompress(b, true, msg.Compress); !ok {
+			rollback()
+		}
+		bc.encMutex.Unlock()
+	}
+
+	if ok {
+		if o := c.observer(); o != nil {
+			o.MessageSent(c, msg, len(b))
+		}
+	}
+
+	return ok
+}
+
+// WithBinaryProtocol is a `ClientOption` which sets the `Client.BinaryProtocol` to true.
+func WithBinaryProtocol() ClientOption {
+	return func(c *Client) {
+		c.BinaryProtocol = true
+	}
+}
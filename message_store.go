@@ -0,0 +1,426 @@
+package neffos
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessageStore keeps the messages of the `Server.Broadcast` which are sent to a specific connection
+// (their `Message.To` is filled) while that connection is not connected to their namespace,
+// see `Server.MessageStore`. The stored messages are sent, in order, when a connection
+// with the same ID (see `Server.IDGenerator`) connects to their namespace.
+// It should be safe for concurrent use.
+type MessageStore interface {
+	// Store keeps the undeliverable "msg" for the connection of the `Message.To`.
+	Store(msg Message) error
+	// Take removes and returns the stored messages of the connection "connID" for the "namespace",
+	// the oldest first.
+	Take(connID, namespace string) ([]Message, error)
+}
+
+// storedMessage is a message of a `MessageStore` queue.
+type storedMessage struct {
+	time time.Time
+	msg  Message
+}
+
+// messageQueue keeps the last "maxQueue" and not expired messages of a queue.
+func messageQueue(queue []storedMessage, now time.Time, ttl time.Duration, maxQueue int) []storedMessage {
+	if ttl > 0 {
+		i := 0
+		for i < len(queue) && now.Sub(queue[i].time) >= ttl {
+			i++
+		}
+		queue = queue[i:]
+	}
+
+	if maxQueue > 0 && len(queue) > maxQueue {
+		queue = queue[len(queue)-maxQueue:]
+	}
+
+	return queue
+}
+
+type messageQueueKey struct {
+	connID    string
+	namespace string
+}
+
+// MemoryMessageStore is an in-memory `MessageStore`.
+type MemoryMessageStore struct {
+	ttl      time.Duration
+	maxQueue int
+
+	queues map[messageQueueKey][]storedMessage
+	swept  time.Time
+	mu     sync.Mutex
+}
+
+var _ MessageStore = (*MemoryMessageStore)(nil)
+
+// NewMemoryMessageStore returns a new in-memory `MessageStore`.
+// The messages are kept for "ttl" and each queue, of a connection ID and a namespace,
+// keeps the last "maxQueue" messages. Zero values mean no limits.
+func NewMemoryMessageStore(ttl time.Duration, maxQueue int) *MemoryMessageStore {
+	return &MemoryMessageStore{
+		ttl:      ttl,
+		maxQueue: maxQueue,
+		queues:   make(map[messageQueueKey][]storedMessage),
+	}
+}
+
+// Store keeps the "msg" to the queue of its `Message.To` and namespace.
+func (s *MemoryMessageStore) Store(msg Message) error {
+	now := time.Now()
+	key := messageQueueKey{connID: msg.To, namespace: msg.Namespace}
+
+	s.mu.Lock()
+	s.sweep(now)
+	s.queues[key] = messageQueue(append(s.queues[key], storedMessage{time: now, msg: msg}), now, s.ttl, s.maxQueue)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// sweep removes the expired queues, at most once per TTL.
+func (s *MemoryMessageStore) sweep(now time.Time) {
+	if s.ttl <= 0 || now.Sub(s.swept) < s.ttl {
+		return
+	}
+
+	s.swept = now
+	for key, queue := range s.queues {
+		if queue = messageQueue(queue, now, s.ttl, s.maxQueue); len(queue) == 0 {
+			delete(s.queues, key)
+		} else {
+			s.queues[key] = queue
+		}
+	}
+}
+
+// Take removes and returns the not expired messages of a connection ID and a namespace.
+func (s *MemoryMessageStore) Take(connID, namespace string) ([]Message, error) {
+	key := messageQueueKey{connID: connID, namespace: namespace}
+
+	s.mu.Lock()
+	queue := messageQueue(s.queues[key], time.Now(), s.ttl, s.maxQueue)
+	delete(s.queues, key)
+	s.mu.Unlock()
+
+	msgs := make([]Message, len(queue))
+	for i, stored := range queue {
+		msgs[i] = stored.msg
+	}
+
+	return msgs, nil
+}
+
+// FileMessageStore is a `MessageStore` which keeps each queue, of a connection ID and a namespace,
+// to a file of a directory, so the messages are kept across server restarts.
+//
+// It's safe for concurrent use by a single process only: its queues are locked in-process,
+// so its directory must not be shared between servers, use a custom `MessageStore` of a database instead.
+type FileMessageStore struct {
+	dir      string
+	ttl      time.Duration
+	maxQueue int
+
+	swept time.Time
+	mu    sync.Mutex
+}
+
+var _ MessageStore = (*FileMessageStore)(nil)
+
+// fileMessageStoreExt is the extension of the `FileMessageStore` queue files.
+const fileMessageStoreExt = ".queue"
+
+// NewFileMessageStore returns a new `MessageStore` which keeps its queues to the "dir" directory,
+// it's created if it does not exist. The messages are kept for "ttl" and each queue
+// keeps the last "maxQueue" messages. Zero values mean no limits.
+func NewFileMessageStore(dir string, ttl time.Duration, maxQueue int) (*FileMessageStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMessageStore{
+		dir:      dir,
+		ttl:      ttl,
+		maxQueue: maxQueue,
+	}, nil
+}
+
+func (s *FileMessageStore) filename(connID, namespace string) string {
+	sum := sha256.Sum256([]byte(connID + "\x00" + namespace))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+fileMessageStoreExt)
+}
+
+// Store appends the "msg" to the queue file of its `Message.To` and namespace.
+func (s *FileMessageStore) Store(msg Message) error {
+	now := time.Now()
+	filename := s.filename(msg.To, msg.Namespace)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	queue, err := readMessageQueue(filename)
+	if err != nil {
+		return err
+	}
+
+	queue = messageQueue(append(queue, storedMessage{time: now, msg: msg}), now, s.ttl, s.maxQueue)
+	return writeMessageQueue(filename, queue)
+}
+
+// sweep removes the expired messages of all queue files, at most once per TTL.
+func (s *FileMessageStore) sweep(now time.Time) {
+	if s.ttl <= 0 || now.Sub(s.swept) < s.ttl {
+		return
+	}
+
+	s.swept = now
+	filenames, _ := filepath.Glob(filepath.Join(s.dir, "*"+fileMessageStoreExt))
+	for _, filename := range filenames {
+		queue, err := readMessageQueue(filename)
+		if err != nil {
+			continue
+		}
+
+		if queue = messageQueue(queue, now, s.ttl, s.maxQueue); len(queue) == 0 {
+			os.Remove(filename)
+		} else {
+			writeMessageQueue(filename, queue)
+		}
+	}
+}
+
+// Take removes the queue file of a connection ID and a namespace and returns its not expired messages.
+func (s *FileMessageStore) Take(connID, namespace string) ([]Message, error) {
+	filename := s.filename(connID, namespace)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue, err := readMessageQueue(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	queue = messageQueue(queue, time.Now(), s.ttl, s.maxQueue)
+	msgs := make([]Message, len(queue))
+	for i, stored := range queue {
+		msgs[i] = stored.msg
+	}
+
+	return msgs, nil
+}
+
+// A queue file is a sequence of records, each record is a
+// "<unix nanoseconds> <binary(0-1)> <size>\n<serialized message>\n" line.
+func readMessageQueue(filename string) ([]storedMessage, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		queue []storedMessage
+		r     = bufio.NewReader(f)
+	)
+
+	for {
+		var (
+			nanos        int64
+			binary, size int
+		)
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" {
+				return queue, nil
+			}
+			return nil, err
+		}
+
+		if _, err = fmt.Sscanf(strings.TrimSuffix(line, "\n"), "%d %d %d", &nanos, &binary, &size); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		b := make([]byte, size+1)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+
+		typ := MessageType(TextMessage)
+		if binary == 1 {
+			typ = BinaryMessage
+		}
+
+		queue = append(queue, storedMessage{
+			time: time.Unix(0, nanos),
+			msg:  DeserializeMessage(typ, b[:size], false, false),
+		})
+	}
+}
+
+// writeMessageQueue replaces the contents of the queue file atomically, it's removed if the "queue" is empty.
+func writeMessageQueue(filename string, queue []storedMessage) error {
+	if len(queue) == 0 {
+		if err := os.Remove(filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	// a unique temporary file, so a failed write never corrupts the one of another writer.
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	w := bufio.NewWriter(f)
+	for _, stored := range queue {
+		binary := 0
+		if stored.msg.SetBinary {
+			binary = 1
+		}
+
+		b := stored.msg.Serialize()
+		fmt.Fprintf(w, "%d %d %d\n", stored.time.UnixNano(), binary, len(b))
+		w.Write(b)
+		w.WriteByte('\n')
+	}
+
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// isOnline reports whether a connection of the "connID" is connected to the "namespace".
+// When a `StackExchange` is used the connections of the rest of the servers are checked
+// through the `Presence`, without a `Presence` it reports true, as it can not be known.
+func (s *Server) isOnline(connID, namespace string) bool {
+	if s.usesStackExchange() {
+		store := s.presenceStore()
+		if store == nil {
+			return true
+		}
+
+		online, err := store.IsMember(connID, namespace, "")
+		return online || err != nil
+	}
+
+	s.idsMutex.RLock()
+	defer s.idsMutex.RUnlock()
+
+	for c := range s.ids[connID] {
+		if c.Namespace(namespace) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// storeOffline keeps the messages of the "msgs" which are sent to a connection
+// that it's not connected to their namespace to the `MessageStore`
+// and returns the rest of the messages.
+//
+// The connection may connect between the check and the store, after its `deliverStored`,
+// so it's checked again after the store and, if it's online, the stored messages are taken back
+// and returned to be sent as the rest.
+func (s *Server) storeOffline(msgs []Message) []Message {
+	if s.MessageStore == nil {
+		return msgs
+	}
+
+	rest := msgs[:0:0]
+	for _, msg := range msgs {
+		if msg.To == "" || msg.wait != "" || msg.IsNative || s.isOnline(msg.To, msg.Namespace) {
+			rest = append(rest, msg)
+			continue
+		}
+
+		msg.from, msg.FromExplicit = "", ""
+		if err := s.MessageStore.Store(msg); err != nil {
+			// let it be lost as before.
+			continue
+		}
+
+		if s.isOnline(msg.To, msg.Namespace) {
+			stored, err := s.MessageStore.Take(msg.To, msg.Namespace)
+			if err != nil {
+				continue
+			}
+
+			rest = append(rest, stored...)
+		}
+	}
+
+	return rest
+}
+
+// deliverStored sends the stored messages of this server-side connection for a "namespace",
+// it's called when the namespace is connected.
+func (c *Conn) deliverStored(namespace string) {
+	if c.IsClient() || c.server.MessageStore == nil {
+		return
+	}
+
+	msgs, err := c.server.MessageStore.Take(c.ID(), namespace)
+	if err != nil {
+		c.reportError(err)
+		return
+	}
+
+	for _, msg := range msgs {
+		c.Write(msg)
+	}
+}
+
+// trackID adds or removes a server-side connection to the index of the connection IDs, see `isOnline`.
+func (s *Server) trackID(c *Conn, connected bool) {
+	s.idsMutex.Lock()
+	conns := s.ids[c.ID()]
+	if connected {
+		if conns == nil {
+			conns = make(map[*Conn]struct{})
+			s.ids[c.ID()] = conns
+		}
+		conns[c] = struct{}{}
+	} else {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(s.ids, c.ID())
+		}
+	}
+	s.idsMutex.Unlock()
+}
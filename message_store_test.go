@@ -0,0 +1,207 @@
+package neffos_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	gobwas "github.com/kataras/neffos/gobwas"
+	gorilla "github.com/kataras/neffos/gorilla"
+)
+
+func TestMessageStore(t *testing.T) {
+	var (
+		namespace = "default"
+		to        = "conn_ID"
+		servers   = make(map[string]*neffos.Server)
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(s *neffos.Server) {
+		s.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+			return r.URL.Query().Get("id")
+		}
+		s.MessageStore = neffos.NewMemoryMessageStore(time.Minute, 2)
+		if len(servers) == 0 {
+			servers["gobwas"] = s
+		} else {
+			servers["gorilla"] = s
+		}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		var (
+			server   = servers[dialer]
+			received = make(chan string, 10)
+			events   = neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- string(msg.Body)
+					return nil
+				},
+			}
+		)
+
+		expect := func(bodies ...string) {
+			t.Helper()
+
+			for _, body := range bodies {
+				select {
+				case got := <-received:
+					if got != body {
+						t.Fatalf("[%s] expected the message %q but got %q", dialer, body, got)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("[%s] expected the message %q to be delivered", dialer, body)
+				}
+			}
+
+			if len(received) > 0 {
+				t.Fatalf("[%s] expected no more messages but got %d", dialer, len(received))
+			}
+		}
+
+		// the connection is offline, only the last two messages are kept.
+		for i := 1; i <= 3; i++ {
+			server.Broadcast(nil, neffos.Message{Namespace: namespace, To: to, Event: "chat", Body: []byte(strconv.Itoa(i))})
+		}
+
+		client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer+"?id="+to, neffos.Namespaces{namespace: events})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+		expect("2", "3")
+
+		// the connection is online, the message is sent directly.
+		server.Broadcast(nil, neffos.Message{Namespace: namespace, To: to, Event: "chat", Body: []byte("4")})
+		expect("4")
+
+		client.Close()
+	}
+}
+
+// connectingMessageStore calls its "connect" once before the first store.
+type connectingMessageStore struct {
+	neffos.MessageStore
+	connect func()
+	once    sync.Once
+}
+
+func (s *connectingMessageStore) Store(msg neffos.Message) error {
+	s.once.Do(s.connect)
+	return s.MessageStore.Store(msg)
+}
+
+func TestMessageStoreConnectWhileStoring(t *testing.T) {
+	var (
+		namespace = "default"
+		to        = "conn_ID"
+		servers   = make(map[string]*neffos.Server)
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(s *neffos.Server) {
+		s.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+			return r.URL.Query().Get("id")
+		}
+		if len(servers) == 0 {
+			servers["gobwas"] = s
+		} else {
+			servers["gorilla"] = s
+		}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		received := make(chan string, 10)
+		events := neffos.Events{
+			"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- string(msg.Body)
+				return nil
+			},
+		}
+
+		client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer+"?id="+to, neffos.Namespaces{namespace: events})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the connection connects to the namespace after the check of the broadcast
+		// and before the message is stored, so its stored messages are already taken.
+		server := servers[dialer]
+		server.MessageStore = &connectingMessageStore{
+			MessageStore: neffos.NewMemoryMessageStore(time.Minute, 0),
+			connect: func() {
+				if _, err := client.Connect(context.TODO(), namespace); err != nil {
+					t.Error(err)
+				}
+			},
+		}
+
+		server.Broadcast(nil, neffos.Message{Namespace: namespace, To: to, Event: "chat", Body: []byte("1")})
+
+		select {
+		case got := <-received:
+			if expected := "1"; expected != got {
+				t.Fatalf("[%s] expected the message %q but got %q", dialer, expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%s] expected the message to be delivered", dialer)
+		}
+
+		select {
+		case got := <-received:
+			t.Fatalf("[%s] expected the message to be delivered once but got %q again", dialer, got)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		client.Close()
+	}
+}
+
+func TestFileMessageStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := neffos.NewFileMessageStore(dir, 100*time.Millisecond, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if err = store.Store(neffos.Message{Namespace: "default", To: "conn_ID", Event: "chat", Body: []byte(strconv.Itoa(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Store(neffos.Message{Namespace: "other", To: "conn_ID", Event: "chat", Body: []byte("other")})
+
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) != 0 {
+		t.Fatalf("expected no temporary files but got: %v", tmp)
+	}
+
+	msgs, err := store.Take("conn_ID", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs) != 2 || string(msgs[0].Body) != "2" || string(msgs[1].Body) != "3" || msgs[0].Event != "chat" {
+		t.Fatalf("expected the last two messages but got: %#v", msgs)
+	}
+
+	if msgs, _ = store.Take("conn_ID", "default"); len(msgs) != 0 {
+		t.Fatalf("expected the messages to be removed but got: %#v", msgs)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if msgs, _ = store.Take("conn_ID", "other"); len(msgs) != 0 {
+		t.Fatalf("expected the messages to be expired but got: %#v", msgs)
+	}
+}
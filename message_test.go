@@ -2,8 +2,10 @@ package neffos
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -140,3 +142,127 @@ func TestMessageSerialization(t *testing.T) {
 		t.Fatalf("expected a unescaped message to be:\n%#+v\n\tbut got:\n%#+v", msg, msgGot)
 	}
 }
+
+func TestBinaryProtocolSerialization(t *testing.T) {
+	var tests = []Message{
+		{Namespace: "default", Room: "room1", Event: OnNamespaceConnect, wait: "0"},
+		{Namespace: "default", Event: "chat", Body: []byte("a body with many ; delimeters; like that;")},
+		{Namespace: "default", Event: "chat", Err: fmt.Errorf("error message"), isError: true},
+		{Namespace: "contains;semi", Room: ";this;for sure;", Event: "chat", wait: "1", isNoOp: true},
+		{Namespace: "default", Event: "chat", Body: []byte{0, 1, 2}, SetBinary: true},
+		{Body: []byte("native"), IsNative: true},
+		{Namespace: "default", Event: "chat", Body: []byte("data"), Header: map[string]string{"traceparent": "00-01-02-01", "k": ""}},
+	}
+
+	encoder, decoder := newBinaryCodec(), newBinaryCodec()
+	for round := 0; round < 2; round++ {
+		for i, tt := range tests {
+			b, _ := encoder.encode(tt)
+			msg := decoder.decode(b)
+			if !reflect.DeepEqual(msg, tt) {
+				t.Fatalf("[%d:%d] expected\n%#+v but got\n%#+v", round, i, tt, msg)
+			}
+		}
+	}
+
+	first, _ := newBinaryCodec().encode(tests[1])
+	if second, _ := encoder.encode(tests[1]); len(second) >= len(first) {
+		t.Fatalf("expected interned namespace and event to reduce the message size")
+	}
+
+	b, rollback := encoder.encode(Message{Namespace: "other", Event: "chat"})
+	rollback()
+	if _, ok := encoder.encoded["other"]; ok {
+		t.Fatalf("expected rollback to unregister the namespace")
+	}
+	if msg := newBinaryCodec().decode(b[:len(b)-2]); !msg.isInvalid {
+		t.Fatalf("expected a truncated message to be invalid")
+	}
+}
+
+func TestBinaryProtocolInternedLimit(t *testing.T) {
+	encoder, decoder := newBinaryCodec(), newBinaryCodec()
+	for i := 0; i < maxInternedStrings; i++ {
+		b, _ := encoder.encode(Message{Namespace: "ns" + strconv.Itoa(i)})
+		if msg := decoder.decode(b); msg.isInvalid {
+			t.Fatalf("[%d] expected a valid message", i)
+		}
+	}
+
+	if expected, got := maxInternedStrings, len(decoder.decoded); expected != got {
+		t.Fatalf("expected %d interned strings but got %d", expected, got)
+	}
+
+	// a peer which registers more than the limit.
+	b := binary.AppendUvarint(nil, 0)                    // flags.
+	b = binary.AppendUvarint(b, 0)                       // wait.
+	b = binary.AppendUvarint(b, maxInternedStrings<<1|1) // new namespace.
+	b = binary.AppendUvarint(b, uint64(len("over")))
+	b = append(b, "over"...)
+
+	if msg := decoder.decode(b); !msg.isInvalid {
+		t.Fatalf("expected a message which exceeds the interned strings limit to be invalid")
+	}
+
+	if expected, got := maxInternedStrings, len(decoder.decoded); expected != got {
+		t.Fatalf("expected %d interned strings but got %d", expected, got)
+	}
+}
+
+func TestStackExchangeWait(t *testing.T) {
+	wait := genWaitStackExchange(genWait(false))
+	if !isStackExchangeWait(wait) {
+		t.Fatalf("expected %q to be a stackexchange wait token", wait)
+	}
+
+	// the marker is kept on the round trip, so the remote side's reply carries it back to the server.
+	msg := Message{Namespace: "default", Event: "ask", wait: wait}
+	if got := DeserializeMessage(TextMessage, serializeMessage(msg), false, false); got.wait != wait || !got.FromStackExchange {
+		t.Fatalf("expected the text protocol to keep the wait token %q but got %q", wait, got.wait)
+	}
+
+	b, _ := newBinaryCodec().encode(msg)
+	if got := newBinaryCodec().decode(b); got.wait != wait || !got.FromStackExchange {
+		t.Fatalf("expected the binary protocol to keep the wait token %q but got %q", wait, got.wait)
+	}
+
+	// the token of a previous version's server.
+	legacy := string(rune('1'+waitComesFromStackExchange)) + "234"
+	if got := DeserializeMessage(TextMessage, serializeMessage(Message{Namespace: "default", Event: "ask", wait: legacy}), false, false); got.wait != legacy || !got.FromStackExchange {
+		t.Fatalf("expected the previous version's wait token %q to be kept and accepted but got %q", legacy, got.wait)
+	}
+
+	for _, wait := range []string{genWait(false), genWait(true), string(waitIsDeliveryPrefix) + "1", string(waitIsConfirmationPrefix) + "1", "1!"} {
+		if isStackExchangeWait(wait) {
+			t.Fatalf("expected %q to not be a stackexchange wait token", wait)
+		}
+	}
+}
+
+func TestMessageHeaderSerialization(t *testing.T) {
+	var tests = []struct {
+		msg        Message
+		serialized []byte
+	}{
+		{
+			msg:        Message{Namespace: "default", Event: "chat", Body: []byte("data"), Header: map[string]string{"traceparent": "00-01-02-01", "b": "a value;with=specials&"}},
+			serialized: []byte(";default;;chat;0;0?b=a+value%3Bwith%3Dspecials%26&traceparent=00-01-02-01;data"),
+		},
+		{
+			msg:        Message{Namespace: "default", Event: "chat", isNoOp: true, wait: "1", Header: map[string]string{"k": "v"}},
+			serialized: []byte("1;default;;chat;0;1?k=v;"),
+		},
+	}
+
+	for i, tt := range tests {
+		got := serializeMessage(tt.msg)
+		if !bytes.Equal(got, tt.serialized) {
+			t.Fatalf("[%d] expected serialized message to be:\n%s\nbut got:\n%s", i, tt.serialized, got)
+		}
+
+		msg := DeserializeMessage(TextMessage, got, false, false)
+		if !reflect.DeepEqual(msg.Header, tt.msg.Header) || msg.isNoOp != tt.msg.isNoOp || !bytes.Equal(msg.Body, tt.msg.Body) {
+			t.Fatalf("[%d] expected deserialized message to be:\n%#+v\nbut got:\n%#+v", i, tt.msg, msg)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config is used on the `New` package-level function.
+// Can be used to customize the metrics names and their registry.
+type Config struct {
+	// Namespace is the prefix of the metrics names.
+	// Defaults to "neffos".
+	Namespace string
+	// Registry is the registry which the metrics are registered to
+	// and gathered from by the `Metrics.ServeHTTP`.
+	// Defaults to a new, empty, registry.
+	Registry *prometheus.Registry
+	// DurationBuckets are the buckets of the `Ask` and StackExchange publish latency histograms, in seconds.
+	// Defaults to `prometheus.DefBuckets`.
+	DurationBuckets []float64
+	// ReceiversBuckets are the buckets of the broadcast fan-out histogram.
+	// Defaults to 1, 5, 10, 50, 100, 500, 1000, 5000 and 10000 receivers.
+	ReceiversBuckets []float64
+	// WriteQueueBuckets are the buckets of the write queue depth histogram, see `neffos.Server.WriteQueueSize`.
+	// Defaults to 1, 2, 5, 10, 20, 50, 100, 200, 500 and 1000 messages.
+	WriteQueueBuckets []float64
+	// MaxEventLabels is the maximum number of different namespace and event label pairs
+	// (namespace labels without an event are counted too),
+	// the rest are reported under the "other" namespace and event.
+	// It protects the metrics of remote sides that send messages of arbitrary events.
+	// Defaults to 500.
+	MaxEventLabels int
+}
+
+// Metrics is a `neffos.Observer` which instruments a neffos server
+// with Prometheus counters and histograms.
+// It completes the `http.Handler` interface to expose its metrics.
+//
+// Usage:
+// m := metrics.New(metrics.Config{})
+// m.Instrument(server)
+// http.Handle("/metrics", m)
+type Metrics struct {
+	registry *prometheus.Registry
+	handler  http.Handler
+
+	connectionsOpened  prometheus.Counter
+	connectionsClosed  *prometheus.CounterVec
+	connectionsActive  prometheus.Gauge
+	namespaceConnects  *prometheus.CounterVec
+	roomJoins          *prometheus.CounterVec
+	messagesReceived   *prometheus.CounterVec
+	messagesSent       *prometheus.CounterVec
+	bytesReceived      prometheus.Counter
+	bytesSent          prometheus.Counter
+	askDuration        *prometheus.HistogramVec
+	broadcastReceivers prometheus.Histogram
+	writeFailures      prometheus.Counter
+	publishDuration    *prometheus.HistogramVec
+	writeQueued        prometheus.Gauge
+	writeQueueDepth    prometheus.Histogram
+	writeQueueDrops    *prometheus.CounterVec
+
+	maxEventLabels int
+	eventLabels    map[[2]string]struct{}
+	eventLabelsMu  sync.RWMutex
+}
+
+var (
+	_ neffos.Observer           = (*Metrics)(nil)
+	_ neffos.WriteQueueObserver = (*Metrics)(nil)
+)
+
+// New returns a new `Metrics` based on the "cfg".
+func New(cfg Config) *Metrics {
+	if cfg.Namespace == "" {
+		cfg.Namespace = "neffos"
+	}
+
+	if cfg.Registry == nil {
+		cfg.Registry = prometheus.NewRegistry()
+	}
+
+	if len(cfg.DurationBuckets) == 0 {
+		cfg.DurationBuckets = prometheus.DefBuckets
+	}
+
+	if len(cfg.ReceiversBuckets) == 0 {
+		cfg.ReceiversBuckets = []float64{1, 5, 10, 50, 100, 500, 1000, 5000, 10000}
+	}
+
+	if len(cfg.WriteQueueBuckets) == 0 {
+		cfg.WriteQueueBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+	}
+
+	if cfg.MaxEventLabels <= 0 {
+		cfg.MaxEventLabels = 500
+	}
+
+	ns := cfg.Namespace
+	m := &Metrics{
+		registry: cfg.Registry,
+		handler:  promhttp.HandlerFor(cfg.Registry, promhttp.HandlerOpts{}),
+
+		connectionsOpened: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "connections_opened_total",
+			Help: "Total number of opened connections.",
+		}),
+		connectionsClosed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "connections_closed_total",
+			Help: "Total number of closed connections by reason.",
+		}, []string{"reason"}),
+		connectionsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: ns, Name: "connections_active",
+			Help: "Number of the currently opened connections.",
+		}),
+		namespaceConnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "namespace_connects_total",
+			Help: "Total number of namespace connections.",
+		}, []string{"namespace"}),
+		roomJoins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "room_joins_total",
+			Help: "Total number of room joins by namespace.",
+		}, []string{"namespace"}),
+		messagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "messages_received_total",
+			Help: "Total number of incoming messages by namespace and event.",
+		}, []string{"namespace", "event"}),
+		messagesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "messages_sent_total",
+			Help: "Total number of outgoing messages by namespace and event.",
+		}, []string{"namespace", "event"}),
+		bytesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "received_bytes_total",
+			Help: "Total number of bytes of the incoming messages.",
+		}),
+		bytesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "sent_bytes_total",
+			Help: "Total number of bytes of the outgoing messages.",
+		}),
+		askDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns, Name: "ask_duration_seconds",
+			Help:    "Latency of the server-side Ask calls by namespace, event and result.",
+			Buckets: cfg.DurationBuckets,
+		}, []string{"namespace", "event", "result"}),
+		broadcastReceivers: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns, Name: "broadcast_receivers",
+			Help:    "Number of the local receivers (fan-out) of each broadcasted message.",
+			Buckets: cfg.ReceiversBuckets,
+		}),
+		writeFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "write_failures_total",
+			Help: "Total number of failed socket writes.",
+		}),
+		publishDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: ns, Name: "stackexchange_publish_duration_seconds",
+			Help:    "Latency of the StackExchange publish calls by result.",
+			Buckets: cfg.DurationBuckets,
+		}, []string{"result"}),
+		writeQueued: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: ns, Name: "write_queue_messages",
+			Help: "Number of the queued messages of all connections which are not written yet.",
+		}),
+		writeQueueDepth: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns, Name: "write_queue_depth",
+			Help:    "Length of a connection's write queue after each queued message.",
+			Buckets: cfg.WriteQueueBuckets,
+		}),
+		writeQueueDrops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns, Name: "write_queue_dropped_total",
+			Help: "Total number of the messages dropped by a full write queue, by policy.",
+		}, []string{"policy"}),
+
+		maxEventLabels: cfg.MaxEventLabels,
+		eventLabels:    make(map[[2]string]struct{}),
+	}
+
+	cfg.Registry.MustRegister(
+		m.connectionsOpened,
+		m.connectionsClosed,
+		m.connectionsActive,
+		m.namespaceConnects,
+		m.roomJoins,
+		m.messagesReceived,
+		m.messagesSent,
+		m.bytesReceived,
+		m.bytesSent,
+		m.askDuration,
+		m.broadcastReceivers,
+		m.writeFailures,
+		m.publishDuration,
+		m.writeQueued,
+		m.writeQueueDepth,
+		m.writeQueueDrops,
+	)
+
+	return m
+}
+
+// Instrument registers the metrics as the "server"'s `neffos.Server.Observer`.
+// It should be called before the server starts to accept connections.
+func (m *Metrics) Instrument(server *neffos.Server) *Metrics {
+	server.Observer = m
+	return m
+}
+
+// Registry returns the registry which the metrics are registered to.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// ServeHTTP completes the `http.Handler` interface,
+// it writes the metrics in the Prometheus text (or OpenMetrics) exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
+
+const otherLabel = "other"
+
+// eventLabel returns the namespace and event label values of a message,
+// limited by the `Config.MaxEventLabels`.
+func (m *Metrics) eventLabel(msg neffos.Message) (string, string) {
+	key := [2]string{msg.Namespace, msg.Event}
+
+	m.eventLabelsMu.RLock()
+	_, ok := m.eventLabels[key]
+	m.eventLabelsMu.RUnlock()
+	if ok {
+		return key[0], key[1]
+	}
+
+	m.eventLabelsMu.Lock()
+	defer m.eventLabelsMu.Unlock()
+
+	if _, ok = m.eventLabels[key]; !ok {
+		if len(m.eventLabels) >= m.maxEventLabels {
+			return otherLabel, otherLabel
+		}
+
+		m.eventLabels[key] = struct{}{}
+	}
+
+	return key[0], key[1]
+}
+
+// closeReason returns the reason label of a connection's close error.
+func closeReason(err error) string {
+	switch {
+	case err == nil:
+		return "local"
+	case neffos.IsTimeoutError(err):
+		return "timeout"
+	case neffos.IsCloseError(err):
+		return "remote"
+	default:
+		return "error"
+	}
+}
+
+func result(ok bool) string {
+	if ok {
+		return "success"
+	}
+
+	return "failure"
+}
+
+// ConnectionOpened completes the `neffos.Observer` interface.
+func (m *Metrics) ConnectionOpened(c *neffos.Conn) {
+	m.connectionsOpened.Inc()
+	m.connectionsActive.Inc()
+}
+
+// ConnectionClosed completes the `neffos.Observer` interface.
+func (m *Metrics) ConnectionClosed(c *neffos.Conn, err error) {
+	m.connectionsClosed.WithLabelValues(closeReason(err)).Inc()
+	m.connectionsActive.Dec()
+}
+
+// NamespaceConnected completes the `neffos.Observer` interface.
+func (m *Metrics) NamespaceConnected(c *neffos.Conn, namespace string) {
+	namespace, _ = m.eventLabel(neffos.Message{Namespace: namespace})
+	m.namespaceConnects.WithLabelValues(namespace).Inc()
+}
+
+// RoomJoined completes the `neffos.Observer` interface.
+func (m *Metrics) RoomJoined(c *neffos.Conn, namespace, room string) {
+	namespace, _ = m.eventLabel(neffos.Message{Namespace: namespace})
+	m.roomJoins.WithLabelValues(namespace).Inc()
+}
+
+// MessageReceived completes the `neffos.Observer` interface.
+func (m *Metrics) MessageReceived(c *neffos.Conn, msg neffos.Message, size int) {
+	m.messagesReceived.WithLabelValues(m.eventLabel(msg)).Inc()
+	m.bytesReceived.Add(float64(size))
+}
+
+// MessageSent completes the `neffos.Observer` interface.
+func (m *Metrics) MessageSent(c *neffos.Conn, msg neffos.Message, size int) {
+	m.messagesSent.WithLabelValues(m.eventLabel(msg)).Inc()
+	m.bytesSent.Add(float64(size))
+}
+
+// AskCompleted completes the `neffos.Observer` interface.
+func (m *Metrics) AskCompleted(c *neffos.Conn, msg neffos.Message, duration time.Duration, err error) {
+	namespace, event := m.eventLabel(msg)
+	m.askDuration.WithLabelValues(namespace, event, result(err == nil)).Observe(duration.Seconds())
+}
+
+// Broadcasted completes the `neffos.Observer` interface.
+func (m *Metrics) Broadcasted(msg neffos.Message, receivers int) {
+	if receivers >= 0 {
+		m.broadcastReceivers.Observe(float64(receivers))
+	}
+}
+
+// WriteFailed completes the `neffos.Observer` interface.
+func (m *Metrics) WriteFailed(c *neffos.Conn, err error) {
+	m.writeFailures.Inc()
+}
+
+// StackExchangePublished completes the `neffos.Observer` interface.
+func (m *Metrics) StackExchangePublished(msgs []neffos.Message, duration time.Duration, ok bool) {
+	m.publishDuration.WithLabelValues(result(ok)).Observe(duration.Seconds())
+}
+
+// WriteQueueChanged completes the `neffos.WriteQueueObserver` interface.
+func (m *Metrics) WriteQueueChanged(c *neffos.Conn, depth, delta int) {
+	m.writeQueued.Add(float64(delta))
+	if delta > 0 {
+		m.writeQueueDepth.Observe(float64(depth))
+	}
+}
+
+// WriteQueueDropped completes the `neffos.WriteQueueObserver` interface.
+func (m *Metrics) WriteQueueDropped(c *neffos.Conn, msg neffos.Message, policy neffos.WriteQueuePolicy) {
+	m.writeQueueDrops.WithLabelValues(policy.String()).Inc()
+}
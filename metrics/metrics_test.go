@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	// connect, namespace, echo and join room labels.
+	m := New(Config{MaxEventLabels: 4}).Instrument(server)
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, "ws"+strings.TrimPrefix(httpServer.URL, "http"), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.Ask(context.TODO(), "echo", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.JoinRoom(context.TODO(), "room1"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Emit("unknown", nil)
+
+	client.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	expectations := []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{"connections opened", testutil.ToFloat64(m.connectionsOpened), 1},
+		{"connections closed", testutil.ToFloat64(m.connectionsClosed), 1},
+		{"connections active", testutil.ToFloat64(m.connectionsActive), 0},
+		{"namespace connects", testutil.ToFloat64(m.namespaceConnects.WithLabelValues(namespace)), 1},
+		{"room joins", testutil.ToFloat64(m.roomJoins.WithLabelValues(namespace)), 1},
+		{"echo received", testutil.ToFloat64(m.messagesReceived.WithLabelValues(namespace, "echo")), 1},
+		{"echo sent", testutil.ToFloat64(m.messagesSent.WithLabelValues(namespace, "echo")), 1},
+	}
+
+	for _, tt := range expectations {
+		if tt.got != tt.expected {
+			t.Fatalf("%s: expected %v but got %v", tt.name, tt.expected, tt.got)
+		}
+	}
+
+	if testutil.ToFloat64(m.messagesReceived.WithLabelValues(otherLabel, otherLabel)) == 0 {
+		t.Fatalf("expected the unknown event to be counted as other, after the label limit was reached")
+	}
+
+	if testutil.ToFloat64(m.bytesReceived) == 0 || testutil.ToFloat64(m.bytesSent) == 0 {
+		t.Fatalf("expected received and sent bytes to be counted")
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `neffos_messages_received_total{event="echo",namespace="default"} 1`) {
+		t.Fatalf("unexpected exposition body:\n%s", body)
+	}
+}
@@ -0,0 +1,116 @@
+package neffos
+
+// Middleware wraps an event callback with cross-cutting logic,
+// e.g. authorization checks, logging or metrics.
+// It is called for user and system events (`OnNamespaceConnect`, `OnRoomJoin` and e.t.c.),
+// the "next" is the wrapped callback. A middleware can short-circuit the chain
+// by not calling the "next" and return an error (or a `Reply`) instead,
+// the error is handled as if it was returned by the event's callback itself,
+// i.e. on `OnNamespaceConnect` it will abort the remote namespace connection.
+// A middleware can pass values to the next callbacks through the message's context,
+// i.e. next(c, msg.WithContext(context.WithValue(msg.Context(), key, value))).
+//
+// See `Events.Use`, `Namespaces.Use`, `Struct.Use` and `Server.Use`.
+type Middleware func(next MessageHandlerFunc) MessageHandlerFunc
+
+// middlewaresEvent is the reserved key of the `Events` entry which keeps the middlewares
+// registered by the `Use` method, so a copy of the map keeps them too. It's never fired as an event,
+// the entry returns the middlewares as a `middlewareList`, see `Events.middlewares`.
+const middlewaresEvent = "_OnMiddlewares"
+
+// middlewareList is the value that the `middlewaresEvent` entry returns.
+type middlewareList []Middleware
+
+func (middlewareList) Error() string { return "neffos: middlewares" }
+
+// middlewares returns the middlewares registered by the `Use` method.
+func (e Events) middlewares() []Middleware {
+	h, ok := e[middlewaresEvent]
+	if !ok {
+		return nil
+	}
+
+	middlewares, _ := h(nil, Message{}).(middlewareList)
+	return middlewares
+}
+
+// len returns the number of the registered events, without the middlewares entry.
+func (e Events) len() int {
+	if _, ok := e[middlewaresEvent]; ok {
+		return len(e) - 1
+	}
+
+	return len(e)
+}
+
+// chainMiddlewares returns a callback which calls the "middlewares", in order, and the "h" last.
+func chainMiddlewares(h MessageHandlerFunc, middlewares []Middleware) MessageHandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+
+	return h
+}
+
+// Use registers one or more middlewares which wrap every event callback of these "e" Events,
+// including the system events and the events registered after this call.
+// Middlewares run in the order they are registered, across calls.
+// They are kept inside the map, a copy of it keeps them too,
+// and the `JoinConnHandlers` keeps the middlewares of all the joined Events of a namespace.
+// The chain is resolved when a namespace is connected, later calls do not affect the connected ones.
+//
+// See `Middleware` for more.
+func (e Events) Use(middlewares ...Middleware) Events {
+	if len(middlewares) == 0 || e == nil {
+		return e
+	}
+
+	current := e.middlewares()
+	list := make(middlewareList, 0, len(current)+len(middlewares))
+	list = append(append(list, current...), middlewares...)
+	e[middlewaresEvent] = func(*NSConn, Message) error {
+		return list
+	}
+
+	return e
+}
+
+// handler returns a callback which calls the "middlewares", the middlewares of these "e" Events
+// and the event's callback last. It's resolved once per `NSConn`, see `newNSConn`.
+func (e Events) handler(middlewares []Middleware) MessageHandlerFunc {
+	if own := e.middlewares(); len(own) > 0 {
+		middlewares = append(middlewares[:len(middlewares):len(middlewares)], own...)
+	}
+
+	return chainMiddlewares(e.dispatch, middlewares)
+}
+
+// Use registers one or more middlewares to all of these "nss" Namespaces,
+// see `Events.Use`. Namespaces that are registered after this call are not affected.
+func (nss Namespaces) Use(middlewares ...Middleware) Namespaces {
+	for _, events := range nss {
+		events.Use(middlewares...)
+	}
+
+	return nss
+}
+
+// Use registers one or more middlewares which wrap the event callbacks
+// built from the struct's methods, see `Events.Use`.
+func (s *Struct) Use(middlewares ...Middleware) *Struct {
+	s.middlewares = append(s.middlewares, middlewares...)
+	if s.events != nil {
+		s.events.Use(middlewares...)
+	}
+
+	return s
+}
+
+// Use registers one or more middlewares which wrap every event callback
+// of all namespaces of the server's connections, before the
+// namespace's middlewares (see `Events.Use`).
+// It should be called before the server starts to accept connections,
+// the namespaces which are already connected are not affected.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
@@ -0,0 +1,47 @@
+package neffos
+
+import (
+	"time"
+)
+
+// Observer can be optionally registered to a `Server` (see `Server.Observer`)
+// to be notified about the server's activity, i.e. to collect metrics.
+// Its methods are called synchronously, from the goroutines of the connections,
+// therefore they should be fast and safe for concurrent use.
+//
+// See the neffos/metrics subpackage for a Prometheus implementation.
+type Observer interface {
+	// ConnectionOpened is called when a new connection is upgraded.
+	ConnectionOpened(c *Conn)
+	// ConnectionClosed is called once, when a connection is closed.
+	// The "err" is the read error which caused the close or nil when it was closed locally, e.g. through `Conn.Close`.
+	ConnectionClosed(c *Conn, err error)
+	// NamespaceConnected is called when a connection is connected to a namespace.
+	NamespaceConnected(c *Conn, namespace string)
+	// RoomJoined is called when a connection is joined to a room of a namespace.
+	RoomJoined(c *Conn, namespace, room string)
+	// MessageReceived is called for each incoming message, "size" is its length in bytes.
+	MessageReceived(c *Conn, msg Message, size int)
+	// MessageSent is called for each message written to a connection, "size" is its length in bytes.
+	MessageSent(c *Conn, msg Message, size int)
+	// AskCompleted is called when a `Conn.Ask` call returned.
+	AskCompleted(c *Conn, msg Message, duration time.Duration, err error)
+	// Broadcasted is called for each message sent through `Server.Broadcast`,
+	// "receivers" is the number of the local connections which the message is delivered to,
+	// the members of the room for room messages, all the local connections for the rest.
+	// When a `StackExchange` is used the "receivers" is -1, the local receivers are not known.
+	Broadcasted(msg Message, receivers int)
+	// WriteFailed is called when a message could not be written to a connection.
+	WriteFailed(c *Conn, err error)
+	// StackExchangePublished is called when a `StackExchange.Publish` call returned.
+	StackExchangePublished(msgs []Message, duration time.Duration, ok bool)
+}
+
+// observer returns the server's `Observer` of a server-side connection, if any.
+func (c *Conn) observer() Observer {
+	if c.server == nil {
+		return nil
+	}
+
+	return c.server.Observer
+}
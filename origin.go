@@ -0,0 +1,119 @@
+package neffos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy describes which origins are allowed to connect to a `Server`,
+// it protects against cross-site websocket hijacking. See `Server.OriginPolicy`.
+//
+// A request is allowed if it matches the same origin (when AllowSameOrigin is true),
+// one of the AllowedOrigins or the Check function.
+// The non-browser clients, which don't send an "Origin" header, are allowed unless RejectMissingOrigin is true.
+type OriginPolicy struct {
+	// AllowedOrigins is a list of the allowed origins, i.e. "https://example.com".
+	// A "*" subdomain matches any subdomain, i.e. "https://*.example.com",
+	// an origin without a scheme matches any scheme, i.e. "example.com"
+	// and a single "*" allows any origin.
+	AllowedOrigins []string
+	// AllowSameOrigin allows the requests which their origin's host is the request's host.
+	AllowSameOrigin bool
+	// Check can be optionally registered to allow origins by a custom logic.
+	Check func(r *http.Request, origin string) bool
+	// RejectMissingOrigin rejects the requests without an "Origin" header.
+	RejectMissingOrigin bool
+}
+
+// ErrOriginNotAllowed is the error, reported to the `Server.OnUpgradeError`,
+// of the requests which were rejected by the `Server.OriginPolicy`.
+var ErrOriginNotAllowed = errors.New("origin not allowed")
+
+// Allow reports whether the request's origin is allowed by the policy.
+func (p *OriginPolicy) Allow(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return !p.RejectMissingOrigin
+	}
+
+	if p.AllowSameOrigin && SameOrigin(r) {
+		return true
+	}
+
+	for _, pattern := range p.AllowedOrigins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	return p.Check != nil && p.Check(r, origin)
+}
+
+// matchOrigin reports whether the "origin" matches the "pattern" of the `OriginPolicy.AllowedOrigins`.
+func matchOrigin(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+
+	host := origin
+	if idx := strings.Index(origin, "://"); idx != -1 {
+		if schemeIdx := strings.Index(pattern, "://"); schemeIdx != -1 {
+			if pattern[:schemeIdx] != origin[:idx] {
+				return false
+			}
+			pattern = pattern[schemeIdx+3:]
+		}
+		host = origin[idx+3:]
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return pattern == host
+}
+
+// SameOrigin reports whether the host of the request's "Origin" header is the request's host.
+// It returns true if the "Origin" header is missing.
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+type originCheckedKey struct{}
+
+// OriginChecked reports whether the request's origin was already allowed by the `Server.OriginPolicy`,
+// the upgraders should skip their own origin checks then.
+func OriginChecked(r *http.Request) bool {
+	checked, _ := r.Context().Value(originCheckedKey{}).(bool)
+	return checked
+}
+
+// checkOrigin applies the `Server.OriginPolicy`, if any, on failure it writes the 403 Forbidden status code.
+// It returns the request marked as checked, see `OriginChecked`.
+func (s *Server) checkOrigin(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	if s.OriginPolicy == nil {
+		return r, nil
+	}
+
+	if !s.OriginPolicy.Allow(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return r, fmt.Errorf("%w: %q", ErrOriginNotAllowed, r.Header.Get("Origin"))
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), originCheckedKey{}, true)), nil
+}
@@ -0,0 +1,42 @@
+package neffos
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	policy := &OriginPolicy{
+		AllowedOrigins:  []string{"https://example.com", "https://*.example.org", "example.net"},
+		AllowSameOrigin: true,
+	}
+
+	var tests = []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"https://example.com", true},
+		{"HTTPS://EXAMPLE.COM", true},
+		{"http://example.com", false},
+		{"https://app.example.org", true},
+		{"https://example.org", false},
+		{"https://evil-example.org", false},
+		{"http://example.net", true},
+		{"https://sub.example.net", false},
+		{"https://localhost:8080", true},
+		{"https://localhost:8081", false},
+		{"null", false},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+
+		if got := policy.Allow(r); tt.allowed != got {
+			t.Fatalf("[%s] expected allowed: %v but got: %v", tt.origin, tt.allowed, got)
+		}
+	}
+}
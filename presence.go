@@ -0,0 +1,419 @@
+package neffos
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Presence describes how the server tracks which connections are online
+// in its namespaces and rooms, across all the servers that share the same `PresenceStore`.
+// See `Server.Presence` and `Server.GetPresence`.
+//
+// A `Member` is recorded when a connection connects to a namespace or joins a room
+// and it's removed when it disconnects, leaves or closes. When a `Server.Heartbeat` is set,
+// the members are refreshed on the heartbeat's pongs and they expire after the TTL without one,
+// so the members of a server which went down unexpectedly are removed too.
+type Presence struct {
+	// Store keeps the members.
+	// Defaults to the `Server.StackExchange` if it completes the `PresenceStore` interface,
+	// as the redis and nats ones do, otherwise to a `MemoryPresence` of this server.
+	Store PresenceStore
+	// TTL is the time that a member is kept without a heartbeat refresh.
+	// It's ignored when the `Server.Heartbeat` is nil, the members never expire then.
+	// Defaults to the heartbeat interval multiplied by its `Heartbeat.MaxMissed` plus one.
+	TTL time.Duration
+	// Metadata can be optionally registered to attach user-defined data to the members,
+	// i.e. the display name of the connection's principal.
+	// The "room" is empty for the namespace's member.
+	Metadata func(ns *NSConn, room string) map[string]string
+	// Notify, if true, sends the `OnPresenceJoined` and `OnPresenceLeft` events
+	// to the members of a room when a connection joins or leaves it.
+	// Their body is the `Member` encoded by the namespace's `Codec`, see `On`.
+	Notify bool
+}
+
+// The events which are sent to the room members when the `Presence.Notify` is true.
+var (
+	// OnPresenceJoined is the event name which its callback is fired when another connection joined a room.
+	OnPresenceJoined = "_OnPresenceJoined"
+	// OnPresenceLeft is the event name which its callback is fired when another connection left a room.
+	OnPresenceLeft = "_OnPresenceLeft"
+)
+
+// Member describes a connection which is online in a namespace or in a room of a namespace.
+type Member struct {
+	ConnID string `json:"connID"`
+	// Key identifies the member's connection across all servers, unlike the ConnID
+	// it's unique even if connections share the same ID (see `Server.IDGenerator`).
+	// The stores key the members by it, or by the ConnID if it's empty.
+	Key string `json:"key,omitempty"`
+	// Subject is the `Principal.Subject` of an authenticated connection.
+	Subject   string `json:"subject,omitempty"`
+	Namespace string `json:"namespace"`
+	// Room is empty for the member of the namespace itself.
+	Room     string            `json:"room,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	JoinedAt time.Time         `json:"joinedAt"`
+	// ExpiresAt is zero if the member never expires, see `Presence.TTL`.
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// key returns the `Member.Key` or the `Member.ConnID` if it's empty.
+func (m Member) key() string {
+	if m.Key == "" {
+		return m.ConnID
+	}
+
+	return m.Key
+}
+
+// Expired reports whether the member should be considered offline at "now".
+func (m Member) Expired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt)
+}
+
+// PresenceStore keeps the members of the `Presence`, it should be safe for concurrent use.
+//
+// It's an optional interface for a `StackExchange` too,
+// so the members are shared between the servers of the stack exchange.
+type PresenceStore interface {
+	// Join adds the "member" or replaces the existing one of the same `Member.Key`, namespace and room.
+	Join(member Member) error
+	// Leave removes the member of the same `Member.Key`, namespace and room as the "member",
+	// the members of the rest of the connections with the same ID are kept.
+	Leave(member Member) error
+	// Members returns the members of the "room" of the "namespace", or of the namespace itself
+	// if "room" is empty, that are not expired.
+	Members(namespace, room string) ([]Member, error)
+	// IsMember reports whether a connection of the "connID" is a not expired member of the "room"
+	// of the "namespace", or of the namespace itself if "room" is empty.
+	IsMember(connID, namespace, room string) (bool, error)
+}
+
+// MemoryPresence is an in-memory `PresenceStore`, it's the default store of a single server.
+type MemoryPresence struct {
+	// namespace -> room -> connection ID -> member key -> member.
+	members map[string]map[string]map[string]map[string]Member
+	mu      sync.Mutex
+}
+
+var _ PresenceStore = (*MemoryPresence)(nil)
+
+// NewMemoryPresence returns a new in-memory `PresenceStore`.
+func NewMemoryPresence() *MemoryPresence {
+	return &MemoryPresence{
+		members: make(map[string]map[string]map[string]map[string]Member),
+	}
+}
+
+// Join adds or replaces the "member".
+func (p *MemoryPresence) Join(member Member) error {
+	p.mu.Lock()
+	rooms, ok := p.members[member.Namespace]
+	if !ok {
+		rooms = make(map[string]map[string]map[string]Member)
+		p.members[member.Namespace] = rooms
+	}
+
+	members, ok := rooms[member.Room]
+	if !ok {
+		members = make(map[string]map[string]Member)
+		rooms[member.Room] = members
+	}
+
+	conns, ok := members[member.ConnID]
+	if !ok {
+		conns = make(map[string]Member)
+		members[member.ConnID] = conns
+	}
+
+	conns[member.key()] = member
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Leave removes the "member".
+func (p *MemoryPresence) Leave(member Member) error {
+	p.mu.Lock()
+	p.delete(member)
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Members returns the not expired members of the "room" of the "namespace",
+// the expired ones are removed.
+func (p *MemoryPresence) Members(namespace, room string) ([]Member, error) {
+	now := time.Now()
+
+	p.mu.Lock()
+	members := p.members[namespace][room]
+	list := make([]Member, 0, len(members))
+	for _, conns := range members {
+		for _, member := range conns {
+			if member.Expired(now) {
+				p.delete(member)
+				continue
+			}
+
+			list = append(list, member)
+		}
+	}
+	p.mu.Unlock()
+
+	return list, nil
+}
+
+// IsMember reports whether a connection of the "connID" is a not expired member of the "room" of the "namespace",
+// the expired ones are removed.
+func (p *MemoryPresence) IsMember(connID, namespace, room string) (bool, error) {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ok := false
+	for _, member := range p.members[namespace][room][connID] {
+		if member.Expired(now) {
+			p.delete(member)
+			continue
+		}
+
+		ok = true
+	}
+
+	return ok, nil
+}
+
+func (p *MemoryPresence) delete(member Member) {
+	rooms, ok := p.members[member.Namespace]
+	if !ok {
+		return
+	}
+
+	if members, ok := rooms[member.Room]; ok {
+		if conns, ok := members[member.ConnID]; ok {
+			delete(conns, member.key())
+			if len(conns) == 0 {
+				delete(members, member.ConnID)
+			}
+		}
+
+		if len(members) == 0 {
+			delete(rooms, member.Room)
+		}
+	}
+
+	if len(rooms) == 0 {
+		delete(p.members, member.Namespace)
+	}
+}
+
+// presenceStore returns the `Presence.Store`, the stack exchange's one or the in-memory one.
+// It returns nil if the `Server.Presence` is nil.
+func (s *Server) presenceStore() PresenceStore {
+	if s.Presence == nil {
+		return nil
+	}
+
+	s.presenceOnce.Do(func() {
+		if s.Presence.Store != nil {
+			s.presence = s.Presence.Store
+		} else if store, ok := findStackExchange[PresenceStore](s.StackExchange); ok {
+			s.presence = store
+		} else {
+			s.presence = NewMemoryPresence()
+		}
+	})
+
+	return s.presence
+}
+
+// presenceTTL returns the `Presence.TTL` or its default value, zero if there is no heartbeat.
+func (s *Server) presenceTTL() time.Duration {
+	if s.Heartbeat == nil || s.Heartbeat.Interval <= 0 {
+		return 0
+	}
+
+	if s.Presence.TTL > 0 {
+		return s.Presence.TTL
+	}
+
+	return s.Heartbeat.Interval * time.Duration(s.Heartbeat.maxMissed()+1)
+}
+
+// GetPresence returns the members of the "room" of the "namespace", or of the namespace itself
+// if "room" is empty. Unlike the `GetRoomMembers` it includes the connections of the rest of the servers
+// that share the same `PresenceStore`, i.e. through a `StackExchange`.
+// It returns nil if the `Server.Presence` is nil.
+func (s *Server) GetPresence(namespace, room string) ([]Member, error) {
+	store := s.presenceStore()
+	if store == nil {
+		return nil, nil
+	}
+
+	return store.Members(namespace, room)
+}
+
+type presenceKey struct {
+	namespace string
+	room      string
+}
+
+// trackPresence records or removes the member of this server-side connection for the "room",
+// or for the namespace itself if "room" is empty.
+func (ns *NSConn) trackPresence(room string, joined bool) {
+	c := ns.Conn
+	if c == nil || c.IsClient() {
+		return
+	}
+
+	store := c.server.presenceStore()
+	if store == nil {
+		return
+	}
+
+	key := presenceKey{namespace: ns.namespace, room: room}
+	if !joined {
+		c.presenceMutex.Lock()
+		member, ok := c.presences[key]
+		delete(c.presences, key)
+		c.presenceMutex.Unlock()
+
+		if ok {
+			c.leavePresence(store, member)
+		}
+		return
+	}
+
+	c.presenceMutex.Lock()
+	if c.presenceID == "" {
+		c.presenceID = c.server.uuid + "." + strconv.FormatUint(atomic.AddUint64(&c.server.presenceSeq, 1), 10)
+	}
+	id := c.presenceID
+	c.presenceMutex.Unlock()
+
+	member := Member{
+		ConnID:    c.ID(),
+		Key:       id,
+		Namespace: ns.namespace,
+		Room:      room,
+		JoinedAt:  time.Now(),
+	}
+	if c.principal != nil {
+		member.Subject = c.principal.Subject()
+	}
+	if c.server.Presence.Metadata != nil {
+		member.Metadata = c.server.Presence.Metadata(ns, room)
+	}
+	if ttl := c.server.presenceTTL(); ttl > 0 {
+		member.ExpiresAt = member.JoinedAt.Add(ttl)
+	}
+
+	c.presenceMutex.Lock()
+	if c.presences == nil {
+		c.presences = make(map[presenceKey]Member)
+	}
+	c.presences[key] = member
+	c.presenceRefreshed = member.JoinedAt
+	c.presenceMutex.Unlock()
+
+	if err := store.Join(member); err != nil {
+		c.reportError(err)
+		return
+	}
+
+	if room != "" && c.server.Presence.Notify {
+		c.notifyPresence(OnPresenceJoined, member)
+	}
+}
+
+func (c *Conn) leavePresence(store PresenceStore, member Member) {
+	if err := store.Leave(member); err != nil {
+		c.reportError(err)
+		return
+	}
+
+	if member.Room != "" && c.server.Presence.Notify {
+		c.notifyPresence(OnPresenceLeft, member)
+	}
+}
+
+// notifyPresence sends the "event" of the "member" to the rest of the members of its room.
+func (c *Conn) notifyPresence(event string, member Member) {
+	codec := c.codec(member.Namespace)
+	body, err := codec.Marshal(member)
+	if err != nil {
+		c.reportError(err)
+		return
+	}
+
+	msg := Message{Namespace: member.Namespace, Room: member.Room, Event: event, Body: body}
+	if b, ok := codec.(BinaryCodec); ok {
+		msg.SetBinary = b.Binary()
+	}
+
+	c.server.Broadcast(c, msg)
+}
+
+// closePresence removes the remaining members of a closed connection.
+func (c *Conn) closePresence() {
+	store := c.server.presenceStore()
+	if store == nil {
+		return
+	}
+
+	c.presenceMutex.Lock()
+	presences := c.presences
+	c.presences = nil
+	c.presenceMutex.Unlock()
+
+	for _, member := range presences {
+		c.leavePresence(store, member)
+	}
+}
+
+// refreshPresence extends the expiration of the connection's members,
+// it's called on the heartbeat's pongs, at most once per half TTL.
+func (c *Conn) refreshPresence() {
+	if c.IsClient() {
+		return
+	}
+
+	store := c.server.presenceStore()
+	if store == nil {
+		return
+	}
+
+	ttl := c.server.presenceTTL()
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	c.presenceMutex.Lock()
+	if len(c.presences) == 0 || now.Sub(c.presenceRefreshed) < ttl/2 {
+		c.presenceMutex.Unlock()
+		return
+	}
+
+	c.presenceRefreshed = now
+	members := make([]Member, 0, len(c.presences))
+	for key, member := range c.presences {
+		member.ExpiresAt = now.Add(ttl)
+		c.presences[key] = member
+		members = append(members, member)
+	}
+	c.presenceMutex.Unlock()
+
+	for _, member := range members {
+		if err := store.Join(member); err != nil {
+			c.reportError(err)
+			return
+		}
+	}
+}
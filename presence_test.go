@@ -0,0 +1,222 @@
+package neffos_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+
+	gobwas "github.com/kataras/neffos/gobwas"
+	gorilla "github.com/kataras/neffos/gorilla"
+)
+
+func TestPresence(t *testing.T) {
+	var (
+		namespace = "default"
+		room      = "room1"
+		servers   = make(map[string]*neffos.Server)
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(s *neffos.Server) {
+		s.Presence = &neffos.Presence{
+			Notify: true,
+			Metadata: func(ns *neffos.NSConn, room string) map[string]string {
+				return map[string]string{"room": room}
+			},
+		}
+		if len(servers) == 0 {
+			servers["gobwas"] = s
+		} else {
+			servers["gorilla"] = s
+		}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		var (
+			server = servers[dialer]
+			joined = make(chan neffos.Member, 1)
+			left   = make(chan neffos.Member, 1)
+			events = neffos.Events{}
+		)
+
+		neffos.On(events, neffos.OnPresenceJoined, func(c *neffos.NSConn, m neffos.Member) error {
+			joined <- m
+			return nil
+		})
+		neffos.On(events, neffos.OnPresenceLeft, func(c *neffos.NSConn, m neffos.Member) error {
+			left <- m
+			return nil
+		})
+
+		connect := func() (*neffos.Client, *neffos.NSConn) {
+			client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer, neffos.Namespaces{namespace: events})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = c.JoinRoom(context.TODO(), room); err != nil {
+				t.Fatal(err)
+			}
+
+			return client, c
+		}
+
+		client1, _ := connect()
+		client2, c2 := connect()
+
+		select {
+		case m := <-joined:
+			if m.ConnID != c2.Conn.ID() || m.Room != room || m.Metadata["room"] != room {
+				t.Fatalf("[%s] unexpected joined member: %#v", dialer, m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%s] expected the first client to be notified about the second one", dialer)
+		}
+
+		for _, r := range []string{"", room} {
+			members, err := server.GetPresence(namespace, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected, got := 2, len(members); expected != got {
+				t.Fatalf("[%s] expected %d members of the %q room but got %d", dialer, expected, r, got)
+			}
+		}
+
+		client2.Close()
+
+		select {
+		case m := <-left:
+			if m.ConnID != c2.Conn.ID() {
+				t.Fatalf("[%s] expected the left member to be the second client but got: %s", dialer, m.ConnID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%s] expected the first client to be notified about the second one's leave", dialer)
+		}
+
+		// the namespace's member is removed after the room's one.
+		var members []neffos.Member
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+			members, _ = server.GetPresence(namespace, "")
+			if len(members) == 1 || time.Now().After(deadline) {
+				break
+			}
+		}
+
+		if expected, got := 1, len(members); expected != got {
+			t.Fatalf("[%s] expected %d member of the namespace but got %d", dialer, expected, got)
+		}
+
+		client1.Close()
+	}
+
+	store := neffos.NewMemoryPresence()
+	store.Join(neffos.Member{ConnID: "expired", Namespace: namespace, ExpiresAt: time.Now().Add(-time.Second)})
+	store.Join(neffos.Member{ConnID: "online", Namespace: namespace})
+	if ok, _ := store.IsMember("expired", namespace, ""); ok {
+		t.Fatalf("expected the expired member to not be a member")
+	}
+	if ok, _ := store.IsMember("online", namespace, ""); !ok {
+		t.Fatalf("expected the online member to be a member")
+	}
+	store.Join(neffos.Member{ConnID: "expired", Namespace: namespace, ExpiresAt: time.Now().Add(-time.Second)})
+	if members, _ := store.Members(namespace, ""); len(members) != 1 || members[0].ConnID != "online" {
+		t.Fatalf("expected the expired member to be removed but got: %#v", members)
+	}
+}
+
+func TestPresenceSharedID(t *testing.T) {
+	var (
+		namespace = "default"
+		room      = "room1"
+		servers   = make(map[string]*neffos.Server)
+	)
+
+	teardownServer := runTestServer("localhost:8080", neffos.Namespaces{namespace: neffos.Events{}}, func(s *neffos.Server) {
+		s.Presence = &neffos.Presence{}
+		s.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+			return "shared"
+		}
+		if len(servers) == 0 {
+			servers["gobwas"] = s
+		} else {
+			servers["gorilla"] = s
+		}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		server := servers[dialer]
+
+		connect := func() *neffos.Client {
+			client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer, neffos.Namespaces{namespace: neffos.Events{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = c.JoinRoom(context.TODO(), room); err != nil {
+				t.Fatal(err)
+			}
+
+			return client
+		}
+
+		client1 := connect()
+		client2 := connect()
+
+		members, err := server.GetPresence(namespace, room)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, got := 2, len(members); expected != got {
+			t.Fatalf("[%s] expected %d members of the same ID but got %d", dialer, expected, got)
+		}
+		if members[0].Key == members[1].Key {
+			t.Fatalf("[%s] expected the members of the same ID to have different keys but got: %s", dialer, members[0].Key)
+		}
+
+		// the leave of a connection keeps the member of the other one with the same ID.
+		client2.Close()
+
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+			members, _ = server.GetPresence(namespace, room)
+			if len(members) == 1 || time.Now().After(deadline) {
+				break
+			}
+		}
+
+		if expected, got := 1, len(members); expected != got {
+			t.Fatalf("[%s] expected %d member after the leave but got %d", dialer, expected, got)
+		}
+
+		client1.Close()
+	}
+
+	store := neffos.NewMemoryPresence()
+	store.Join(neffos.Member{ConnID: "shared", Key: "1", Namespace: namespace})
+	store.Join(neffos.Member{ConnID: "shared", Key: "2", Namespace: namespace})
+	store.Leave(neffos.Member{ConnID: "shared", Key: "2", Namespace: namespace})
+	if ok, _ := store.IsMember("shared", namespace, ""); !ok {
+		t.Fatalf("expected the member of the same ID to be kept after the leave of the other one")
+	}
+	store.Leave(neffos.Member{ConnID: "shared", Key: "1", Namespace: namespace})
+	if ok, _ := store.IsMember("shared", namespace, ""); ok {
+		t.Fatalf("expected no member after the leave of both connections")
+	}
+}
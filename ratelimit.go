@@ -0,0 +1,285 @@
+package neffos
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit is a token bucket limit of incoming messages.
+type RateLimit struct {
+	// Rate is the number of the allowed messages per second.
+	// Defaults to 0, unlimited.
+	Rate float64
+	// Burst is the maximum number of the messages which are allowed at once.
+	// Defaults to the Rate, at least 1.
+	Burst int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+// RateLimitAction describes what happens to the messages which exceed a `RateLimit`.
+type RateLimitAction uint8
+
+const (
+	// RateLimitDrop drops the message silently.
+	RateLimitDrop RateLimitAction = iota
+	// RateLimitReply drops the message and replies with a `*RateLimitedError` error,
+	// the remote side receives the `ErrRateLimited` through its `Message.Err`.
+	RateLimitReply
+	// RateLimitClose closes the connection with the `RateLimits.CloseCode` websocket close code.
+	RateLimitClose
+)
+
+// RateLimits can be optionally registered to a `Server` (see `Server.RateLimits`
+// and `Server.NamespaceRateLimits`) to protect it from connections that flood it with messages.
+// Replies to the pending server's messages (i.e. to `Conn.Ask`) and the heartbeat's pings and pongs
+// (see `Server.Heartbeat`) are never limited.
+type RateLimits struct {
+	// Conn limits the incoming messages of each connection.
+	// It's used only through the `Server.RateLimits`.
+	Conn RateLimit
+	// Namespace limits the incoming messages of each namespace of a connection.
+	Namespace RateLimit
+	// Events limits the incoming messages of specific events of each namespace of a connection,
+	// the key is the event's name.
+	Events map[string]RateLimit
+	// Action is the action for the messages which exceed the limits.
+	// Defaults to `RateLimitDrop`.
+	Action RateLimitAction
+	// CloseCode is the websocket close code of the `RateLimitClose` action.
+	// Defaults to `ClosePolicyViolation`.
+	CloseCode int
+	// MaxInFlightAsks limits the concurrent `Conn.Ask` calls of each connection,
+	// the rest of the calls fail with `ErrTooManyAsks`.
+	// It's used only through the `Server.RateLimits`.
+	// Defaults to 0, unlimited.
+	MaxInFlightAsks int
+}
+
+// ErrRateLimited is the error which the remote side receives, as its `Message.Err`,
+// when its message exceeded a rate limit of the `RateLimitReply` action.
+// Locally, the limited message's error is a `*RateLimitedError`, use the
+// `errors.Is(err, neffos.ErrRateLimited)` to check for both.
+var ErrRateLimited = &RateLimitedError{}
+
+// ErrTooManyAsks is returned by the `Conn.Ask` when the `RateLimits.MaxInFlightAsks` is exceeded.
+var ErrTooManyAsks = errors.New("too many in-flight asks")
+
+// RateLimitedError is the error of a message which exceeded a rate limit,
+// it is reported to the `Server.OnError`.
+type RateLimitedError struct {
+	Namespace string
+	Event     string
+	// RetryAfter is the minimum time until the limit allows a next message.
+	RetryAfter time.Duration
+}
+
+const rateLimitedErrorText = "rate limit exceeded"
+
+func (e *RateLimitedError) Error() string {
+	if *e == (RateLimitedError{}) {
+		return rateLimitedErrorText
+	}
+
+	return fmt.Sprintf("%s: namespace: %q: event: %q: retry after: %s", rateLimitedErrorText, e.Namespace, e.Event, e.RetryAfter)
+}
+
+// Is reports whether the "target" is the `ErrRateLimited`.
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// ResolveError completes the `RegisterKnownError` dynamic text interface,
+// the remote side's rate limit errors are resolved to the `ErrRateLimited`.
+func (e *RateLimitedError) ResolveError(errorText string) bool {
+	return strings.HasPrefix(errorText, rateLimitedErrorText)
+}
+
+// tokenBucket is the state of a `RateLimit`.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take reports whether a message is allowed, otherwise it returns the wait time until the next one.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) (bool, time.Duration) {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// connLimiter keeps the token buckets of a server-side connection.
+type connLimiter struct {
+	conn       tokenBucket
+	namespaces map[string]*tokenBucket
+	events     map[[2]string]*tokenBucket
+}
+
+func (l *connLimiter) bucket(namespace, event string) *tokenBucket {
+	if event == "" {
+		b, ok := l.namespaces[namespace]
+		if !ok {
+			b = new(tokenBucket)
+			l.namespaces[namespace] = b
+		}
+		return b
+	}
+
+	key := [2]string{namespace, event}
+	b, ok := l.events[key]
+	if !ok {
+		b = new(tokenBucket)
+		l.events[key] = b
+	}
+	return b
+}
+
+// rateLimits returns the limits of a "namespace", if any.
+func (s *Server) rateLimits(namespace string) *RateLimits {
+	if limits, ok := s.NamespaceRateLimits[namespace]; ok {
+		return limits
+	}
+
+	return s.RateLimits
+}
+
+// isReplyToServer reports whether an incoming message of a server-side connection
+// is a reply to a pending server's message, a wait token which is not pending
+// may be made up by the remote side to skip the limits.
+func (c *Conn) isReplyToServer(msg Message) bool {
+	if msg.wait == "" || msg.wait[0] == waitComesFromClientPrefix {
+		return false
+	}
+
+	if isDeliveryWait(msg.wait) {
+		// the client's acknowledgement of a server's message.
+		return msg.Event == ""
+	}
+
+	c.waitingMessagesMutex.RLock()
+	_, ok := c.waitingMessages[msg.wait]
+	c.waitingMessagesMutex.RUnlock()
+	if ok {
+		return true
+	}
+
+	c.server.waitingMessagesMutex.RLock()
+	_, ok = c.server.waitingMessages[msg.wait]
+	c.server.waitingMessagesMutex.RUnlock()
+
+	return ok
+}
+
+// limit applies the server's rate limits to an incoming message,
+// it returns a non-nil error if the message exceeded a limit and it was handled by the limit's action.
+func (c *Conn) limit(msg Message) error {
+	s := c.server
+	if s == nil || (s.RateLimits == nil && len(s.NamespaceRateLimits) == 0) || msg.isInvalid || c.isReplyToServer(msg) {
+		return nil
+	}
+
+	var (
+		now        = time.Now()
+		limits     = s.rateLimits(msg.Namespace)
+		ok         = true
+		retryAfter time.Duration
+	)
+
+	c.limiterMutex.Lock()
+	if c.limiter == nil {
+		c.limiter = &connLimiter{
+			namespaces: make(map[string]*tokenBucket),
+			events:     make(map[[2]string]*tokenBucket),
+		}
+	}
+
+	if s.RateLimits != nil && s.RateLimits.Conn.enabled() {
+		ok, retryAfter = c.limiter.conn.take(s.RateLimits.Conn, now)
+	}
+
+	// buckets are kept only for the registered namespaces.
+	if _, registered := c.namespaces[msg.Namespace]; ok && registered && limits != nil {
+		if limit, has := limits.Events[msg.Event]; has && limit.enabled() {
+			ok, retryAfter = c.limiter.bucket(msg.Namespace, msg.Event).take(limit, now)
+		}
+
+		if ok && limits.Namespace.enabled() {
+			ok, retryAfter = c.limiter.bucket(msg.Namespace, "").take(limits.Namespace, now)
+		}
+	}
+	c.limiterMutex.Unlock()
+
+	if ok {
+		return nil
+	}
+
+	err := &RateLimitedError{Namespace: msg.Namespace, Event: msg.Event, RetryAfter: retryAfter}
+	c.reportError(err)
+
+	action, closeCode := RateLimitDrop, ClosePolicyViolation
+	if limits != nil {
+		action = limits.Action
+		if limits.CloseCode > 0 {
+			closeCode = limits.CloseCode
+		}
+	}
+
+	switch action {
+	case RateLimitReply:
+		if isDeliveryWait(msg.wait) {
+			// not acknowledged, the remote side may send it again.
+			msg.wait = ""
+		}
+
+		msg.Err = err
+		c.Write(msg)
+	case RateLimitClose:
+		c.CloseWithCode(closeCode, rateLimitedErrorText)
+	}
+
+	return err
+}
+
+// acquireAsk reports whether a new `Conn.Ask` call is allowed by the `RateLimits.MaxInFlightAsks`,
+// the caller should call the `releaseAsk` when it's done.
+func (c *Conn) acquireAsk() bool {
+	if c.server == nil || c.server.RateLimits == nil || c.server.RateLimits.MaxInFlightAsks <= 0 {
+		return true
+	}
+
+	if n := atomic.AddInt32(c.inFlightAsks, 1); int(n) > c.server.RateLimits.MaxInFlightAsks {
+		atomic.AddInt32(c.inFlightAsks, -1)
+		return false
+	}
+
+	return true
+}
+
+func (c *Conn) releaseAsk() {
+	if c.server == nil || c.server.RateLimits == nil || c.server.RateLimits.MaxInFlightAsks <= 0 {
+		return
+	}
+
+	atomic.AddInt32(c.inFlightAsks, -1)
+}
@@ -0,0 +1,90 @@
+package neffos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var (
+		limit = RateLimit{Rate: 10, Burst: 3}
+		b     tokenBucket
+		now   = time.Now()
+	)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.take(limit, now); !ok {
+			t.Fatalf("[%d] expected the burst to be allowed", i)
+		}
+	}
+
+	ok, retryAfter := b.take(limit, now)
+	if ok {
+		t.Fatalf("expected the message after the burst to be limited")
+	}
+	if expected := 100 * time.Millisecond; retryAfter != expected {
+		t.Fatalf("expected retry after: %s but got: %s", expected, retryAfter)
+	}
+
+	if ok, _ = b.take(limit, now.Add(retryAfter)); !ok {
+		t.Fatalf("expected a message to be allowed after the retry time")
+	}
+
+	if ok, _ = b.take(limit, now.Add(time.Hour)); !ok {
+		t.Fatalf("expected a message to be allowed after a long time")
+	}
+	if b.tokens != 2 {
+		t.Fatalf("expected the tokens to be limited by the burst but got: %v", b.tokens)
+	}
+}
+
+func TestRateLimitsReplyToServer(t *testing.T) {
+	c := &Conn{
+		server: &Server{
+			RateLimits:      &RateLimits{Conn: RateLimit{Rate: 1, Burst: 1}},
+			waitingMessages: make(map[string]chan Message),
+		},
+		waitingMessages: make(map[string]chan Message),
+	}
+
+	if err := c.limit(Message{Event: "event"}); err != nil {
+		t.Fatalf("expected the first message to be allowed but got: %v", err)
+	}
+
+	// a made up wait token is not a reply.
+	if err := c.limit(Message{Event: "event", wait: "1"}); err == nil {
+		t.Fatalf("expected a message with a forged wait token to be limited")
+	}
+
+	c.waitingMessages["2"] = make(chan Message)
+	if err := c.limit(Message{Event: "event", wait: "2"}); err != nil {
+		t.Fatalf("expected the reply to a pending message to not be limited but got: %v", err)
+	}
+
+	if err := c.limit(Message{wait: string(waitIsDeliveryPrefix) + "3"}); err != nil {
+		t.Fatalf("expected a delivery acknowledgement to not be limited but got: %v", err)
+	}
+}
+
+func TestRateLimitsHeartbeat(t *testing.T) {
+	c := &Conn{
+		server: &Server{
+			RateLimits:      &RateLimits{Conn: RateLimit{Rate: 1, Burst: 1}, Action: RateLimitClose},
+			waitingMessages: make(map[string]chan Message),
+		},
+		waitingMessages: make(map[string]chan Message),
+		latency:         new(int64),
+		missedPings:     new(int32),
+	}
+
+	pong := serializeMessage(Message{Event: pongEvent, Body: []byte("0")})
+	for i := 0; i < 3; i++ {
+		if err := c.HandlePayload(TextMessage, pong); err != nil {
+			t.Fatalf("[%d] expected the pong to not be limited but got: %v", i, err)
+		}
+	}
+
+	if err := c.limit(Message{Event: "event"}); err != nil {
+		t.Fatalf("expected the heartbeat to not take a token but got: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package neffos
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// ErrPanic is the error which is sent to the remote side, as its `Message.Err`,
+// when a server-side event callback panics. The panic itself is reported
+// to the `Server.OnError` as a `*PanicError`.
+var ErrPanic = errors.New("event callback panic")
+
+// PanicError is the error which is reported to the `Server.OnError`
+// when a server-side event callback (or a middleware) panics.
+type PanicError struct {
+	Namespace string
+	Event     string
+	// Value is the value which was passed to the panic call.
+	Value interface{}
+	// Stack is the stack trace of the goroutine which panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s: namespace: %q: event: %q: %v", ErrPanic.Error(), e.Namespace, e.Event, e.Value)
+}
+
+// Is reports whether the "target" is the `ErrPanic`.
+func (e *PanicError) Is(target error) bool {
+	return target == ErrPanic
+}
+
+// recoverEvent recovers a panic of a server-side event callback, it's deferred by the `Events.fireEvent`.
+// The panic is reported to the `Server.OnError` and the event's error is set to `ErrPanic`,
+// so the remote side gets notified like any other event's error.
+func (c *Conn) recoverEvent(msg Message, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	*err = ErrPanic
+	c.reportError(&PanicError{
+		Namespace: msg.Namespace,
+		Event:     msg.Event,
+		Value:     v,
+		Stack:     debug.Stack(),
+	})
+
+	if c.server.CloseOnPanic {
+		if atomic.LoadUint32(c.isInsideHandler) == 1 {
+			// let the reader write the error reply first, see `startReader`.
+			atomic.StoreUint32(c.panicked, 1)
+			return
+		}
+
+		c.Close()
+	}
+}
+
+// reportError reports an "err" of a server-side connection to the `Server.OnError`, if registered.
+func (c *Conn) reportError(err error) {
+	if c.server != nil && c.server.OnError != nil {
+		c.server.OnError(c, err)
+	}
+}
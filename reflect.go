@@ -159,6 +159,38 @@ func makeMessageHandlerFuncType(forType reflect.Type, nsConnFieldIndex int) refl
 	return reflect.FuncOf(expectedIn, []reflect.Type{errType}, false)
 }
 
+// getTypedArg reports whether a method is a typed event callback, i.e
+// func(c *neffos.NSConn, v T) error or func(v T) error for dynamic structs,
+// and returns the type of its T argument.
+func getTypedArg(methodType reflect.Type, nsConnFieldIndex int) (reflect.Type, bool) {
+	expectedIn := 3 // receiver, NSConn and T.
+	if nsConnFieldIndex >= 0 {
+		expectedIn = 2
+	}
+
+	if methodType.NumIn() != expectedIn || methodType.NumOut() != 1 || methodType.Out(0) != errType {
+		return nil, false
+	}
+
+	if expectedIn == 3 && methodType.In(1) != nsConnType {
+		return nil, false
+	}
+
+	argType := methodType.In(expectedIn - 1)
+	if argType == msgType || argType == nsConnType {
+		return nil, false
+	}
+
+	return argType, true
+}
+
+// isNSConnMethod reports whether a method is promoted from an embedded *NSConn,
+// e.g. the `NSConn.Disconnect`, those are never event callbacks.
+func isNSConnMethod(method reflect.Method) bool {
+	_, ok := nsConnType.MethodByName(method.Name)
+	return ok
+}
+
 func isArgOf(fnType reflect.Type, argType reflect.Type) bool {
 	if fnType.Kind() != reflect.Func {
 		panic("isArgOf used on a non-method type")
@@ -173,7 +205,7 @@ func isArgOf(fnType reflect.Type, argType reflect.Type) bool {
 	return false
 }
 
-func makeEventFromMethod(v reflect.Value, method reflect.Method, eventMatcher EventMatcherFunc) (eventName string, cb MessageHandlerFunc) {
+func makeEventFromMethod(v reflect.Value, method reflect.Method, eventMatcher EventMatcherFunc, typedArg reflect.Type) (eventName string, cb MessageHandlerFunc) {
 	eventName = method.Name
 
 	// if method looks like a system event, i.e
@@ -198,7 +230,14 @@ func makeEventFromMethod(v reflect.Value, method reflect.Method, eventMatcher Ev
 		}
 	}
 
-	if isArgOf(method.Type, nsConnType) {
+	if typedArg != nil {
+		if IsSystemEvent(eventName) {
+			// system events are not typed.
+			return "", nil
+		}
+
+		cb = makeTypedEventFromMethod(v, method, typedArg)
+	} else if isArgOf(method.Type, nsConnType) {
 		// it should accept NSConn - static "controller".
 		cb = v.Method(method.Index).Interface().(func(*NSConn, Message) error)
 	} else {
@@ -212,10 +251,43 @@ func makeEventFromMethod(v reflect.Value, method reflect.Method, eventMatcher Ev
 	return
 }
 
+// makeTypedEventFromMethod returns an event callback which decodes the incoming message's body
+// to a value of "argType", using the namespace's `Codec`, and calls the typed method, see `On`.
+func makeTypedEventFromMethod(v reflect.Value, method reflect.Method, argType reflect.Type) MessageHandlerFunc {
+	isStatic := isArgOf(method.Type, nsConnType)
+
+	return func(c *NSConn, msg Message) error {
+		if msg.Err != nil {
+			return nil
+		}
+
+		arg := reflect.New(argType)
+		if err := c.decodeTyped(msg.Body, arg.Interface()); err != nil {
+			return err
+		}
+
+		var out []reflect.Value
+		if isStatic {
+			out = v.Method(method.Index).Call([]reflect.Value{reflect.ValueOf(c), arg.Elem()})
+		} else {
+			// load an existing instance which contains the same "c".
+			out = c.value.Method(method.Index).Call([]reflect.Value{arg.Elem()})
+		}
+
+		err, _ := out[0].Interface().(error)
+		return err
+	}
+}
+
 // StructInjector is a type which injects a dynamic struct value.
 // See `Struct.SetInjector` for more.
 type StructInjector func(structType reflect.Type, nsConn *NSConn) (structValue reflect.Value)
 
+// StructMessageInjector acts like the `StructInjector` but it accepts
+// the `OnNamespaceConnect` message as well, i.e. to read its `Message.Header`.
+// See `Struct.SetMessageInjector` for more.
+type StructMessageInjector func(structType reflect.Type, nsConn *NSConn, msg Message) (structValue reflect.Value)
+
 func nameOf(structType reflect.Type) string {
 	structType = indirectType(structType)
 
@@ -225,7 +297,7 @@ func nameOf(structType reflect.Type) string {
 	return fullname
 }
 
-func makeEventsFromStruct(v reflect.Value, eventMatcher EventMatcherFunc, injector StructInjector) Events {
+func makeEventsFromStruct(v reflect.Value, eventMatcher EventMatcherFunc, injector StructMessageInjector, typedMethods bool) Events {
 	events := make(Events)
 
 	typ := v.Type()
@@ -237,11 +309,19 @@ func makeEventsFromStruct(v reflect.Value, eventMatcher EventMatcherFunc, inject
 	for i, n := 0, typ.NumMethod(); i < n; i++ {
 		method := typ.Method(i)
 
+		var typedArg reflect.Type
 		if method.Type != msgHandlerType {
-			continue
+			if !typedMethods {
+				continue
+			}
+
+			var ok bool
+			if typedArg, ok = getTypedArg(method.Type, nsConnFieldIndex); !ok || isNSConnMethod(method) {
+				continue
+			}
 		}
 
-		eventName, cb := makeEventFromMethod(v, method, eventMatcher)
+		eventName, cb := makeEventFromMethod(v, method, eventMatcher, typedArg)
 		if cb == nil {
 			continue
 		}
@@ -275,7 +355,7 @@ func makeEventsFromStruct(v reflect.Value, eventMatcher EventMatcherFunc, inject
 				Debugf("Field [%s.%s] marked as static on value [%v]", nameOf(typ), fname, fval)
 			})
 
-			injector = func(typ reflect.Type, nsConn *NSConn) reflect.Value {
+			injector = func(typ reflect.Type, nsConn *NSConn, msg Message) reflect.Value {
 				return reflect.New(typ)
 			}
 		}
@@ -283,7 +363,7 @@ func makeEventsFromStruct(v reflect.Value, eventMatcher EventMatcherFunc, inject
 		cb, hasNamespaceConnect := events[OnNamespaceConnect]
 
 		events[OnNamespaceConnect] = func(c *NSConn, msg Message) error {
-			cachePtr := injector(typ, c)
+			cachePtr := injector(typ, c, msg)
 			cacheElem := cachePtr.Elem()
 
 			// set the NSConn dynamic field.
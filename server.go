@@ -59,6 +59,66 @@ type Server struct {
 	// Therefore, if set to true,
 	// each broadcast call will publish its own message(s) by order.
 	SyncBroadcaster bool
+	// OutboxSize is the maximum number of unacknowledged messages, sent with at-least-once delivery,
+	// that each connection retains. See `Conn.WriteReliable`.
+	// Defaults to `DefaultOutboxSize`.
+	OutboxSize int
+	// OutboxTTL is the time that the unacknowledged messages of a closed connection are kept,
+	// a new connection with the same ID (see `IDGenerator`) takes them over
+	// and they are sent again when it connects to their namespace.
+	// Defaults to `DefaultOutboxTTL`.
+	OutboxTTL time.Duration
+	// RateLimits can be optionally set to limit the incoming messages of each connection,
+	// see `RateLimits` for more.
+	// Defaults to nil, unlimited.
+	RateLimits *RateLimits
+	// NamespaceRateLimits can be optionally set to use different `RateLimits`
+	// for specific namespaces, it has priority over the `RateLimits` field,
+	// except its `Conn` and `MaxInFlightAsks` limits which apply to the whole connection.
+	NamespaceRateLimits map[string]*RateLimits
+	// WriteQueueSize, if greater than zero, enables a bounded outgoing messages queue
+	// for each connection, of that size. The queued messages are written by a goroutine
+	// of the connection, so a slow connection does not block its writers, i.e. the `Broadcast`.
+	// Keep note that the `Conn.Write` reports whether the message was queued then, not written.
+	// Defaults to 0, messages are written synchronously.
+	WriteQueueSize int
+	// WriteQueuePolicy is the policy of a full write queue, see `WriteQueueSize`.
+	// Defaults to `WriteQueueDropOldest`.
+	WriteQueuePolicy WriteQueuePolicy
+	// WriteQueueTimeout is the maximum time that a write waits for room in a full write queue
+	// of the `WriteQueueBlock` policy.
+	// Defaults to `DefaultWriteQueueTimeout`.
+	WriteQueueTimeout time.Duration
+	// MaxMessageSize, if greater than zero, is the maximum size, in bytes, of an incoming message.
+	// The connections which send bigger messages are closed with the `CloseMessageTooBig`
+	// websocket close code and the `ErrMessageTooBig` error. The socket should complete
+	// the `SocketReadLimiter` interface, as the `gorilla` and `gobwas` ones do.
+	// Defaults to 0, unlimited.
+	MaxMessageSize int64
+	// MaxFieldLength, if greater than zero, is the maximum length of the namespace, room
+	// and event fields of an incoming message. The connections which send longer fields
+	// are closed with the `ClosePolicyViolation` websocket close code.
+	// Defaults to 0, unlimited.
+	MaxFieldLength int
+	// MaxNamespaces, if greater than zero, is the maximum number of the namespaces
+	// that a connection can connect to at the same time. The connections which exceed it
+	// receive the `ErrTooManyNamespaces` error and they are closed with the `ClosePolicyViolation` websocket close code.
+	// The `Conn.Connect` calls of the server-side are not limited.
+	// Defaults to 0, unlimited.
+	MaxNamespaces int
+	// MaxRooms, if greater than zero, is the maximum number of the rooms
+	// that a connection can join per namespace. The connections which exceed it
+	// receive the `ErrTooManyRooms` error and they are closed with the `ClosePolicyViolation` websocket close code.
+	// The `NSConn.JoinRoom` calls of the server-side are not limited.
+	// Defaults to 0, unlimited.
+	MaxRooms int
+	// Codec is the `Codec` of the server's namespaces, see `EmitTyped` and `OnTyped`.
+	// Defaults to nil, the `DefaultCodec` is used instead.
+	Codec Codec
+	// NamespaceCodecs can be optionally set to use a different `Codec`
+	// for specific namespaces, it has priority over the `Codec` field.
+	// The clients of these namespaces should use the same codecs.
+	NamespaceCodecs map[string]Codec
 	// FireDisconnectAlways will allow firing the `OnDisconnect` server's
 	// event even if the connection wasimmediately closed from the `OnConnect` server's event
 	// through `Close()` or non-nil error.
@@ -66,6 +126,38 @@ type Server struct {
 	//
 	// Defaults to false.
 	FireDisconnectAlways bool
+	// Compression can be optionally set to negotiate the per-message compression (permessage-deflate)
+	// with the clients which support it, see `Compression` and `Message.Compress`.
+	// Defaults to nil, the messages are not compressed.
+	Compression *Compression
+	// Heartbeat can be optionally set to ping the connections periodically,
+	// measure their latency (see `Conn.Latency`) and close the dead ones.
+	// Defaults to nil, no pings are sent.
+	Heartbeat *Heartbeat
+	// Presence can be optionally set to track which connections are online
+	// in the namespaces and rooms of this and the rest of the servers, see `GetPresence`.
+	// Defaults to nil, no presence is tracked.
+	Presence *Presence
+	// RoomHistory can be optionally set to record the broadcasted room messages,
+	// so the connections can receive them later through the `Room.Replay`.
+	// Defaults to nil, no messages are recorded.
+	RoomHistory *RoomHistory
+	// MessageStore can be optionally set to keep the broadcasted messages to a specific connection
+	// (see `Message.To`) while that connection is not connected to their namespace
+	// and send them when a connection with the same ID (see `IDGenerator`) connects to it.
+	// When a `StackExchange` is used the `Presence` must be set too,
+	// so the connections of the rest of the servers are known,
+	// and all servers must share the same store, i.e. a custom one of a database:
+	// the `MemoryMessageStore` and the `FileMessageStore` are not shared between processes,
+	// a server never sends their messages to a connection which connects to another server.
+	// See `NewMemoryMessageStore` and `NewFileMessageStore`.
+	// Defaults to nil, the messages to offline connections are lost.
+	MessageStore MessageStore
+	// ReconnectHint is the reason of the `CloseGoingAway` close frame which the `Shutdown` sends
+	// to the connections, e.g. the URL of another server that the clients should reconnect to.
+	// Clients with a `Client.Reconnect` policy reconnect to it if it's a "ws" or "wss" URL.
+	// Defaults to empty, the clients reconnect to the same URL.
+	ReconnectHint string
 
 	mu         sync.RWMutex
 	namespaces Namespaces
@@ -83,14 +175,48 @@ type Server struct {
 	broadcastMessages chan []Message
 
 	broadcaster *broadcaster
+	// the joined rooms of the local connections, see `GetRoomMembers`.
+	rooms *roomRegistry
+	// the resolved store of the `Presence`, see `presenceStore`.
+	presence     PresenceStore
+	presenceOnce sync.Once
+	presenceSeq  uint64 // generates the `Member.Key` of each connection.
+	// the resolved store of the `RoomHistory`, see `historyStore`.
+	history     HistoryStore
+	historyOnce sync.Once
+	// the local connections by their IDs, see `isOnline`.
+	ids      map[string]map[*Conn]struct{}
+	idsMutex sync.RWMutex
 
 	// messages that this server must waits
 	// for a reply from one of its own connections(see `waitMessages`).
 	waitingMessages      map[string]chan Message
 	waitingMessagesMutex sync.RWMutex
 
+	// unacknowledged messages of closed connections by their ID, see `parkOutbox`.
+	outboxes      map[string]*outbox
+	outboxesMutex sync.Mutex
+
+	// server-wide middlewares, see `Use`.
+	middlewares []Middleware
+
 	closed uint32
 
+	// OriginPolicy can be optionally set to allow only specific origins to connect,
+	// it applies to any `Upgrader`. The rejected requests receive the 403 Forbidden status code
+	// and the `ErrOriginNotAllowed` error is reported to the `OnUpgradeError`.
+	// Defaults to nil, the origin is checked by the `Upgrader` itself, if any.
+	OriginPolicy *OriginPolicy
+	// Authenticate can be optionally registered to authenticate the handshake requests
+	// before they are upgraded. A non-nil error rejects the request with the 401 Unauthorized status code,
+	// otherwise the returned `Principal` is available through the `Conn.Principal`,
+	// a nil `Principal` accepts an anonymous connection.
+	// See the `BearerToken`, `CookieToken`, `QueryToken` and `HeaderToken` helpers too.
+	Authenticate func(r *http.Request) (Principal, error)
+	// NamespaceAuthorizers can be optionally set to allow only specific principals
+	// to connect to a namespace, the rest of the connections receive the `ErrForbidden` error
+	// before the namespace's `OnNamespaceConnect` event is fired.
+	NamespaceAuthorizers map[string]Authorizer
 	// OnUpgradeError can be optionally registered to catch upgrade errors.
 	OnUpgradeError func(err error)
 	// OnConnect can be optionally registered to be notified for any new neffos client connection,
@@ -101,6 +227,22 @@ type Server struct {
 	// OnDisconnect can be optionally registered to notify about a connection's disconnect.
 	// Don't confuse it with the `OnNamespaceDisconnect`, this callback is for the entire client side connection.
 	OnDisconnect func(c *Conn)
+	// OnError can be optionally registered to be notified about the errors of the connections
+	// that can not be returned to the caller, i.e. a panic of an event callback (a `*PanicError`),
+	// an incoming message with invalid format (`ErrInvalidPayload`) and socket write failures.
+	OnError func(c *Conn, err error)
+	// Observer can be optionally registered to be notified about the server's activity,
+	// i.e. to collect metrics, see the neffos/metrics subpackage.
+	// It should be set before the server starts to accept connections.
+	Observer Observer
+	// Tracer can be optionally registered to trace the messages across the connections
+	// and the servers of a `StackExchange`, see the neffos/tracing subpackage.
+	// It should be set before the server starts to accept connections.
+	Tracer Tracer
+	// CloseOnPanic, if true, closes a connection after one of its event callbacks panicked,
+	// the remote side receives the `ErrPanic` error first.
+	// Defaults to false, the panic is recovered and the connection is kept.
+	CloseOnPanic bool
 }
 
 // New constructs and returns a new neffos server.
@@ -125,7 +267,10 @@ func New(upgrader Upgrader, connHandler ConnHandler) *Server {
 		actions:           make(chan action),
 		broadcastMessages: make(chan []Message),
 		broadcaster:       newBroadcaster(),
+		rooms:             newRoomRegistry(),
 		waitingMessages:   make(map[string]chan Message),
+		outboxes:          make(map[string]*outbox),
+		ids:               make(map[string]map[*Conn]struct{}),
 		IDGenerator:       DefaultIDGenerator,
 	}
 
@@ -176,6 +321,7 @@ func (s *Server) start() {
 			if _, ok := s.connections[c]; ok {
 				// close(c.out)
 				delete(s.connections, c)
+				s.trackID(c, false)
 				atomic.AddUint64(&s.count, ^uint64(0))
 				// println("disconnect...")
 				if s.OnDisconnect != nil {
@@ -215,6 +361,70 @@ func (s *Server) Close() {
 	}
 }
 
+// shutdownPollInterval is the interval that the `Shutdown` checks whether the server is drained.
+var shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown gracefully shuts down the server. It stops accepting new connections,
+// waits until the in-flight event handlers, the pending `Ask` calls, the write queues
+// and the unacknowledged `Conn.WriteReliable` messages are done,
+// flushes the `StackExchange` (see `StackExchangeFlusher`) and then
+// closes the connections with the `CloseGoingAway` code and the `ReconnectHint` reason.
+//
+// The connections are closed when the "ctx" is done too, its error is returned then.
+// Use the `Close` method for an immediate termination instead.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapUint32(&s.closed, 0, 1) {
+		return errServerClosed
+	}
+
+	err := s.drain(ctx)
+	if s.usesStackExchange() {
+		if flushErr := stackExchangeFlush(ctx, s.StackExchange); err == nil {
+			err = flushErr
+		}
+	}
+
+	s.Do(func(c *Conn) {
+		c.CloseWithCode(CloseGoingAway, s.ReconnectHint)
+	}, false)
+
+	return err
+}
+
+// drain blocks until the server and its connections are idle or the "ctx" is done.
+func (s *Server) drain(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for !s.isIdle() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) isIdle() bool {
+	s.waitingMessagesMutex.RLock()
+	n := len(s.waitingMessages)
+	s.waitingMessagesMutex.RUnlock()
+	if n > 0 {
+		return false
+	}
+
+	idle := true
+	s.Do(func(c *Conn) {
+		if idle && c.isBusy() {
+			idle = false
+		}
+	}, false)
+
+	return idle
+}
+
 var (
 	errServerClosed  = errors.New("server closed")
 	errInvalidMethod = errors.New("no valid request method")
@@ -270,7 +480,7 @@ func isServerConnID(s string) bool {
 }
 
 func genServerConnID(s *Server, c *Conn) string {
-	return fmt.Sprintf("neffos(0x%s(%s%p))", s.uuid, c.id, c)
+	return fmt.Sprintf("neffos(0x%s(%s%p))", s.uuid, c.ID(), c)
 }
 
 // Upgrade handles the connection, same as `ServeHTTP` but it can accept
@@ -304,8 +514,28 @@ func (s *Server) Upgrade(
 		return nil, errInvalidMethod
 	}
 
+	r, err := s.checkOrigin(w, r)
+	if err != nil {
+		if s.OnUpgradeError != nil {
+			s.OnUpgradeError(err)
+		}
+		return nil, err
+	}
+
 	tryParseURLParamsToHeaders(r)
 
+	principal, err := s.authenticate(w, r)
+	if err != nil {
+		if s.OnUpgradeError != nil {
+			s.OnUpgradeError(err)
+		}
+		return nil, err
+	}
+
+	if s.Compression != nil {
+		r = r.WithContext(contextWithCompression(r.Context(), s.Compression))
+	}
+
 	socket, err := s.upgrader(w, r)
 	if err != nil {
 		if s.OnUpgradeError != nil {
@@ -314,21 +544,40 @@ func (s *Server) Upgrade(
 		return nil, err
 	}
 
+	if s.MaxMessageSize > 0 {
+		if limiter, ok := socket.(SocketReadLimiter); ok {
+			limiter.SetReadLimit(s.MaxMessageSize)
+		}
+	}
+
 	if socketWrapper != nil {
 		socket = socketWrapper(socket)
 	}
 
-	c := newConn(socket, s.namespaces)
+	// the request's context is cancelled when the upgrade handler returns, keep its values only.
+	c := newConn(context.WithoutCancel(r.Context()), socket, s.namespaces)
 	if customIDGen != nil {
 		c.id = customIDGen(w, r)
 	} else {
 		c.id = s.IDGenerator(w, r)
 	}
 	c.serverConnID = genServerConnID(s, c)
+	c.outbox = s.takeOutbox(c.ID())
 
 	c.readTimeout = s.readTimeout
 	c.writeTimeout = s.writeTimeout
 	c.server = s
+	c.principal = principal
+	c.compression = s.Compression
+
+	if s.WriteQueueSize > 0 {
+		c.writeQueue = newWriteQueue(c, s.WriteQueueSize, s.WriteQueuePolicy, s.WriteQueueTimeout)
+		go c.writeQueue.run()
+	}
+
+	if s.Heartbeat != nil {
+		c.startHeartbeat(s.Heartbeat)
+	}
 
 	retriesHeaderValue := r.Header.Get(websocketReconectHeaderKey)
 	if retriesHeaderValue != "" {
@@ -342,8 +591,13 @@ func (s *Server) Upgrade(
 		}(c)
 	}
 
+	s.trackID(c, true)
 	s.connect <- c
 
+	if s.Observer != nil {
+		s.Observer.ConnectionOpened(c)
+	}
+
 	go c.startReader()
 
 	// Before `OnConnect` in order to be able
@@ -435,6 +689,13 @@ func (s *Server) Do(fn func(*Conn), async bool) {
 
 func publishMessages(c *Conn, msgs []Message) bool {
 	for _, msg := range msgs {
+		if msg.roomMembers != nil {
+			if _, ok := msg.roomMembers[c]; !ok {
+				// not a member of the message's room.
+				continue
+			}
+		}
+
 		if msg.from == c.ID() {
 			// if the message is not supposed to return back to any connection with this ID.
 			return true
@@ -501,7 +762,16 @@ func Exclude(connID string) fmt.Stringer { return stringerValue{connID} }
 // doesn't wait for a publish to complete to all clients before any
 // next broadcast call. To change that behavior set the `Server.SyncBroadcaster` to true
 // before server start.
+// Messages with a filled `Room` field are written to the room members only (see `GetRoomMembers`)
+// and they are recorded to the `RoomHistory`, if any.
+// Messages to a specific connection which is not connected to their namespace
+// are kept to the `MessageStore`, if any.
 func (s *Server) Broadcast(exceptSender fmt.Stringer, msgs ...Message) {
+	s.recordHistory(msgs)
+
+	if msgs = s.storeOffline(msgs); len(msgs) == 0 {
+		return
+	}
 
 	if exceptSender != nil {
 		var fromExplicit, from string
@@ -524,11 +794,32 @@ func (s *Server) Broadcast(exceptSender fmt.Stringer, msgs ...Message) {
 		}
 	}
 
+	if s.Tracer != nil {
+		for i := range msgs {
+			end := startSpan(s.Tracer, nil, SpanServerBroadcast, &msgs[i])
+			defer end(nil)
+		}
+	}
+
 	if s.usesStackExchange() {
-		s.StackExchange.Publish(msgs)
+		s.publish(msgs)
+		return
+	}
+
+	// room messages are sent to the room members only.
+	if msgs = s.resolveRoomMembers(msgs); len(msgs) == 0 {
 		return
 	}
 
+	if s.Observer != nil {
+		receivers := int(s.GetTotalConnections())
+		for _, msg := range msgs {
+			if msg.roomMembers == nil {
+				s.Observer.Broadcasted(msg, receivers)
+			}
+		}
+	}
+
 	if s.SyncBroadcaster {
 		s.broadcastMessages <- msgs
 		return
@@ -537,6 +828,35 @@ func (s *Server) Broadcast(exceptSender fmt.Stringer, msgs ...Message) {
 	s.broadcaster.broadcast(msgs)
 }
 
+// publish publishes the "msgs" through the `StackExchange`.
+func (s *Server) publish(msgs []Message) {
+	end := noopEndSpan
+	if s.Tracer != nil && len(msgs) > 0 {
+		for i := range msgs {
+			// carry the trace to the rest of the servers.
+			msgs[i].Header = injectHeader(s.Tracer, msgs[i].Context(), msgs[i].Header)
+		}
+
+		first := msgs[0]
+		end = startSpan(s.Tracer, nil, SpanStackExchangePublish, &first)
+	}
+
+	start := time.Now()
+	ok := s.StackExchange.Publish(msgs)
+	if ok {
+		end(nil)
+	} else {
+		end(ErrWrite)
+	}
+
+	if s.Observer != nil {
+		s.Observer.StackExchangePublished(msgs, time.Since(start), ok)
+		for _, msg := range msgs {
+			s.Observer.Broadcasted(msg, -1)
+		}
+	}
+}
+
 // Ask is like `Broadcast` but it blocks until a response
 // from a specific connection if "msg.To" is filled otherwise
 // from the first connection which will reply to this "msg".
@@ -545,15 +865,23 @@ func (s *Server) Broadcast(exceptSender fmt.Stringer, msgs ...Message) {
 // The second argument is the request message
 // which should be sent to a specific namespace:event
 // like the `Conn.Ask`.
-func (s *Server) Ask(ctx context.Context, msg Message) (Message, error) {
+func (s *Server) Ask(ctx context.Context, msg Message) (resp Message, err error) {
 	if ctx == nil {
 		ctx = context.TODO()
 	}
 
 	msg.wait = genWait(false)
 
+	if s.Tracer != nil {
+		end := startSpan(s.Tracer, ctx, SpanServerAsk, &msg)
+		defer func() { end(err) }()
+	}
+
 	if s.usesStackExchange() {
 		msg.wait = genWaitStackExchange(msg.wait)
+		if s.Tracer != nil {
+			msg.Header = injectHeader(s.Tracer, msg.ctx, msg.Header)
+		}
 		return s.StackExchange.Ask(ctx, msg, msg.wait)
 	}
 
@@ -0,0 +1,159 @@
+package neffos
+
+import (
+	"sync"
+)
+
+// roomRegistry is the server-side index of the joined rooms,
+// namespace -> room -> connections.
+// It's updated on room join and leave events of server-side connections
+// and it's used to broadcast room messages to its members only.
+type roomRegistry struct {
+	rooms map[string]map[string]map[*NSConn]struct{}
+	mu    sync.RWMutex
+}
+
+func newRoomRegistry() *roomRegistry {
+	return &roomRegistry{
+		rooms: make(map[string]map[string]map[*NSConn]struct{}),
+	}
+}
+
+func (r *roomRegistry) join(ns *NSConn, roomName string) {
+	r.mu.Lock()
+	rooms, ok := r.rooms[ns.namespace]
+	if !ok {
+		rooms = make(map[string]map[*NSConn]struct{})
+		r.rooms[ns.namespace] = rooms
+	}
+
+	members, ok := rooms[roomName]
+	if !ok {
+		members = make(map[*NSConn]struct{})
+		rooms[roomName] = members
+	}
+
+	members[ns] = struct{}{}
+	r.mu.Unlock()
+}
+
+func (r *roomRegistry) leave(ns *NSConn, roomName string) {
+	r.mu.Lock()
+	if rooms, ok := r.rooms[ns.namespace]; ok {
+		if members, ok := rooms[roomName]; ok {
+			delete(members, ns)
+			if len(members) == 0 {
+				delete(rooms, roomName)
+			}
+		}
+
+		if len(rooms) == 0 {
+			delete(r.rooms, ns.namespace)
+		}
+	}
+	r.mu.Unlock()
+}
+
+func (r *roomRegistry) members(namespace, roomName string) []*NSConn {
+	r.mu.RLock()
+	members := r.rooms[namespace][roomName]
+	list := make([]*NSConn, 0, len(members))
+	for ns := range members {
+		list = append(list, ns)
+	}
+	r.mu.RUnlock()
+
+	return list
+}
+
+func (r *roomRegistry) count(namespace, roomName string) int {
+	r.mu.RLock()
+	n := len(r.rooms[namespace][roomName])
+	r.mu.RUnlock()
+
+	return n
+}
+
+func (r *roomRegistry) names(namespace string) []string {
+	r.mu.RLock()
+	rooms := r.rooms[namespace]
+	names := make([]string, 0, len(rooms))
+	for roomName := range rooms {
+		names = append(names, roomName)
+	}
+	r.mu.RUnlock()
+
+	return names
+}
+
+// trackRoom updates the server's room registry, if this is a server-side connection.
+func (ns *NSConn) trackRoom(roomName string, joined bool) {
+	if ns.Conn == nil || ns.Conn.IsClient() {
+		return
+	}
+
+	if joined {
+		ns.Conn.server.rooms.join(ns, roomName)
+		if o := ns.Conn.observer(); o != nil {
+			o.RoomJoined(ns.Conn, ns.namespace, roomName)
+		}
+	} else {
+		ns.Conn.server.rooms.leave(ns, roomName)
+	}
+
+	ns.trackPresence(roomName, joined)
+}
+
+// GetRoomMembers returns the connections that are joined to a specific "room" of a "namespace"
+// on a specific time point. It's fast, the server keeps an index of the joined rooms.
+// Note that a custom `IDGenerator` may produce the same ID for different connections,
+// therefore the result is a slice and not a map of IDs.
+//
+// Local connections only, when a `StackExchange` is used the rest of the servers are not queried.
+func (s *Server) GetRoomMembers(namespace, room string) []*NSConn {
+	return s.rooms.members(namespace, room)
+}
+
+// GetRooms returns the names of the rooms, of a specific "namespace",
+// that have at least one local connection joined.
+func (s *Server) GetRooms(namespace string) []string {
+	return s.rooms.names(namespace)
+}
+
+// GetTotalRoomMembers returns the total amount of the local connections
+// that are joined to a specific "room" of a "namespace".
+func (s *Server) GetTotalRoomMembers(namespace, room string) int {
+	return s.rooms.count(namespace, room)
+}
+
+// resolveRoomMembers fills the local members of the room messages, messages with their `Room` field filled,
+// so they are written to the members only by the broadcaster, and returns the messages to broadcast.
+// Room messages without local members are omitted.
+func (s *Server) resolveRoomMembers(msgs []Message) []Message {
+	rest := msgs[:0:0]
+
+	for _, msg := range msgs {
+		if msg.Room == "" {
+			rest = append(rest, msg)
+			continue
+		}
+
+		members := s.rooms.members(msg.Namespace, msg.Room)
+		if s.Observer != nil {
+			s.Observer.Broadcasted(msg, len(members))
+		}
+
+		if len(members) == 0 {
+			continue
+		}
+
+		msg.roomMembers = make(map[*Conn]struct{}, len(members))
+		for _, ns := range members {
+			msg.roomMembers[ns.Conn] = struct{}{}
+		}
+
+		rest = append(rest, msg)
+	}
+
+	return rest
+}
@@ -3,8 +3,11 @@ package neffos_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -15,6 +18,7 @@ import (
 	gobwas "github.com/kataras/neffos/gobwas"
 	gorilla "github.com/kataras/neffos/gorilla"
 
+	gorillaws "github.com/gorilla/websocket"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -206,3 +210,628 @@ func TestServerAsk(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestServerMiddleware(t *testing.T) {
+	var (
+		namespace = "default"
+		forbidden = "forbidden"
+		errAuth   = errors.New("unauthorized")
+		mu        sync.Mutex
+		calls     []string
+		record    = func(name string) neffos.Middleware {
+			return func(next neffos.MessageHandlerFunc) neffos.MessageHandlerFunc {
+				return func(c *neffos.NSConn, msg neffos.Message) error {
+					if msg.Event == "event" {
+						mu.Lock()
+						calls = append(calls, name)
+						mu.Unlock()
+					}
+
+					return next(c, msg)
+				}
+			}
+		}
+		events = neffos.Namespaces{
+			namespace: neffos.Events{
+				"event": func(c *neffos.NSConn, msg neffos.Message) error {
+					mu.Lock()
+					calls = append(calls, "handler")
+					mu.Unlock()
+					return neffos.Reply(msg.Body)
+				},
+			},
+			forbidden: neffos.Events{},
+		}
+	)
+
+	neffos.RegisterKnownError(errAuth)
+
+	events[namespace].Use(record("events1"))
+	events[namespace].Use(record("events2"))
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.Use(record("server"), func(next neffos.MessageHandlerFunc) neffos.MessageHandlerFunc {
+			return func(c *neffos.NSConn, msg neffos.Message) error {
+				if msg.Namespace == forbidden && msg.Event == neffos.OnNamespaceConnect {
+					return errAuth
+				}
+
+				return next(c, msg)
+			}
+		})
+	})
+	defer teardownServer()
+
+	clientEvents := neffos.Namespaces{namespace: neffos.Events{}, forbidden: neffos.Events{}}
+	defer runTestClient("localhost:8080", clientEvents, func(dialer string, client *neffos.Client) {
+		if _, err := client.Connect(context.TODO(), forbidden); err != errAuth {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, errAuth, err)
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		calls = nil
+		mu.Unlock()
+
+		if _, err = c.Ask(context.TODO(), "event", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		got := strings.Join(calls, ",")
+		mu.Unlock()
+		if expected := "server,events1,events2,handler"; expected != got {
+			t.Fatalf("[%s] expected calls: %s but got: %s", dialer, expected, got)
+		}
+	})()
+}
+
+func TestServerRecoverPanic(t *testing.T) {
+	var (
+		namespace = "default"
+		errs      = make(chan error, 2)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"panic": func(c *neffos.NSConn, msg neffos.Message) error {
+					panic("event panic")
+				},
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	for _, closeOnPanic := range []bool{false, true} {
+		teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+			s.CloseOnPanic = closeOnPanic
+			s.OnError = func(c *neffos.Conn, err error) {
+				if _, ok := err.(*neffos.PanicError); ok {
+					errs <- err
+				}
+			}
+		})
+
+		runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = c.Ask(context.TODO(), "panic", nil); err != neffos.ErrPanic {
+				t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrPanic, err)
+			}
+
+			if err = <-errs; !errors.Is(err, neffos.ErrPanic) || !strings.Contains(err.Error(), "event panic") {
+				t.Fatalf("[%s] expected a panic error but got: %v", dialer, err)
+			}
+
+			if closeOnPanic {
+				select {
+				case <-client.NotifyClose:
+				case <-time.After(3 * time.Second):
+					t.Fatalf("[%s] expected the connection to be closed", dialer)
+				}
+				return
+			}
+
+			if _, err = c.Ask(context.TODO(), "echo", []byte("data")); err != nil {
+				t.Fatalf("[%s] expected the connection to be kept but got: %v", dialer, err)
+			}
+		})()
+
+		teardownServer()
+	}
+}
+
+func TestServerMessageHeader(t *testing.T) {
+	var (
+		namespace = "default"
+		errToken  = errors.New("invalid token")
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				neffos.OnNamespaceConnect: func(c *neffos.NSConn, msg neffos.Message) error {
+					if msg.Header["token"] != "secret" {
+						return errToken
+					}
+
+					return nil
+				},
+				"event": func(c *neffos.NSConn, msg neffos.Message) error {
+					c.Conn.Server().Broadcast(nil, neffos.Message{
+						Namespace: msg.Namespace,
+						Event:     "echo",
+						Header:    msg.Header,
+					})
+					return nil
+				},
+			},
+		}
+	)
+
+	neffos.RegisterKnownError(errToken)
+
+	teardownServer := runTestServer("localhost:8080", events)
+	defer teardownServer()
+
+	headers := make(chan map[string]string, 1)
+	clientEvents := neffos.Namespaces{
+		namespace: neffos.Events{
+			"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+				headers <- msg.Header
+				return nil
+			},
+		},
+	}
+
+	defer runTestClient("localhost:8080", clientEvents, func(dialer string, client *neffos.Client) {
+		if _, err := client.ConnectWithHeader(context.TODO(), namespace, map[string]string{"token": "invalid"}); err != errToken {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, errToken, err)
+		}
+
+		c, err := client.ConnectWithHeader(context.TODO(), namespace, map[string]string{"token": "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{"request-id": "42", "locale": "el-GR"}
+		c.EmitWithHeader("event", nil, expected)
+
+		select {
+		case got := <-headers:
+			if len(got) != len(expected) || got["request-id"] != expected["request-id"] || got["locale"] != expected["locale"] {
+				t.Fatalf("[%s] expected header: %v but got: %v", dialer, expected, got)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("[%s] the broadcasted message was not received", dialer)
+		}
+	})()
+}
+
+func TestServerWriteQueue(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					for i := 0; i < 10; i++ {
+						c.Emit("push", []byte(strconv.Itoa(i)))
+					}
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+		received = make(chan string, 20)
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.WriteQueueSize = 32
+	})
+	defer teardownServer()
+
+	clientEvents := neffos.Namespaces{
+		namespace: neffos.Events{
+			"push": func(c *neffos.NSConn, msg neffos.Message) error {
+				received <- string(msg.Body)
+				return nil
+			},
+		},
+	}
+
+	defer runTestClient("localhost:8080", clientEvents, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Ask(context.TODO(), "echo", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+
+		// queued messages are written in order.
+		for i := 0; i < 10; i++ {
+			select {
+			case got := <-received:
+				if expected := strconv.Itoa(i); expected != got {
+					t.Fatalf("[%s] expected message: %s but got: %s", dialer, expected, got)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("[%s] message %d was not received", dialer, i)
+			}
+		}
+	})()
+}
+
+func TestServerRateLimits(t *testing.T) {
+	var (
+		namespace = "default"
+		strict    = "strict"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(msg.Body)
+				},
+			},
+			strict: neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					return nil
+				},
+			},
+		}
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.RateLimits = &neffos.RateLimits{
+			Events: map[string]neffos.RateLimit{"chat": {Rate: 0.1, Burst: 2}},
+			Action: neffos.RateLimitReply,
+		}
+		s.NamespaceRateLimits = map[string]*neffos.RateLimits{
+			strict: {Namespace: neffos.RateLimit{Rate: 0.1, Burst: 2}, Action: neffos.RateLimitClose},
+		}
+	})
+	defer teardownServer()
+
+	defer runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if _, err = c.Ask(context.TODO(), "chat", []byte("data")); err != nil {
+				t.Fatalf("[%s] [%d] expected message to be allowed but got: %v", dialer, i, err)
+			}
+		}
+
+		if _, err = c.Ask(context.TODO(), "chat", []byte("data")); !errors.Is(err, neffos.ErrRateLimited) {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrRateLimited, err)
+		}
+
+		// the namespace connect message is the first message of the strict namespace.
+		s, err := client.Connect(context.TODO(), strict)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.Emit("chat", nil)
+		s.Emit("chat", nil)
+
+		for i := 0; !s.Conn.IsClosed(); i++ {
+			if i == 100 {
+				t.Fatalf("[%s] expected the connection to be closed", dialer)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})()
+}
+
+func TestServerLimits(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					return nil
+				},
+			},
+			"other": neffos.Events{},
+		}
+
+		errorsMu sync.Mutex
+		reported = make(map[error]int)
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.MaxMessageSize = 1024
+		s.MaxFieldLength = 32
+		s.MaxNamespaces = 1
+		s.MaxRooms = 1
+		s.OnError = func(c *neffos.Conn, err error) {
+			errorsMu.Lock()
+			reported[err]++
+			errorsMu.Unlock()
+		}
+	})
+	defer teardownServer()
+
+	waitClose := func(dialer string, c *neffos.NSConn) {
+		for i := 0; !c.Conn.IsClosed(); i++ {
+			if i == 100 {
+				t.Fatalf("[%s] expected the connection to be closed", dialer)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	defer runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = client.Connect(context.TODO(), "other"); err != neffos.ErrTooManyNamespaces {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrTooManyNamespaces, err)
+		}
+
+		waitClose(dialer, c)
+	})()
+
+	defer runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.JoinRoom(context.TODO(), "room1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.JoinRoom(context.TODO(), "room2"); err != neffos.ErrTooManyRooms {
+			t.Fatalf("[%s] expected error: %v but got: %v", dialer, neffos.ErrTooManyRooms, err)
+		}
+
+		waitClose(dialer, c)
+	})()
+
+	defer runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Emit(strings.Repeat("e", 33), nil)
+		waitClose(dialer, c)
+	})()
+
+	runTestClient("localhost:8080", events, func(dialer string, client *neffos.Client) {
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !c.Emit("chat", bytes.Repeat([]byte("b"), 512)) {
+			t.Fatalf("[%s] expected message to be sent", dialer)
+		}
+
+		c.Emit("chat", bytes.Repeat([]byte("b"), 2048))
+		waitClose(dialer, c)
+	})()
+
+	errorsMu.Lock()
+	defer errorsMu.Unlock()
+	for _, err := range []error{neffos.ErrMessageTooBig, neffos.ErrFieldTooLong} {
+		if expected, got := 2, reported[err]; expected != got {
+			t.Fatalf("expected %q to be reported %d times but got %d", err, expected, got)
+		}
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	var (
+		namespace = "default"
+		hint      = "ws://localhost:8080/moved"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"slow": func(c *neffos.NSConn, msg neffos.Message) error {
+					time.Sleep(200 * time.Millisecond)
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+		servers = make(map[string]*neffos.Server)
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.ReconnectHint = hint
+		if len(servers) == 0 {
+			servers["gobwas"] = s
+		} else {
+			servers["gorilla"] = s
+		}
+	})
+	defer teardownServer()
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		closeErr := make(chan error, 1)
+		client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer, events,
+			neffos.WithReconnect(neffos.ReconnectPolicy{MaxAttempts: 1, Delay: time.Millisecond}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.OnReconnecting = func(attempt int, err error) {
+			if attempt == 1 {
+				closeErr <- err
+			}
+		}
+
+		c, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, err := c.Ask(context.TODO(), "slow", []byte("data"))
+			reply <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err = servers[dialer].Shutdown(ctx); err != nil {
+			t.Fatalf("[%s] %v", dialer, err)
+		}
+		cancel()
+
+		if err = <-reply; err != nil {
+			t.Fatalf("[%s] expected the in-flight ask to be replied but got: %v", dialer, err)
+		}
+
+		err = <-closeErr
+		if ce, ok := err.(neffos.CloseError); !ok || ce.Code != neffos.CloseGoingAway || ce.Reason() != hint {
+			t.Fatalf("[%s] expected a going away close error with the reconnect hint but got: %v", dialer, err)
+		}
+
+		if _, err = neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer, events); err == nil {
+			t.Fatalf("[%s] expected the shut down server to reject new connections", dialer)
+		}
+		client.Close()
+	}
+}
+
+func TestServerAuthenticate(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"whoami": func(c *neffos.NSConn, msg neffos.Message) error {
+					p, ok := neffos.PrincipalOf[*neffos.BasicPrincipal](c.Conn)
+					if !ok {
+						return neffos.Reply([]byte("anonymous"))
+					}
+					return neffos.Reply([]byte(p.Subject()))
+				},
+			},
+			"private": neffos.Events{},
+			"admin":   neffos.Events{},
+		}
+		extract = neffos.FirstToken(neffos.BearerToken, neffos.QueryToken("token"))
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.Authenticate = func(r *http.Request) (neffos.Principal, error) {
+			switch extract(r) {
+			case "":
+				return nil, nil
+			case "admin-token":
+				return &neffos.BasicPrincipal{ID: "admin", Roles: []string{"admin"}}, nil
+			case "user-token":
+				return &neffos.BasicPrincipal{ID: "user"}, nil
+			default:
+				return nil, neffos.ErrUnauthorized
+			}
+		}
+		s.NamespaceAuthorizers = map[string]neffos.Authorizer{
+			"private": neffos.RequireAuthenticated,
+			"admin":   neffos.RequireRoles("admin"),
+		}
+	})
+	defer teardownServer()
+
+	var tests = []struct {
+		query    string
+		subject  string
+		allowed  map[string]bool
+		rejected bool
+	}{
+		{"", "anonymous", map[string]bool{"private": false, "admin": false}, false},
+		{"?X-Websocket-Header-Authorization=Bearer%20user-token", "user", map[string]bool{"private": true, "admin": false}, false},
+		{"?token=admin-token", "admin", map[string]bool{"private": true, "admin": true}, false},
+		{"?token=invalid", "", nil, true},
+	}
+
+	dialers := map[string]neffos.Dialer{"gobwas": gobwas.DefaultDialer, "gorilla": gorilla.DefaultDialer}
+	for dialer, dial := range dialers {
+		for _, tt := range tests {
+			client, err := neffos.Dial(context.TODO(), dial, "ws://localhost:8080/"+dialer+tt.query, events)
+			if tt.rejected {
+				if err == nil {
+					t.Fatalf("[%s] [%s] expected the connection to be rejected", dialer, tt.query)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("[%s] [%s] %v", dialer, tt.query, err)
+			}
+
+			c, err := client.Connect(context.TODO(), namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reply, err := c.Ask(context.TODO(), "whoami", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(reply.Body); tt.subject != got {
+				t.Fatalf("[%s] [%s] expected subject: %s but got: %s", dialer, tt.query, tt.subject, got)
+			}
+
+			for ns, allowed := range tt.allowed {
+				_, err = client.Connect(context.TODO(), ns)
+				if allowed && err != nil {
+					t.Fatalf("[%s] [%s] expected to connect to %s but got: %v", dialer, tt.query, ns, err)
+				}
+				if !allowed && err != neffos.ErrForbidden {
+					t.Fatalf("[%s] [%s] expected error: %v but got: %v", dialer, tt.query, neffos.ErrForbidden, err)
+				}
+			}
+			client.Close()
+		}
+	}
+}
+
+func TestServerOriginPolicy(t *testing.T) {
+	var (
+		namespace = "default"
+		events    = neffos.Namespaces{namespace: neffos.Events{}}
+		rejected  uint32
+	)
+
+	teardownServer := runTestServer("localhost:8080", events, func(s *neffos.Server) {
+		s.OriginPolicy = &neffos.OriginPolicy{AllowedOrigins: []string{"https://*.example.com"}}
+		s.OnUpgradeError = func(err error) {
+			if errors.Is(err, neffos.ErrOriginNotAllowed) {
+				atomic.AddUint32(&rejected, 1)
+			}
+		}
+	})
+	defer teardownServer()
+
+	dialWithOrigin := func(endpoint, origin string) error {
+		header := http.Header{"Origin": []string{origin}}
+		client, err := neffos.Dial(context.TODO(), gorilla.Dialer(gorillaws.DefaultDialer, header), "ws://localhost:8080/"+endpoint, events)
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+
+	for _, endpoint := range []string{"gobwas", "gorilla"} {
+		if err := dialWithOrigin(endpoint, "https://app.example.com"); err != nil {
+			t.Fatalf("[%s] expected the origin to be allowed but got: %v", endpoint, err)
+		}
+
+		if err := dialWithOrigin(endpoint, "https://evil.com"); err == nil {
+			t.Fatalf("[%s] expected the origin to be rejected", endpoint)
+		}
+	}
+
+	if expected, got := uint32(2), atomic.LoadUint32(&rejected); expected != got {
+		t.Fatalf("expected %d rejected upgrades but got %d", expected, got)
+	}
+}
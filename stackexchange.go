@@ -51,6 +51,38 @@ type StackExchangeInitializer interface {
 	Init(Namespaces) error
 }
 
+// StackExchangeFlusher is an optional interface for a `StackExchange`.
+// It contains a single `Flush` method which should block until
+// the published messages are sent, it's called by the `Server.Shutdown`.
+type StackExchangeFlusher interface {
+	// Flush should send any buffered messages, it should return when the "ctx" is done.
+	Flush(ctx context.Context) error
+}
+
+func stackExchangeFlush(ctx context.Context, s StackExchange) error {
+	if s != nil {
+		if flusher, ok := s.(StackExchangeFlusher); ok {
+			return flusher.Flush(ctx)
+		}
+	}
+
+	return nil
+}
+
+// findStackExchange returns the "s" or the first of the wrapped stack exchanges
+// which is a "T", i.e. a `PresenceStore`.
+func findStackExchange[T any](s StackExchange) (T, bool) {
+	if w, ok := s.(*stackExchangeWrapper); ok {
+		if v, ok := findStackExchange[T](w.current); ok {
+			return v, true
+		}
+		return findStackExchange[T](w.parent)
+	}
+
+	v, ok := s.(T)
+	return v, ok
+}
+
 func stackExchangeInit(s StackExchange, namespaces Namespaces) error {
 	if s != nil {
 		if sinit, ok := s.(StackExchangeInitializer); ok {
@@ -127,3 +159,12 @@ func (s *stackExchangeWrapper) Unsubscribe(c *Conn, namespace string) {
 	s.parent.Unsubscribe(c, namespace)
 	s.current.Unsubscribe(c, namespace)
 }
+
+func (s *stackExchangeWrapper) Flush(ctx context.Context) error {
+	err := stackExchangeFlush(ctx, s.parent)
+	if errCurrent := stackExchangeFlush(ctx, s.current); err == nil {
+		err = errCurrent
+	}
+
+	return err
+}
@@ -0,0 +1,294 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/kataras/neffos"
+)
+
+// ErrClosed is returned by the `StackExchange` methods after its `Close`.
+var ErrClosed = errors.New("stackexchange: closed")
+
+// StackExchange is an in-memory `neffos.StackExchange`.
+// It connects the `neffos.Server`s of the same process which use it,
+// i.e. to write deterministic tests of many servers without a redis or a nats server
+// or to run many servers inside a single binary.
+//
+// The messages are serialized and deserialized as they were sent over the network
+// and the `Publish` returns after they are written to all the subscribed connections.
+// It completes the `neffos.PresenceStore` and `neffos.HistoryStore` interfaces too,
+// so the presence and the room history are shared between its servers.
+type StackExchange struct {
+	// the local connections of all servers by their namespace and by their ID.
+	namespaces map[string]map[*neffos.Conn]struct{}
+	conns      map[string]map[*neffos.Conn]struct{}
+	// the waiting `Ask` calls by their token.
+	waiters map[string]chan neffos.Message
+	closed  bool
+	mu      sync.RWMutex
+
+	presence *neffos.MemoryPresence
+	history  *neffos.MemoryHistory
+}
+
+var (
+	_ neffos.StackExchange            = (*StackExchange)(nil)
+	_ neffos.StackExchangeInitializer = (*StackExchange)(nil)
+	_ neffos.PresenceStore            = (*StackExchange)(nil)
+	_ neffos.HistoryStore             = (*StackExchange)(nil)
+)
+
+// NewStackExchange returns a new in-memory StackExchange.
+// Pass the same instance to the `UseStackExchange` method of each server that should be connected.
+func NewStackExchange() *StackExchange {
+	return &StackExchange{
+		namespaces: make(map[string]map[*neffos.Conn]struct{}),
+		conns:      make(map[string]map[*neffos.Conn]struct{}),
+		waiters:    make(map[string]chan neffos.Message),
+		presence:   neffos.NewMemoryPresence(),
+		history:    neffos.NewMemoryHistory(),
+	}
+}
+
+// Init completes the `neffos.StackExchangeInitializer` interface,
+// it's called by the `Server.UseStackExchange` and it fails if the exchange is closed.
+func (exc *StackExchange) Init(neffos.Namespaces) error {
+	exc.mu.RLock()
+	defer exc.mu.RUnlock()
+
+	if exc.closed {
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// Close disconnects the servers of this exchange, the next messages are not published
+// and the waiting `Ask` calls are cancelled with `ErrClosed`.
+func (exc *StackExchange) Close() error {
+	exc.mu.Lock()
+	defer exc.mu.Unlock()
+
+	if exc.closed {
+		return nil
+	}
+
+	exc.closed = true
+	exc.namespaces = make(map[string]map[*neffos.Conn]struct{})
+	exc.conns = make(map[string]map[*neffos.Conn]struct{})
+	for token, ch := range exc.waiters {
+		close(ch)
+		delete(exc.waiters, token)
+	}
+
+	return nil
+}
+
+func add(index map[string]map[*neffos.Conn]struct{}, key string, c *neffos.Conn) {
+	conns, ok := index[key]
+	if !ok {
+		conns = make(map[*neffos.Conn]struct{})
+		index[key] = conns
+	}
+
+	conns[c] = struct{}{}
+}
+
+func remove(index map[string]map[*neffos.Conn]struct{}, key string, c *neffos.Conn) {
+	if conns, ok := index[key]; ok {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+// OnConnect subscribes the connection to itself for direct neffos messages.
+// It's called automatically after the neffos server's OnConnect (if any)
+// on incoming client connections.
+func (exc *StackExchange) OnConnect(c *neffos.Conn) error {
+	exc.mu.Lock()
+	defer exc.mu.Unlock()
+
+	if exc.closed {
+		return ErrClosed
+	}
+
+	add(exc.conns, c.ID(), c)
+	return nil
+}
+
+// OnDisconnect removes the connection's subscriptions.
+// It's called automatically when a connection goes offline,
+// manually by server or client or by network failure.
+func (exc *StackExchange) OnDisconnect(c *neffos.Conn) {
+	exc.mu.Lock()
+	remove(exc.conns, c.ID(), c)
+	for namespace := range exc.namespaces {
+		remove(exc.namespaces, namespace, c)
+	}
+	exc.mu.Unlock()
+}
+
+// Subscribe subscribes to a specific namespace,
+// it's called automatically on neffos namespace connected.
+func (exc *StackExchange) Subscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	if !exc.closed {
+		add(exc.namespaces, namespace, c)
+	}
+	exc.mu.Unlock()
+}
+
+// Unsubscribe unsubscribes from a specific namespace,
+// it's called automatically on neffos namespace disconnect.
+func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
+	exc.mu.Lock()
+	remove(exc.namespaces, namespace, c)
+	exc.mu.Unlock()
+}
+
+// Publish writes the messages to the subscribed connections of all servers,
+// it returns when they are written.
+// It's called automatically on neffos broadcasting.
+func (exc *StackExchange) Publish(msgs []neffos.Message) bool {
+	for _, msg := range msgs {
+		if !exc.publish(msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (exc *StackExchange) publish(msg neffos.Message) bool {
+	exc.mu.RLock()
+	if exc.closed {
+		exc.mu.RUnlock()
+		return false
+	}
+
+	// the connections to a specific connection ID
+	// and let the server-side do the checks of valid or invalid message
+	// to send on this particular client, as the rest of the stack exchanges do.
+	index := exc.namespaces[msg.Namespace]
+	if msg.To != "" {
+		index = exc.conns[msg.To]
+	}
+
+	conns := make([]*neffos.Conn, 0, len(index))
+	for c := range index {
+		conns = append(conns, c)
+	}
+	exc.mu.RUnlock()
+
+	var typ neffos.MessageType = neffos.TextMessage
+	if msg.SetBinary {
+		typ = neffos.BinaryMessage
+	}
+
+	b := msg.Serialize()
+	for _, c := range conns {
+		m := c.DeserializeMessage(typ, b)
+		m.FromStackExchange = true
+
+		c.Write(m)
+	}
+
+	return true
+}
+
+// Ask implements the server Ask feature in memory. It blocks until response.
+func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token string) (response neffos.Message, err error) {
+	// the first reply is kept, the rest are dropped.
+	ch := make(chan neffos.Message, 1)
+
+	exc.mu.Lock()
+	if exc.closed {
+		exc.mu.Unlock()
+		return response, ErrClosed
+	}
+	exc.waiters[token] = ch
+	exc.mu.Unlock()
+
+	defer func() {
+		exc.mu.Lock()
+		if exc.waiters[token] == ch {
+			delete(exc.waiters, token)
+		}
+		exc.mu.Unlock()
+	}()
+
+	if !exc.publish(msg) {
+		return response, neffos.ErrWrite
+	}
+
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case m, ok := <-ch:
+		if !ok {
+			return response, ErrClosed
+		}
+
+		response = m
+		err = response.Err
+	}
+
+	return
+}
+
+// NotifyAsk notifies and unblocks a "msg" subscriber, called on a server connection's read when expects a result.
+func (exc *StackExchange) NotifyAsk(msg neffos.Message, token string) error {
+	msg.ClearWait()
+
+	exc.mu.RLock()
+	ch, ok := exc.waiters[token]
+	if ok {
+		select {
+		case ch <- neffos.DeserializeMessage(neffos.TextMessage, msg.Serialize(), false, false):
+		default:
+		}
+	}
+	exc.mu.RUnlock()
+
+	return nil
+}
+
+// Join adds or replaces a presence member,
+// it completes the `neffos.PresenceStore` interface, see `neffos.Server.Presence`.
+func (exc *StackExchange) Join(member neffos.Member) error {
+	return exc.presence.Join(member)
+}
+
+// Leave removes a presence member,
+// it completes the `neffos.PresenceStore` interface.
+func (exc *StackExchange) Leave(member neffos.Member) error {
+	return exc.presence.Leave(member)
+}
+
+// Members returns the not expired presence members of a namespace's room, of all servers,
+// it completes the `neffos.PresenceStore` interface.
+func (exc *StackExchange) Members(namespace, room string) ([]neffos.Member, error) {
+	return exc.presence.Members(namespace, room)
+}
+
+// IsMember reports whether a connection is a not expired presence member of a namespace's room, of any server,
+// it completes the `neffos.PresenceStore` interface.
+func (exc *StackExchange) IsMember(connID, namespace, room string) (bool, error) {
+	return exc.presence.IsMember(connID, namespace, room)
+}
+
+// Append records a room message, it completes the `neffos.HistoryStore` interface,
+// see `neffos.Server.RoomHistory`.
+func (exc *StackExchange) Append(msg neffos.Message, limit int) (uint64, error) {
+	return exc.history.Append(msg, limit)
+}
+
+// Since returns the recorded messages of a namespace's room after the "since" sequence number, of all servers,
+// it completes the `neffos.HistoryStore` interface.
+func (exc *StackExchange) Since(namespace, room string, since uint64) ([]neffos.HistoryEntry, error) {
+	return exc.history.Since(namespace, room, since)
+}
@@ -0,0 +1,113 @@
+package memory_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+	"github.com/kataras/neffos/stackexchange/memory"
+)
+
+func TestStackExchange(t *testing.T) {
+	var (
+		namespace = "default"
+		exc       = memory.NewStackExchange()
+		received  = make(chan string, 10)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- c.Conn.ID() + ":" + string(msg.Body)
+					return nil
+				},
+				"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply([]byte(c.Conn.ID()))
+				},
+			},
+		}
+	)
+	defer exc.Close()
+
+	connect := func(id string) (*neffos.Server, *neffos.Client) {
+		server := neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		server.IDGenerator = func(http.ResponseWriter, *http.Request) string { return id }
+		if err := server.UseStackExchange(exc); err != nil {
+			t.Fatal(err)
+		}
+
+		httpServer := httptest.NewServer(server)
+		t.Cleanup(func() {
+			server.Close()
+			httpServer.Close()
+		})
+
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1), events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		if _, err = client.Connect(context.TODO(), namespace); err != nil {
+			t.Fatal(err)
+		}
+
+		return server, client
+	}
+
+	server1, _ := connect("conn1")
+	server2, _ := connect("conn2")
+
+	expect := func(values ...string) {
+		t.Helper()
+
+		got := make(map[string]bool)
+		for range values {
+			select {
+			case v := <-received:
+				got[v] = true
+			case <-time.After(time.Second):
+				t.Fatalf("expected the messages %v but got %v", values, got)
+			}
+		}
+
+		for _, v := range values {
+			if !got[v] {
+				t.Fatalf("expected the message %q but got %v", v, got)
+			}
+		}
+	}
+
+	// the messages are written before the Broadcast returns.
+	server1.Broadcast(nil, neffos.Message{Namespace: namespace, Event: "chat", Body: []byte("all")})
+	expect("conn1:all", "conn2:all")
+
+	server1.Broadcast(nil, neffos.Message{Namespace: namespace, To: "conn2", Event: "chat", Body: []byte("direct")})
+	expect("conn2:direct")
+
+	response, err := server1.Ask(context.TODO(), neffos.Message{Namespace: namespace, To: "conn2", Event: "ask"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "conn2", string(response.Body); expected != got {
+		t.Fatalf("expected the response of %q but got %q", expected, got)
+	}
+
+	response, err = server2.Ask(context.TODO(), neffos.Message{Namespace: namespace, To: "conn1", Event: "ask"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "conn1", string(response.Body); expected != got {
+		t.Fatalf("expected the response of %q but got %q", expected, got)
+	}
+
+	exc.Close()
+	if err = neffos.New(gorilla.DefaultUpgrader, events).UseStackExchange(exc); err != memory.ErrClosed {
+		t.Fatalf("expected the closed error but got: %v", err)
+	}
+}
@@ -2,8 +2,12 @@ package nats
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/kataras/neffos"
 
@@ -28,9 +32,16 @@ type StackExchange struct {
 	subscribe     chan subscribeAction
 	unsubscribe   chan unsubscribeAction
 	delSubscriber chan closeAction
+
+	// the JetStream key-value bucket of the presence members, see `presence`.
+	presenceKV    nats.KeyValue
+	presenceMutex sync.Mutex
 }
 
-var _ neffos.StackExchange = (*StackExchange)(nil)
+var (
+	_ neffos.StackExchange = (*StackExchange)(nil)
+	_ neffos.PresenceStore = (*StackExchange)(nil)
+)
 
 type (
 	subscriber struct {
@@ -377,3 +388,148 @@ func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
 func (exc *StackExchange) OnDisconnect(c *neffos.Conn) {
 	exc.delSubscriber <- closeAction{conn: c}
 }
+
+// Flush blocks until the published messages are processed by the nats server,
+// it completes the `neffos.StackExchangeFlusher` interface.
+func (exc *StackExchange) Flush(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return exc.publisher.Flush()
+	}
+
+	return exc.publisher.FlushWithContext(ctx)
+}
+
+// presence returns the JetStream key-value bucket of the presence members,
+// it's created on the first call. The nats server should have the JetStream enabled.
+func (exc *StackExchange) presence() (nats.KeyValue, error) {
+	exc.presenceMutex.Lock()
+	defer exc.presenceMutex.Unlock()
+
+	if exc.presenceKV != nil {
+		return exc.presenceKV, nil
+	}
+
+	js, err := exc.publisher.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := strings.ReplaceAll(exc.SubjectPrefix, ".", "_") + "_presence"
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	exc.presenceKV = kv
+	return kv, nil
+}
+
+// encodeToken encodes a namespace, room or connection ID to a valid key token.
+func encodeToken(s string) string {
+	if s == "" {
+		// not a valid base64 value.
+		return "_"
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// getPresenceKey returns the key of a presence member, the connection ID is followed by the `neffos.Member.Key`
+// so the members of the connections which share the same ID are kept apart.
+func getPresenceKey(member neffos.Member) string {
+	key := member.Key
+	if key == "" {
+		key = member.ConnID
+	}
+
+	return getPresencePrefix(member.Namespace, member.Room) + "." + encodeToken(member.ConnID) + "." + encodeToken(key)
+}
+
+func getPresencePrefix(namespace, room string) string {
+	return encodeToken(namespace) + "." + encodeToken(room)
+}
+
+// Join adds or replaces a presence member,
+// it completes the `neffos.PresenceStore` interface, see `neffos.Server.Presence`.
+func (exc *StackExchange) Join(member neffos.Member) error {
+	kv, err := exc.presence()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+
+	_, err = kv.Put(getPresenceKey(member), b)
+	return err
+}
+
+// Leave removes a presence member,
+// it completes the `neffos.PresenceStore` interface.
+func (exc *StackExchange) Leave(member neffos.Member) error {
+	kv, err := exc.presence()
+	if err != nil {
+		return err
+	}
+
+	return kv.Delete(getPresenceKey(member))
+}
+
+// Members returns the not expired presence members of a namespace's room, of all servers,
+// it completes the `neffos.PresenceStore` interface. The expired ones are removed.
+func (exc *StackExchange) Members(namespace, room string) ([]neffos.Member, error) {
+	return exc.watchMembers(getPresencePrefix(namespace, room) + ".*.*")
+}
+
+// watchMembers returns the not expired presence members of the keys of the "filter", the expired ones are removed.
+func (exc *StackExchange) watchMembers(filter string) ([]neffos.Member, error) {
+	kv, err := exc.presence()
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := kv.Watch(filter, nats.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+
+	now := time.Now()
+	var members []neffos.Member
+	// the current values are followed by a nil entry.
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+
+		var member neffos.Member
+		if json.Unmarshal(entry.Value(), &member) != nil {
+			continue
+		}
+
+		if member.Expired(now) {
+			kv.Delete(entry.Key())
+			continue
+		}
+
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// IsMember reports whether a connection is a not expired presence member of a namespace's room, of any server,
+// it completes the `neffos.PresenceStore` interface. The expired ones are removed.
+func (exc *StackExchange) IsMember(connID, namespace, room string) (bool, error) {
+	members, err := exc.watchMembers(getPresencePrefix(namespace, room) + "." + encodeToken(connID) + ".*")
+	if err != nil {
+		return false, err
+	}
+
+	return len(members) > 0, nil
+}
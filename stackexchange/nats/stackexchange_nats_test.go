@@ -0,0 +1,265 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+
+	"github.com/nats-io/nats.go"
+)
+
+// fakeServer is a nats server of the core publish-subscribe protocol only,
+// it routes the messages of the exact subjects, without wildcards.
+type fakeServer struct {
+	listener net.Listener
+	// subject -> client -> subscription IDs.
+	subs map[string]map[*fakeClient][]string
+	mu   sync.Mutex
+}
+
+type fakeClient struct {
+	conn   net.Conn
+	noEcho bool
+	mu     sync.Mutex
+}
+
+func (c *fakeClient) write(format string, args ...interface{}) {
+	c.mu.Lock()
+	fmt.Fprintf(c.conn, format, args...)
+	c.mu.Unlock()
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{listener: listener, subs: make(map[string]map[*fakeClient][]string)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(&fakeClient{conn: conn})
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeServer) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *fakeServer) serve(c *fakeClient) {
+	defer c.conn.Close()
+	defer s.unsubscribe(c, "")
+
+	c.write("INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "CONNECT":
+			var opts struct {
+				Echo bool `json:"echo"`
+			}
+			json.Unmarshal([]byte(strings.TrimSpace(line[len(fields[0]):])), &opts)
+			c.noEcho = !opts.Echo
+		case "PING":
+			c.write("PONG\r\n")
+		case "SUB":
+			subject, sid := fields[1], fields[len(fields)-1]
+			s.mu.Lock()
+			if s.subs[subject] == nil {
+				s.subs[subject] = make(map[*fakeClient][]string)
+			}
+			s.subs[subject][c] = append(s.subs[subject][c], sid)
+			s.mu.Unlock()
+		case "UNSUB":
+			s.unsubscribe(c, fields[1])
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(r, payload); err != nil {
+				return
+			}
+
+			s.publish(c, fields[1], payload[:size])
+		}
+	}
+}
+
+// unsubscribe removes the subscription "sid" of the client, or all of them if it's empty.
+func (s *fakeServer) unsubscribe(c *fakeClient, sid string) {
+	s.mu.Lock()
+	for subject, clients := range s.subs {
+		sids := clients[c][:0]
+		for _, id := range clients[c] {
+			if sid != "" && id != sid {
+				sids = append(sids, id)
+			}
+		}
+
+		if len(sids) == 0 {
+			delete(clients, c)
+		} else {
+			clients[c] = sids
+		}
+
+		if len(clients) == 0 {
+			delete(s.subs, subject)
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *fakeServer) publish(from *fakeClient, subject string, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c, sids := range s.subs[subject] {
+		if c == from && c.noEcho {
+			continue
+		}
+
+		for _, sid := range sids {
+			c.write("MSG %s %s %d\r\n%s\r\n", subject, sid, len(payload), payload)
+		}
+	}
+}
+
+// expectSubscribed waits for the "subject" to be subscribed.
+func (s *fakeServer) expectSubscribed(t *testing.T, subject string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s.mu.Lock()
+		ok := len(s.subs[subject]) > 0
+		s.mu.Unlock()
+
+		if ok {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the subject %q to be subscribed", subject)
+		}
+	}
+}
+
+func TestAsk(t *testing.T) {
+	var (
+		namespace = "default"
+		natsSrv   = newFakeServer(t)
+		server    = neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(append([]byte("reply:"), msg.Body...))
+				},
+			},
+		}
+	)
+
+	exc, err := NewStackExchange(natsSrv.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exc.publisher.Close()
+
+	if err = server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer func() {
+		server.Close()
+		httpServer.Close()
+	}()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+	natsSrv.expectSubscribed(t, exc.getSubject(namespace, "", ""))
+
+	// the ask is published to the connection and its reply is published back to the waiter.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := server.Ask(ctx, neffos.Message{Namespace: namespace, Event: "ask", Body: []byte("ping")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "reply:ping", string(resp.Body); expected != got {
+		t.Fatalf("expected the reply %q but got %q", expected, got)
+	}
+
+	// the ask of a server of a previous version, which adds the marker to the first digit of the wait token,
+	// is replied back to its token too.
+	legacy := "R" + strconv.FormatInt(time.Now().UnixNano(), 10)[1:]
+
+	nc, err := nats.Connect(natsSrv.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	ask := neffos.Message{Namespace: namespace, Event: "ask", Body: []byte("legacy")}
+	if err = nc.Publish(exc.getSubject(namespace, "", ""), append([]byte(legacy), ask.Serialize()...)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("expected the reply to the previous version's ask but got: %v", err)
+	}
+
+	msg := neffos.DeserializeMessage(neffos.TextMessage, m.Data, false, false)
+	if expected, got := "reply:legacy", string(msg.Body); expected != got {
+		t.Fatalf("expected the reply %q but got %q", expected, got)
+	}
+}
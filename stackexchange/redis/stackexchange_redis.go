@@ -1,8 +1,13 @@
 package redis
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kataras/neffos"
@@ -30,6 +35,20 @@ type Config struct {
 	// MaxActive defines the size connection pool.
 	// Defaults to 10.
 	MaxActive int
+
+	// Multiplex, if true, makes the stack exchange to use a single redis subscription connection
+	// for all the connections of the neffos server, instead of one per connection.
+	// It subscribes to each namespace and connection ID channel once
+	// and dispatches the incoming messages to a queue of each local connection, see `MultiplexQueueSize`,
+	// so a slow connection never delays the subscription connection and the rest.
+	// The `Ask` calls share that subscription connection too.
+	// Defaults to false.
+	Multiplex bool
+	// MultiplexQueueSize is the size of the incoming messages queue of each connection
+	// of the `Multiplex` mode. A connection which its queue is full is a slow consumer,
+	// the message is dropped and the connection is closed with the `neffos.CloseTryAgainLater` code.
+	// Defaults to 256.
+	MultiplexQueueSize int
 }
 
 // StackExchange is a `neffos.StackExchange` for redis.
@@ -45,6 +64,16 @@ type StackExchange struct {
 	subscribe     chan subscribeAction
 	unsubscribe   chan unsubscribeAction
 	delSubscriber chan closeAction
+
+	// the shared subscription connection of the `Config.Multiplex` mode, nil otherwise.
+	pubSub    radix.PubSubConn
+	msgCh     chan radix.PubSubMessage
+	queueSize int
+	// the local subscribers and the waiting `Ask` calls by their channel,
+	// written by the run goroutine and the `Ask` and read by the dispatch one.
+	channels map[string]map[*subscriber]struct{}
+	waiters  map[string]chan neffos.Message
+	mu       sync.RWMutex
 }
 
 type (
@@ -52,6 +81,11 @@ type (
 		conn   *neffos.Conn
 		pubSub radix.PubSubConn
 		msgCh  chan<- radix.PubSubMessage
+		// the subscribed channels and the incoming messages queue of the `Config.Multiplex` mode,
+		// the queue is closed by the run goroutine after the subscriber is removed from its channels.
+		channels map[string]struct{}
+		queue    chan []byte
+		slow     uint32
 	}
 
 	subscribeAction struct {
@@ -69,7 +103,11 @@ type (
 	}
 )
 
-var _ neffos.StackExchange = (*StackExchange)(nil)
+var (
+	_ neffos.StackExchange = (*StackExchange)(nil)
+	_ neffos.PresenceStore = (*StackExchange)(nil)
+	_ neffos.HistoryStore  = (*StackExchange)(nil)
+)
 
 // NewStackExchange returns a new redis StackExchange.
 // The "channel" input argument is the channel prefix for publish and subscribe.
@@ -90,6 +128,10 @@ func NewStackExchange(cfg Config, channel string) (*StackExchange, error) {
 		cfg.MaxActive = 10
 	}
 
+	if cfg.MultiplexQueueSize <= 0 {
+		cfg.MultiplexQueueSize = 256
+	}
+
 	var dialOptions []radix.DialOpt
 
 	if cfg.Password != "" {
@@ -143,20 +185,42 @@ func NewStackExchange(cfg Config, channel string) (*StackExchange, error) {
 		unsubscribe:   make(chan unsubscribeAction),
 	}
 
+	if cfg.Multiplex {
+		exc.multiplex(radix.PersistentPubSub("", "", connFunc), cfg.MultiplexQueueSize)
+	}
+
 	go exc.run()
 
 	return exc, nil
 }
 
+// multiplex makes the "pubSub" the shared subscription connection of the `Config.Multiplex` mode.
+func (exc *StackExchange) multiplex(pubSub radix.PubSubConn, queueSize int) {
+	exc.pubSub = pubSub
+	exc.msgCh = make(chan radix.PubSubMessage, 128)
+	exc.queueSize = queueSize
+	exc.channels = make(map[string]map[*subscriber]struct{})
+	exc.waiters = make(map[string]chan neffos.Message)
+	go exc.dispatch()
+}
+
 func (exc *StackExchange) run() {
 	for {
 		select {
 		case s := <-exc.addSubscriber:
 			exc.subscribers[s.conn] = s
+			if exc.pubSub != nil {
+				exc.subscribeChannel(s, exc.getChannel("", "", s.conn.ID()))
+			}
 			// neffos.Debugf("[%s] added to potential subscribers", s.conn.ID())
 		case m := <-exc.subscribe:
 			if sub, ok := exc.subscribers[m.conn]; ok {
 				channel := exc.getChannel(m.namespace, "", "")
+				if exc.pubSub != nil {
+					exc.subscribeChannel(sub, channel)
+					continue
+				}
+
 				sub.pubSub.PSubscribe(sub.msgCh, channel)
 				// neffos.Debugf("[%s] subscribed to [%s] for namespace [%s]", m.conn.ID(), channel, m.namespace)
 				//	} else {
@@ -165,20 +229,119 @@ func (exc *StackExchange) run() {
 		case m := <-exc.unsubscribe:
 			if sub, ok := exc.subscribers[m.conn]; ok {
 				channel := exc.getChannel(m.namespace, "", "")
+				if exc.pubSub != nil {
+					exc.unsubscribeChannel(sub, channel)
+					continue
+				}
 				// neffos.Debugf("[%s] unsubscribed from [%s]", channel)
 				sub.pubSub.PUnsubscribe(sub.msgCh, channel)
 			}
 		case m := <-exc.delSubscriber:
 			if sub, ok := exc.subscribers[m.conn]; ok {
 				// neffos.Debugf("[%s] disconnected", m.conn.ID())
-				sub.pubSub.Close()
-				close(sub.msgCh)
+				if exc.pubSub != nil {
+					for channel := range sub.channels {
+						exc.unsubscribeChannel(sub, channel)
+					}
+					// it's not reachable by the dispatch goroutine anymore.
+					close(sub.queue)
+				} else {
+					sub.pubSub.Close()
+					close(sub.msgCh)
+				}
 				delete(exc.subscribers, m.conn)
 			}
 		}
 	}
 }
 
+// subscribeChannel adds the subscriber's connection to the local connections of a "channel"
+// and subscribes to it if it's the first one, used on the `Config.Multiplex` mode.
+func (exc *StackExchange) subscribeChannel(sub *subscriber, channel string) {
+	if _, ok := sub.channels[channel]; ok {
+		return
+	}
+	sub.channels[channel] = struct{}{}
+
+	exc.mu.Lock()
+	subs, ok := exc.channels[channel]
+	if !ok {
+		subs = make(map[*subscriber]struct{})
+		exc.channels[channel] = subs
+	}
+	subs[sub] = struct{}{}
+	exc.mu.Unlock()
+
+	if !ok {
+		// outside of the lock, the dispatch goroutine may wait for it
+		// while the subscription connection waits for the dispatch goroutine.
+		exc.pubSub.Subscribe(exc.msgCh, channel)
+	}
+}
+
+// unsubscribeChannel removes the subscriber's connection from the local connections of a "channel"
+// and unsubscribes from it if it was the last one, used on the `Config.Multiplex` mode.
+func (exc *StackExchange) unsubscribeChannel(sub *subscriber, channel string) {
+	if _, ok := sub.channels[channel]; !ok {
+		return
+	}
+	delete(sub.channels, channel)
+
+	exc.mu.Lock()
+	subs := exc.channels[channel]
+	delete(subs, sub)
+	last := len(subs) == 0
+	if last {
+		delete(exc.channels, channel)
+	}
+	exc.mu.Unlock()
+
+	if last {
+		exc.pubSub.Unsubscribe(exc.msgCh, channel)
+	}
+}
+
+// dispatch hands the messages of the shared subscription connection
+// to the queues of the local subscribers of their channel or to their waiting `Ask` call,
+// used on the `Config.Multiplex` mode. It never blocks, so the subscription connection is never blocked:
+// the message is dropped for a subscriber which its queue is full and its connection is closed.
+func (exc *StackExchange) dispatch() {
+	for redisMsg := range exc.msgCh {
+		exc.mu.RLock()
+		if waiter, ok := exc.waiters[redisMsg.Channel]; ok {
+			select {
+			case waiter <- neffos.DeserializeMessage(neffos.TextMessage, redisMsg.Message, false, false):
+			default:
+				// keep the first reply.
+			}
+			exc.mu.RUnlock()
+			continue
+		}
+
+		// under the lock, the run goroutine closes the queue of a subscriber after its removal.
+		for sub := range exc.channels[redisMsg.Channel] {
+			select {
+			case sub.queue <- redisMsg.Message:
+			default:
+				if atomic.CompareAndSwapUint32(&sub.slow, 0, 1) {
+					go sub.conn.CloseWithCode(neffos.CloseTryAgainLater, neffos.ErrWriteQueueFull.Error())
+				}
+			}
+		}
+		exc.mu.RUnlock()
+	}
+}
+
+// write writes the queued messages of a subscriber to its connection, used on the `Config.Multiplex` mode.
+func (sub *subscriber) write() {
+	for b := range sub.queue {
+		msg := sub.conn.DeserializeMessage(neffos.TextMessage, b)
+		msg.FromStackExchange = true
+
+		sub.conn.Write(msg)
+	}
+}
+
 func (exc *StackExchange) getChannel(namespace, room, connID string) string {
 	if connID != "" {
 		// publish direct and let the server-side do the checks
@@ -199,6 +362,18 @@ func (exc *StackExchange) getChannel(namespace, room, connID string) string {
 // It's called automatically after the neffos server's OnConnect (if any)
 // on incoming client connections.
 func (exc *StackExchange) OnConnect(c *neffos.Conn) error {
+	if exc.pubSub != nil {
+		s := &subscriber{
+			conn:     c,
+			channels: make(map[string]struct{}),
+			queue:    make(chan []byte, exc.queueSize),
+		}
+		go s.write()
+		exc.addSubscriber <- s
+
+		return nil
+	}
+
 	redisMsgCh := make(chan radix.PubSubMessage)
 	go func() {
 		for redisMsg := range redisMsgCh {
@@ -252,6 +427,10 @@ func (exc *StackExchange) publishCommand(channel string, b []byte) error {
 
 // Ask implements the server Ask feature for redis. It blocks until response.
 func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token string) (response neffos.Message, err error) {
+	if exc.pubSub != nil {
+		return exc.askMultiplexed(ctx, msg, token)
+	}
+
 	sub := radix.PersistentPubSub("", "", exc.connFunc)
 	msgCh := make(chan radix.PubSubMessage)
 	err = sub.Subscribe(msgCh, token)
@@ -275,6 +454,41 @@ func (exc *StackExchange) Ask(ctx context.Context, msg neffos.Message, token str
 	return
 }
 
+// askMultiplexed is the `Ask` of the `Config.Multiplex` mode,
+// it waits for the reply on the shared subscription connection.
+func (exc *StackExchange) askMultiplexed(ctx context.Context, msg neffos.Message, token string) (response neffos.Message, err error) {
+	ch := make(chan neffos.Message, 1)
+
+	exc.mu.Lock()
+	exc.waiters[token] = ch
+	exc.mu.Unlock()
+
+	defer func() {
+		exc.mu.Lock()
+		delete(exc.waiters, token)
+		exc.mu.Unlock()
+
+		exc.pubSub.Unsubscribe(exc.msgCh, token)
+	}()
+
+	if err = exc.pubSub.Subscribe(exc.msgCh, token); err != nil {
+		return
+	}
+
+	if !exc.publish(msg) {
+		return response, neffos.ErrWrite
+	}
+
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case response = <-ch:
+		err = response.Err
+	}
+
+	return
+}
+
 // NotifyAsk notifies and unblocks a "msg" subscriber, called on a server connection's read when expects a result.
 func (exc *StackExchange) NotifyAsk(msg neffos.Message, token string) error {
 	msg.ClearWait()
@@ -308,3 +522,188 @@ func (exc *StackExchange) Unsubscribe(c *neffos.Conn, namespace string) {
 func (exc *StackExchange) OnDisconnect(c *neffos.Conn) {
 	exc.delSubscriber <- closeAction{conn: c}
 }
+
+// getPresenceKey returns the key of the presence members hash of a namespace's room,
+// the key of their expiration sorted set is the same with the ".expire" suffix.
+// Both are keyed by the `neffos.Member.Key`.
+func (exc *StackExchange) getPresenceKey(namespace, room string) string {
+	return exc.channel + ".presence." + namespace + "." + room
+}
+
+// getPresenceConnKey returns the key of the expiration sorted set of the presence members
+// of a connection ID, so the members of the connections which share the same ID are looked up together.
+func (exc *StackExchange) getPresenceConnKey(namespace, room, connID string) string {
+	return exc.getPresenceKey(namespace, room) + ".conn." + connID
+}
+
+// getMemberKey returns the `neffos.Member.Key` or its connection ID if it's empty.
+func getMemberKey(member neffos.Member) string {
+	if member.Key == "" {
+		return member.ConnID
+	}
+
+	return member.Key
+}
+
+// Join adds or replaces a presence member,
+// it completes the `neffos.PresenceStore` interface, see `neffos.Server.Presence`.
+func (exc *StackExchange) Join(member neffos.Member) error {
+	b, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+
+	score := "+inf"
+	if !member.ExpiresAt.IsZero() {
+		score = strconv.FormatInt(member.ExpiresAt.UnixMilli(), 10)
+	}
+
+	key, memberKey := exc.getPresenceKey(member.Namespace, member.Room), getMemberKey(member)
+	return exc.pool.Do(radix.Pipeline(
+		radix.FlatCmd(nil, "HSET", key, memberKey, b),
+		radix.Cmd(nil, "ZADD", key+".expire", score, memberKey),
+		radix.Cmd(nil, "ZADD", exc.getPresenceConnKey(member.Namespace, member.Room, member.ConnID), score, memberKey),
+	))
+}
+
+// Leave removes a presence member,
+// it completes the `neffos.PresenceStore` interface.
+func (exc *StackExchange) Leave(member neffos.Member) error {
+	key, memberKey := exc.getPresenceKey(member.Namespace, member.Room), getMemberKey(member)
+	return exc.pool.Do(radix.Pipeline(
+		radix.Cmd(nil, "HDEL", key, memberKey),
+		radix.Cmd(nil, "ZREM", key+".expire", memberKey),
+		radix.Cmd(nil, "ZREM", exc.getPresenceConnKey(member.Namespace, member.Room, member.ConnID), memberKey),
+	))
+}
+
+// membersScript removes the expired presence members of the KEYS[1] hash and the KEYS[2] sorted set,
+// with a score less or equal to the ARGV[1], and returns the rest.
+// The expired member is removed from the sorted set of its connection ID too, its key is the KEYS[1] with
+// the ".conn." suffix and the connection ID, see `getPresenceConnKey`.
+// It runs atomically so a member which is refreshed meanwhile is never removed.
+var membersScript = radix.NewEvalScript(2, `
+local expired = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1])
+for _, id in ipairs(expired) do
+	local value = redis.call("HGET", KEYS[1], id)
+	if value then
+		local ok, member = pcall(cjson.decode, value)
+		if ok and type(member) == "table" and type(member.connID) == "string" then
+			redis.call("ZREM", KEYS[1] .. ".conn." .. member.connID, id)
+		end
+	end
+	redis.call("HDEL", KEYS[1], id)
+	redis.call("ZREM", KEYS[2], id)
+end
+return redis.call("HVALS", KEYS[1])
+`)
+
+// Members returns the not expired presence members of a namespace's room, of all servers,
+// it completes the `neffos.PresenceStore` interface. The expired ones are removed.
+func (exc *StackExchange) Members(namespace, room string) ([]neffos.Member, error) {
+	key := exc.getPresenceKey(namespace, room)
+	now := time.Now()
+
+	var values []string
+	err := exc.pool.Do(membersScript.Cmd(&values, key, key+".expire", strconv.FormatInt(now.UnixMilli(), 10)))
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]neffos.Member, 0, len(values))
+	for _, value := range values {
+		var member neffos.Member
+		if json.Unmarshal([]byte(value), &member) != nil || member.Expired(now) {
+			continue
+		}
+
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// IsMember reports whether a connection is a not expired presence member of a namespace's room, of any server,
+// it completes the `neffos.PresenceStore` interface.
+// It's a single count of the not expired scores of the connection ID's members,
+// the expired members are removed by the `Members`.
+func (exc *StackExchange) IsMember(connID, namespace, room string) (bool, error) {
+	var count int
+	min := "(" + strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := exc.pool.Do(radix.Cmd(&count, "ZCOUNT", exc.getPresenceConnKey(namespace, room, connID), min, "+inf")); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// getHistoryKey returns the key of the room history list of a namespace's room,
+// the key of its sequence number counter is the same with the ".seq" suffix.
+func (exc *StackExchange) getHistoryKey(namespace, room string) string {
+	return exc.channel + ".history." + namespace + "." + room
+}
+
+// appendScript increments the KEYS[2] sequence number, pushes the "<seq>" + ARGV[1] item
+// to the KEYS[1] list, keeps its last ARGV[2] items and returns the sequence number.
+// It runs atomically so the items of a list are always in the order of their sequence numbers.
+var appendScript = radix.NewEvalScript(2, `
+local seq = redis.call("INCR", KEYS[2])
+redis.call("RPUSH", KEYS[1], seq .. ARGV[1])
+redis.call("LTRIM", KEYS[1], -tonumber(ARGV[2]), -1)
+return seq
+`)
+
+// Append records a room message to a redis list, trimmed to the last "limit" messages,
+// it completes the `neffos.HistoryStore` interface, see `neffos.Server.RoomHistory`.
+// Each list item is the "<seq>;<unix milliseconds>;<binary(0-1)>;<serialized message>".
+func (exc *StackExchange) Append(msg neffos.Message, limit int) (uint64, error) {
+	key := exc.getHistoryKey(msg.Namespace, msg.Room)
+
+	binary := "0"
+	if msg.SetBinary {
+		binary = "1"
+	}
+
+	item := ";" + strconv.FormatInt(time.Now().UnixMilli(), 10) + ";" + binary + ";"
+
+	var seq uint64
+	err := exc.pool.Do(appendScript.FlatCmd(&seq, []string{key, key + ".seq"}, append([]byte(item), msg.Serialize()...), limit))
+	return seq, err
+}
+
+// Since returns the recorded messages of a namespace's room after the "since" sequence number,
+// it completes the `neffos.HistoryStore` interface.
+func (exc *StackExchange) Since(namespace, room string, since uint64) ([]neffos.HistoryEntry, error) {
+	var items [][]byte
+	if err := exc.pool.Do(radix.Cmd(&items, "LRANGE", exc.getHistoryKey(namespace, room), "0", "-1")); err != nil {
+		return nil, err
+	}
+
+	var entries []neffos.HistoryEntry
+	for _, item := range items {
+		parts := bytes.SplitN(item, []byte(";"), 4)
+		if len(parts) != 4 {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(string(parts[0]), 10, 64)
+		if err != nil || seq <= since {
+			continue
+		}
+
+		millis, _ := strconv.ParseInt(string(parts[1]), 10, 64)
+
+		var typ neffos.MessageType = neffos.TextMessage
+		if string(parts[2]) == "1" {
+			typ = neffos.BinaryMessage
+		}
+
+		entries = append(entries, neffos.HistoryEntry{
+			Seq:     seq,
+			Time:    time.UnixMilli(millis),
+			Message: neffos.DeserializeMessage(typ, parts[3], false, false),
+		})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,387 @@
+package redis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// fakePubSub is a `radix.PubSubConn` which counts the subscriptions of each channel.
+type fakePubSub struct {
+	subscriptions map[string]int
+	mu            sync.Mutex
+}
+
+var _ radix.PubSubConn = (*fakePubSub)(nil)
+
+func (p *fakePubSub) Subscribe(msgCh chan<- radix.PubSubMessage, channels ...string) error {
+	p.mu.Lock()
+	for _, channel := range channels {
+		p.subscriptions[channel]++
+	}
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *fakePubSub) Unsubscribe(msgCh chan<- radix.PubSubMessage, channels ...string) error {
+	p.mu.Lock()
+	for _, channel := range channels {
+		p.subscriptions[channel]--
+	}
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *fakePubSub) PSubscribe(msgCh chan<- radix.PubSubMessage, patterns ...string) error {
+	return p.Subscribe(msgCh, patterns...)
+}
+
+func (p *fakePubSub) PUnsubscribe(msgCh chan<- radix.PubSubMessage, patterns ...string) error {
+	return p.Unsubscribe(msgCh, patterns...)
+}
+
+func (p *fakePubSub) Ping() error  { return nil }
+func (p *fakePubSub) Close() error { return nil }
+
+// expectSubscriptions waits for the subscriptions count of the "channel" to be the "expected" one.
+func (p *fakePubSub) expectSubscriptions(t *testing.T, channel string, expected int) {
+	t.Helper()
+
+	var got int
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		p.mu.Lock()
+		got = p.subscriptions[channel]
+		p.mu.Unlock()
+
+		if got == expected || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	if got != expected {
+		t.Fatalf("expected %d subscriptions of the channel %q but got %d", expected, channel, got)
+	}
+}
+
+// newMultiplexed returns a `Config.Multiplex` StackExchange of the "pubSub", without a redis pool.
+func newMultiplexed(pubSub radix.PubSubConn, queueSize int) *StackExchange {
+	exc := &StackExchange{
+		channel:       "neffos",
+		subscribers:   make(map[*neffos.Conn]*subscriber),
+		addSubscriber: make(chan *subscriber),
+		delSubscriber: make(chan closeAction),
+		subscribe:     make(chan subscribeAction),
+		unsubscribe:   make(chan unsubscribeAction),
+	}
+	exc.multiplex(pubSub, queueSize)
+	go exc.run()
+
+	return exc
+}
+
+func TestMultiplexDispatch(t *testing.T) {
+	var (
+		namespace = "default"
+		pubSub    = &fakePubSub{subscriptions: make(map[string]int)}
+		exc       = newMultiplexed(pubSub, 8)
+		server    = neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		received  = make(chan string, 10)
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"chat": func(c *neffos.NSConn, msg neffos.Message) error {
+					received <- c.Conn.ID() + ":" + string(msg.Body)
+					return nil
+				},
+			},
+		}
+	)
+
+	server.IDGenerator = func(w http.ResponseWriter, r *http.Request) string {
+		return r.URL.Query().Get("id")
+	}
+	if err := server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer func() {
+		server.Close()
+		httpServer.Close()
+	}()
+
+	connect := func(id string) *neffos.NSConn {
+		client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1)+"?id="+id, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		ns, err := client.Connect(context.TODO(), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ns
+	}
+
+	expect := func(values ...string) {
+		t.Helper()
+
+		got := make(map[string]bool)
+		for range values {
+			select {
+			case v := <-received:
+				got[v] = true
+			case <-time.After(time.Second):
+				t.Fatalf("expected the messages %v but got %v", values, got)
+			}
+		}
+
+		for _, v := range values {
+			if !got[v] {
+				t.Fatalf("expected the message %q but got %v", v, got)
+			}
+		}
+
+		select {
+		case v := <-received:
+			t.Fatalf("expected no more messages but got %q", v)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	publish := func(channel, to, body string) {
+		msg := neffos.Message{Namespace: namespace, To: to, Event: "chat", Body: []byte(body)}
+		exc.msgCh <- radix.PubSubMessage{Type: "message", Channel: channel, Message: msg.Serialize()}
+	}
+
+	var (
+		namespaceChannel = exc.getChannel(namespace, "", "")
+		conn1Channel     = exc.getChannel("", "", "conn1")
+		conn2Channel     = exc.getChannel("", "", "conn2")
+	)
+
+	ns1 := connect("conn1")
+	ns2 := connect("conn2")
+
+	// the namespace channel is subscribed once, for both connections.
+	pubSub.expectSubscriptions(t, namespaceChannel, 1)
+	pubSub.expectSubscriptions(t, conn1Channel, 1)
+	pubSub.expectSubscriptions(t, conn2Channel, 1)
+
+	publish(namespaceChannel, "", "all")
+	expect("conn1:all", "conn2:all")
+
+	publish(conn2Channel, "conn2", "direct")
+	expect("conn2:direct")
+
+	// a reply to a waiting Ask is not written to the connections.
+	reply := make(chan neffos.Message, 1)
+	exc.mu.Lock()
+	exc.waiters[namespaceChannel] = reply
+	exc.mu.Unlock()
+
+	publish(namespaceChannel, "", "reply")
+	select {
+	case msg := <-reply:
+		if expected, got := "reply", string(msg.Body); expected != got {
+			t.Fatalf("expected the reply %q but got %q", expected, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the reply to be sent to the waiting Ask")
+	}
+	expect()
+
+	exc.mu.Lock()
+	delete(exc.waiters, namespaceChannel)
+	exc.mu.Unlock()
+
+	// the namespace channel is unsubscribed after the last connection's disconnect.
+	if err := ns1.Disconnect(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	pubSub.expectSubscriptions(t, namespaceChannel, 1)
+
+	publish(namespaceChannel, "", "rest")
+	expect("conn2:rest")
+
+	if err := ns2.Disconnect(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	pubSub.expectSubscriptions(t, namespaceChannel, 0)
+
+	// the connection's channel is unsubscribed after its close.
+	ns1.Conn.Close()
+	pubSub.expectSubscriptions(t, conn1Channel, 0)
+	pubSub.expectSubscriptions(t, conn2Channel, 1)
+}
+
+func TestMultiplexSlowConsumer(t *testing.T) {
+	var (
+		pubSub = &fakePubSub{subscriptions: make(map[string]int)}
+		exc    = newMultiplexed(pubSub, 1)
+		// the stack exchange is not used by the server,
+		// so the subscriber is added without a goroutine which writes its queue.
+		server = neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{"default": neffos.Events{}})
+		conns  = make(chan *neffos.Conn, 1)
+	)
+
+	server.OnConnect = func(c *neffos.Conn) error {
+		conns <- c
+		return nil
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer func() {
+		server.Close()
+		httpServer.Close()
+	}()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1), neffos.Namespaces{"default": neffos.Events{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c := <-conns
+	sub := &subscriber{conn: c, channels: make(map[string]struct{}), queue: make(chan []byte, 1)}
+	exc.addSubscriber <- sub
+
+	channel := exc.getChannel("", "", c.ID())
+	pubSub.expectSubscriptions(t, channel, 1)
+
+	// the dispatch is not blocked by the full queue, the slow connection is closed.
+	msg := neffos.Message{Namespace: "default", To: c.ID(), Event: "chat"}
+	for i := 0; i < 3; i++ {
+		select {
+		case exc.msgCh <- radix.PubSubMessage{Type: "message", Channel: channel, Message: msg.Serialize()}:
+		case <-time.After(time.Second):
+			t.Fatal("expected the dispatch to not be blocked by a slow connection")
+		}
+	}
+
+	for deadline := time.Now().Add(time.Second); !c.IsClosed(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the slow connection to be closed")
+		}
+	}
+
+	if expected, got := 1, len(sub.queue); expected != got {
+		t.Fatalf("expected %d queued message but got %d", expected, got)
+	}
+
+	exc.OnDisconnect(c)
+	pubSub.expectSubscriptions(t, channel, 0)
+
+	if _, ok := <-sub.queue; !ok {
+		t.Fatal("expected the queued message before the close of the queue")
+	}
+	if _, ok := <-sub.queue; ok {
+		t.Fatal("expected the queue to be closed")
+	}
+}
+
+// stubPublish sets a pool to the "exc" which its PUBLISH commands are received by its subscription connection,
+// as a redis server would do for the subscribed channels.
+func stubPublish(t *testing.T, exc *StackExchange) {
+	t.Helper()
+
+	pool, err := radix.NewPool("tcp", "stub", 1,
+		radix.PoolPipelineWindow(0, 0),
+		radix.PoolConnFunc(func(network, addr string) (radix.Conn, error) {
+			return radix.Stub(network, addr, func(args []string) interface{} {
+				if len(args) == 3 && args[0] == "PUBLISH" {
+					exc.msgCh <- radix.PubSubMessage{Type: "message", Channel: args[1], Message: []byte(args[2])}
+					return 1
+				}
+
+				return "OK"
+			}), nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	exc.pool = pool
+}
+
+func TestMultiplexAsk(t *testing.T) {
+	var (
+		namespace = "default"
+		pubSub    = &fakePubSub{subscriptions: make(map[string]int)}
+		exc       = newMultiplexed(pubSub, 8)
+		server    = neffos.New(gorilla.DefaultUpgrader, neffos.Namespaces{namespace: neffos.Events{}})
+		events    = neffos.Namespaces{
+			namespace: neffos.Events{
+				"ask": func(c *neffos.NSConn, msg neffos.Message) error {
+					return neffos.Reply(append([]byte("reply:"), msg.Body...))
+				},
+			},
+		}
+	)
+
+	stubPublish(t, exc)
+	if err := server.UseStackExchange(exc); err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer func() {
+		server.Close()
+		httpServer.Close()
+	}()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, strings.Replace(httpServer.URL, "http", "ws", 1), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err = client.Connect(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+	pubSub.expectSubscriptions(t, exc.getChannel(namespace, "", ""), 1)
+
+	// the ask is published to the connection and its reply is published back to the waiter.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, err := server.Ask(ctx, neffos.Message{Namespace: namespace, Event: "ask", Body: []byte("ping")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "reply:ping", string(resp.Body); expected != got {
+		t.Fatalf("expected the reply %q but got %q", expected, got)
+	}
+
+	// the ask of a server of a previous version, which adds the marker to the first digit of the wait token,
+	// is replied back to its token too.
+	legacy := "R" + strconv.FormatInt(time.Now().UnixNano(), 10)[1:]
+	reply := make(chan neffos.Message, 1)
+	exc.mu.Lock()
+	exc.waiters[legacy] = reply
+	exc.mu.Unlock()
+
+	ask := neffos.Message{Namespace: namespace, Event: "ask", Body: []byte("legacy")}
+	exc.msgCh <- radix.PubSubMessage{Type: "message", Channel: exc.getChannel(namespace, "", ""), Message: append([]byte(legacy), ask.Serialize()...)}
+
+	select {
+	case msg := <-reply:
+		if expected, got := "reply:legacy", string(msg.Body); expected != got {
+			t.Fatalf("expected the reply %q but got %q", expected, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the reply to the previous version's ask")
+	}
+}
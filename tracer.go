@@ -0,0 +1,103 @@
+package neffos
+
+import (
+	"context"
+)
+
+// The operations which a `Tracer` starts spans for.
+const (
+	// SpanHandlePayload is the operation of handling an incoming message, see `Conn.HandlePayload`.
+	SpanHandlePayload = "neffos.HandlePayload"
+	// SpanConnAsk is the operation of a `Conn.Ask` call.
+	SpanConnAsk = "neffos.Conn.Ask"
+	// SpanServerAsk is the operation of a `Server.Ask` call.
+	SpanServerAsk = "neffos.Server.Ask"
+	// SpanServerBroadcast is the operation of a `Server.Broadcast` call.
+	SpanServerBroadcast = "neffos.Server.Broadcast"
+	// SpanStackExchangePublish is the operation of a `StackExchange.Publish` call.
+	SpanStackExchangePublish = "neffos.StackExchange.Publish"
+)
+
+// Tracer can be optionally registered to a `Server` (see `Server.Tracer`)
+// and to a `Client` (see `Client.Tracer`) to trace the messages across
+// the connections and the servers of a `StackExchange`.
+// The trace context is carried by the messages' `Message.Header`.
+//
+// The incoming message's trace context is available to the event callbacks
+// through the `Message.Context` and outgoing messages carry the trace context
+// of their `Message.Context`, see `Message.WithContext`.
+//
+// See the neffos/tracing subpackage for an OpenTelemetry implementation.
+type Tracer interface {
+	// Start starts a span of an "operation" (see `SpanHandlePayload` and e.t.c.), child of the "ctx",
+	// and returns a context which carries the span and a function which ends it.
+	Start(ctx context.Context, operation string, msg Message) (context.Context, func(err error))
+	// Inject writes the trace context of the "ctx" to the "header".
+	Inject(ctx context.Context, header map[string]string)
+	// Extract returns a copy of the "ctx" which carries the trace context of the "header".
+	Extract(ctx context.Context, header map[string]string) context.Context
+}
+
+// tracer returns the server's or client's `Tracer`, if any.
+func (c *Conn) tracer() Tracer {
+	if c.server != nil {
+		return c.server.Tracer
+	}
+
+	if c.client != nil {
+		return c.client.Tracer
+	}
+
+	return nil
+}
+
+// injectTrace returns the header of the "msg" including the trace context of its context, if any.
+// The message's header is never modified, it may be shared across connections.
+func (c *Conn) injectTrace(msg Message) map[string]string {
+	t := c.tracer()
+	if t == nil || msg.ctx == nil {
+		return msg.Header
+	}
+
+	return injectHeader(t, msg.ctx, msg.Header)
+}
+
+func injectHeader(t Tracer, ctx context.Context, header map[string]string) map[string]string {
+	h := make(map[string]string, len(header)+2)
+	for k, v := range header {
+		h[k] = v
+	}
+
+	t.Inject(ctx, h)
+	if len(h) == 0 {
+		return nil
+	}
+
+	return h
+}
+
+// startSpan starts a span of an "operation" for the "msg", child of the message's context
+// or of the "ctx" if the message has no context, the message's context is set to the span's one.
+// It returns a no-op end function if a `Tracer` is not registered.
+func startSpan(t Tracer, ctx context.Context, operation string, msg *Message) func(error) {
+	if t == nil {
+		return noopEndSpan
+	}
+
+	if msg.ctx != nil || ctx == nil {
+		ctx = msg.Context()
+	}
+
+	ctx, end := t.Start(ctx, operation, *msg)
+	msg.ctx = ctx
+	return end
+}
+
+func noopEndSpan(error) {}
+
+// WithTracer is a `ClientOption` which sets the `Client.Tracer`.
+func WithTracer(tracer Tracer) ClientOption {
+	return func(c *Client) {
+		c.Tracer = tracer
+	}
+}
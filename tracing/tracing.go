@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/kataras/neffos"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the OpenTelemetry tracer.
+const instrumentationName = "github.com/kataras/neffos/tracing"
+
+// Config is used on the `New` package-level function.
+type Config struct {
+	// TracerProvider is the provider of the spans' tracer.
+	// Defaults to the global `otel.GetTracerProvider()`.
+	TracerProvider trace.TracerProvider
+	// Propagator writes and reads the trace context to and from the messages' header.
+	// Defaults to the W3C Trace Context and Baggage propagators.
+	Propagator propagation.TextMapPropagator
+}
+
+// Tracer is a `neffos.Tracer` which traces the messages
+// of a neffos server or client with OpenTelemetry spans.
+//
+// Usage:
+// t := tracing.New(tracing.Config{})
+// t.Instrument(server)
+// OR
+// neffos.Dial(ctx, dialer, url, events, neffos.WithTracer(t))
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+var _ neffos.Tracer = (*Tracer)(nil)
+
+// New returns a new `Tracer` based on the "cfg".
+func New(cfg Config) *Tracer {
+	if cfg.TracerProvider == nil {
+		cfg.TracerProvider = otel.GetTracerProvider()
+	}
+
+	if cfg.Propagator == nil {
+		cfg.Propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	}
+
+	return &Tracer{
+		tracer:     cfg.TracerProvider.Tracer(instrumentationName),
+		propagator: cfg.Propagator,
+	}
+}
+
+// Instrument registers the tracer as the "server"'s `neffos.Server.Tracer`.
+// It should be called before the server starts to accept connections.
+func (t *Tracer) Instrument(server *neffos.Server) *Tracer {
+	server.Tracer = t
+	return t
+}
+
+// spanKind returns the kind of the span of an "operation".
+func spanKind(operation string) trace.SpanKind {
+	switch operation {
+	case neffos.SpanHandlePayload:
+		return trace.SpanKindConsumer
+	case neffos.SpanConnAsk, neffos.SpanServerAsk:
+		return trace.SpanKindClient
+	case neffos.SpanServerBroadcast, neffos.SpanStackExchangePublish:
+		return trace.SpanKindProducer
+	default:
+		return trace.SpanKindInternal
+	}
+}
+
+// Start completes the `neffos.Tracer` interface.
+func (t *Tracer) Start(ctx context.Context, operation string, msg neffos.Message) (context.Context, func(err error)) {
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", "neffos"),
+		attribute.String("neffos.namespace", msg.Namespace),
+		attribute.String("neffos.event", msg.Event),
+	}
+
+	if msg.Room != "" {
+		attrs = append(attrs, attribute.String("neffos.room", msg.Room))
+	}
+
+	ctx, span := t.tracer.Start(ctx, operation, trace.WithSpanKind(spanKind(operation)), trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}
+}
+
+// Inject completes the `neffos.Tracer` interface.
+func (t *Tracer) Inject(ctx context.Context, header map[string]string) {
+	t.propagator.Inject(ctx, propagation.MapCarrier(header))
+}
+
+// Extract completes the `neffos.Tracer` interface.
+func (t *Tracer) Extract(ctx context.Context, header map[string]string) context.Context {
+	return t.propagator.Extract(ctx, propagation.MapCarrier(header))
+}
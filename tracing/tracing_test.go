@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kataras/neffos"
+	"github.com/kataras/neffos/gorilla"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	var (
+		namespace      = "default"
+		handlerTraceID = make(chan trace.TraceID, 1)
+		events         = neffos.Namespaces{
+			namespace: neffos.Events{
+				"echo": func(c *neffos.NSConn, msg neffos.Message) error {
+					handlerTraceID <- trace.SpanContextFromContext(msg.Context()).TraceID()
+					return neffos.Reply(msg.Body)
+				},
+			},
+		}
+	)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := New(Config{TracerProvider: provider})
+
+	server := neffos.New(gorilla.DefaultUpgrader, events)
+	tracer.Instrument(server)
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client, err := neffos.Dial(context.TODO(), gorilla.DefaultDialer, "ws"+strings.TrimPrefix(httpServer.URL, "http"), events, neffos.WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c, err := client.Connect(context.TODO(), namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	traceID := parent.SpanContext().TraceID()
+
+	if _, err = c.Ask(ctx, "echo", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	select {
+	case got := <-handlerTraceID:
+		if got != traceID {
+			t.Fatalf("expected the server's event callback to continue the trace %s but got %s", traceID, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the event callback was not called")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	found := make(map[string]bool)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			found[span.Name()] = true
+		}
+	}
+
+	for _, name := range []string{neffos.SpanConnAsk, neffos.SpanHandlePayload} {
+		if !found[name] {
+			t.Fatalf("expected a %q span of the trace but got: %v", name, found)
+		}
+	}
+}